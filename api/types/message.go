@@ -119,3 +119,12 @@ type WizMessageResponse struct {
 	Result WizMessageResult `json:"result,omitempty"`
 	Error  WizMessageError  `json:"error,omitempty"`
 }
+
+// WizPushMessage represent a message sent by the device on its own, such as 'syncPilot' or 'firstBeat'
+type WizPushMessage struct {
+	Method string `json:"method"`
+	Id     int    `json:"id,omitempty"`
+	Env    string `json:"env,omitempty"`
+
+	Params WizMessageResult `json:"params,omitempty"`
+}
@@ -0,0 +1,103 @@
+package types
+
+// PilotMode represents the way a device is producing its light
+type PilotMode string
+
+const (
+	PilotModeOff     PilotMode = "off"     // PilotModeOff is set when the device is turned off
+	PilotModeRgb     PilotMode = "rgb"     // PilotModeRgb is set when a color is set with r, g, b (and maybe c, w)
+	PilotModeCct     PilotMode = "cct"     // PilotModeCct is set when a white temperature is set with temp, or c and w
+	PilotModeScene   PilotMode = "scene"   // PilotModeScene is set when a scene or a rhythm is playing
+	PilotModeWhite   PilotMode = "white"   // PilotModeWhite is set when a dimmable white device is just on
+	PilotModeUnknown PilotMode = "unknown" // PilotModeUnknown is set when the device did not report enough fields
+)
+
+// The following structs are the typed counterparts of WizMessageResult, one per method.
+// Fields are pointers, so a field the device did not report (nil) is distinguishable from a zero value
+
+// PilotState represents the result of 'getPilot' and the params of 'syncPilot' messages
+type PilotState struct {
+	Mac  string `json:"mac,omitempty"`  // Mac from the bulb
+	Src  string `json:"src,omitempty"`  // Src of the state change
+	Rssi *int   `json:"rssi,omitempty"` // Rssi is WiFi signal strength, in negative dBm
+
+	State      *bool `json:"state,omitempty"`      // State is status of the Device : true if ON, false if OFF
+	SceneId    *int  `json:"sceneId,omitempty"`    // SceneId represents current scene by id
+	SchdPsetId *int  `json:"schdPsetId,omitempty"` // SchdPsetId represents current rhythm by id
+	Speed      *int  `json:"speed,omitempty"`      // Speed represents the effect changing speed (10-200)
+	Ratio      *int  `json:"ratio,omitempty"`      // Ratio represents the ratio between the up and down light (1-100)
+
+	R    *int `json:"r,omitempty"`    // (0-255)
+	G    *int `json:"g,omitempty"`    // (0-255)
+	B    *int `json:"b,omitempty"`    // (0-255)
+	C    *int `json:"c,omitempty"`    // C represents the value of the cold white led (0-255)
+	W    *int `json:"w,omitempty"`    // W represents the value of the warm white led (0-255)
+	Temp *int `json:"temp,omitempty"` // Temp represents the color temperature in kelvin
+
+	Dimming *int `json:"dimming,omitempty"` // Dimming represents the value of the brightness (10-100)
+}
+
+// Mode return the way the device is producing its light, derived from the reported fields
+func (p PilotState) Mode() PilotMode {
+
+	switch {
+	case p.State != nil && !*p.State:
+		return PilotModeOff
+
+	case (p.SceneId != nil && *p.SceneId > 0) || (p.SchdPsetId != nil && *p.SchdPsetId > 0):
+		return PilotModeScene
+
+	case p.R != nil || p.G != nil || p.B != nil:
+		return PilotModeRgb
+
+	case (p.Temp != nil && *p.Temp > 0) || p.C != nil || p.W != nil:
+		return PilotModeCct
+
+	case p.State != nil && p.Dimming != nil:
+		return PilotModeWhite
+	}
+
+	return PilotModeUnknown
+}
+
+// SystemConfig represents the result of 'getSystemConfig'
+type SystemConfig struct {
+	Mac        string `json:"mac,omitempty"`
+	HomeId     *int   `json:"homeId,omitempty"`
+	RoomId     *int   `json:"roomId,omitempty"`
+	GroupId    *int   `json:"groupId,omitempty"`
+	Rgn        string `json:"rgn,omitempty"` // Rgn represents the region in the world. I.E: 'eu'
+	ModuleName string `json:"moduleName,omitempty"`
+	FwVersion  string `json:"fwVersion,omitempty"`
+	Ping       *int   `json:"ping,omitempty"`
+	DrvConf    []int  `json:"drvConf,omitempty"`
+	WhiteRange []int  `json:"whiteRange,omitempty"` // WhiteRange represents the white temperature range on old firmwares
+}
+
+// ModelConfig represents the result of 'getModelConfig'
+type ModelConfig struct {
+	Ps           *int  `json:"ps,omitempty"`
+	PwmFreq      *int  `json:"pwmFreq,omitempty"`
+	PwmRange     []int `json:"pwmRange,omitempty"`
+	Wcr          *int  `json:"wcr,omitempty"`
+	Nowc         *int  `json:"nowc,omitempty"`
+	CctRange     []int `json:"cctRange,omitempty"` // CctRange represents the white temperature range advertised to the user (new)
+	ExtRange     []int `json:"extRange,omitempty"` // ExtRange represents the white temperature range advertised to the user (old)
+	RenderFactor []int `json:"renderFactor,omitempty"`
+}
+
+// UserConfig represents the result of 'getUserConfig'
+type UserConfig struct {
+	FadeIn     *int  `json:"fadeIn,omitempty"`
+	FadeOut    *int  `json:"fadeOut,omitempty"`
+	DftDim     *int  `json:"dftDim,omitempty"`
+	OpMode     *int  `json:"opMode,omitempty"`
+	Po         *bool `json:"po,omitempty"`
+	MinDimming *int  `json:"minDimming,omitempty"`
+	TapSensor  *int  `json:"tapSensor,omitempty"`
+}
+
+// DevInfo represents the result of 'getDevInfo'
+type DevInfo struct {
+	DevMac string `json:"devMac,omitempty"`
+}
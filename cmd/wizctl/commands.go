@@ -0,0 +1,655 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	wizgotypes "github.com/achetronic/wizgo/api/types"
+	"github.com/achetronic/wizgo/pkg/wizgo"
+)
+
+const (
+	// Error messages
+	ArgumentsErrorMessage     = "expected %s"
+	InvalidNumberErrorMessage = "invalid number '%s'"
+	UnknownSceneErrorMessage  = "unknown scene '%s'"
+)
+
+// Command represents a subcommand of wizctl
+type Command struct {
+	Name        string
+	Arguments   string // Arguments shown in the usage. I.E: '<r> <g> <b>'
+	Description string
+	Run         func(ctx context.Context, env *Environment, flags *CommandFlags, args []string) error
+}
+
+// CommandFlags represents the flags shared by the subcommands
+type CommandFlags struct {
+	Targets    stringList
+	Brightness int
+	Speed      int
+	DryRun     bool
+}
+
+// stringList is a flag that can be given several times
+type stringList []string
+
+func (s *stringList) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringList) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+// ActionResult represents the outcome of a command on one device
+type ActionResult struct {
+	Target  string `json:"target"`
+	Address string `json:"address"`
+	Mac     string `json:"mac,omitempty"`
+	Ok      bool   `json:"ok"`
+	Error   string `json:"error,omitempty"`
+}
+
+// DeviceStatus represents the state of one device
+type DeviceStatus struct {
+	Target  string                 `json:"target"`
+	Address string                 `json:"address"`
+	Mac     string                 `json:"mac,omitempty"`
+	Mode    wizgotypes.PilotMode   `json:"mode,omitempty"`
+	Pilot   *wizgotypes.PilotState `json:"pilot,omitempty"`
+	Error   string                 `json:"error,omitempty"`
+}
+
+// DeviceConfigReport represents the configuration of one device
+type DeviceConfigReport struct {
+	Target       string                   `json:"target"`
+	Address      string                   `json:"address"`
+	SystemConfig *wizgotypes.SystemConfig `json:"systemConfig,omitempty"`
+	ModelConfig  *wizgotypes.ModelConfig  `json:"modelConfig,omitempty"`
+	UserConfig   *wizgotypes.UserConfig   `json:"userConfig,omitempty"`
+	Capabilities *wizgo.Capabilities      `json:"capabilities,omitempty"`
+	Error        string                   `json:"error,omitempty"`
+}
+
+// WatchEvent represents a state change printed by the watch command
+type WatchEvent struct {
+	Time   time.Time             `json:"time"`
+	Target string                `json:"target"`
+	Ip     string                `json:"ip"`
+	Mac    string                `json:"mac"`
+	Method string                `json:"method"`
+	Pilot  wizgotypes.PilotState `json:"pilot"`
+}
+
+// SceneDeviceResult represents the outcome of applying a scene file on one device
+type SceneDeviceResult struct {
+	wizgo.SceneDeviceReport
+	Error string `json:"error,omitempty"`
+}
+
+// SceneResult represents the outcome of applying a scene file
+type SceneResult struct {
+	Scene   string              `json:"scene"`
+	DryRun  bool                `json:"dryRun,omitempty"`
+	Devices []SceneDeviceResult `json:"devices"`
+}
+
+// Commands lists all the subcommands, in the order they are shown in the usage
+var Commands = []Command{
+	{Name: "discover", Description: "look for devices on the network", Run: runDiscover},
+	{Name: "status", Description: "show the current state of the devices", Run: runStatus},
+	{Name: "on", Description: "turn on the devices", Run: runOn},
+	{Name: "off", Description: "turn off the devices", Run: runOff},
+	{Name: "color", Arguments: "<r> <g> <b> | <color>", Description: "set a color (3 x 0-255, hex code, CSS name or '2700K')", Run: runColor},
+	{Name: "temp", Arguments: "<kelvin>", Description: "set a white temperature", Run: runTemp},
+	{Name: "dim", Arguments: "<brightness>", Description: "set the brightness (10-100)", Run: runDim},
+	{Name: "scene", Arguments: "<id|name>", Description: "play a scene", Run: runScene},
+	{Name: "pulse", Description: "send a pulse of light to find the devices", Run: runPulse},
+	{Name: "config", Description: "show the configuration and capabilities of the devices", Run: runConfig},
+	{Name: "apply", Arguments: "<file>", Description: "bring the devices to the state described in a YAML or JSON scene file", Run: runApply},
+	{Name: "watch", Description: "print the state changes pushed by the devices until interrupted", Run: runWatch},
+}
+
+// runDiscover prints the devices found on the network
+func runDiscover(ctx context.Context, env *Environment, flags *CommandFlags, args []string) (err error) {
+
+	if err = env.Discover(ctx); err != nil {
+		return err
+	}
+
+	devices := env.Inventory.Devices()
+
+	table := Table{Headers: []string{"NAME", "MAC", "ADDRESS", "MODULE", "FIRMWARE", "HOME", "ROOM", "GROUP"}}
+	for _, device := range devices {
+		room := strconv.Itoa(device.RoomId)
+		if name, found := env.Config.Rooms[device.RoomId]; found {
+			room = name
+		}
+
+		table.Rows = append(table.Rows, []string{
+			device.Name, device.Mac, device.Address(), device.ModuleName, device.FwVersion,
+			strconv.Itoa(device.HomeId), room, strconv.Itoa(device.GroupId),
+		})
+	}
+
+	if devices == nil {
+		devices = []wizgo.InventoryDevice{}
+	}
+	return env.Print(devices, table)
+}
+
+// runStatus prints the current state of the targets
+func runStatus(ctx context.Context, env *Environment, flags *CommandFlags, args []string) (err error) {
+
+	targets, err := env.Resolve(ctx, flags.Targets)
+	if err != nil {
+		return err
+	}
+
+	statuses := make([]DeviceStatus, len(targets))
+	forEachTarget(targets, func(index int, target Target) {
+		statuses[index] = DeviceStatus{Target: target.Label(), Address: target.Client.Address(), Mac: target.Mac}
+
+		state, err := target.Client.ReadPilotStateContext(ctx)
+		if err != nil {
+			statuses[index].Error = err.Error()
+			return
+		}
+
+		statuses[index].Pilot = &state
+		statuses[index].Mode = state.Mode()
+		if state.Mac != "" {
+			statuses[index].Mac = wizgo.NormalizeMac(state.Mac)
+		}
+	})
+
+	failed := 0
+	table := Table{Headers: []string{"TARGET", "ADDRESS", "MAC", "STATE", "RSSI"}}
+	for _, status := range statuses {
+		if status.Error != "" {
+			failed++
+			table.Rows = append(table.Rows, []string{status.Target, status.Address, status.Mac, status.Error, "-"})
+			continue
+		}
+		table.Rows = append(table.Rows, []string{
+			status.Target, status.Address, status.Mac, describePilot(*status.Pilot), intText(status.Pilot.Rssi),
+		})
+	}
+
+	if err = env.Print(statuses, table); err != nil {
+		return err
+	}
+
+	if failed > 0 {
+		return targetsError(failed, len(statuses))
+	}
+	return nil
+}
+
+// runOn turns on the targets
+func runOn(ctx context.Context, env *Environment, flags *CommandFlags, args []string) (err error) {
+	return runAction(ctx, env, flags, func(ctx context.Context, wizClient *wizgo.WizClient) (wizgotypes.WizMessageResponse, error) {
+		return wizClient.TurnOnContext(ctx)
+	})
+}
+
+// runOff turns off the targets
+func runOff(ctx context.Context, env *Environment, flags *CommandFlags, args []string) (err error) {
+	return runAction(ctx, env, flags, func(ctx context.Context, wizClient *wizgo.WizClient) (wizgotypes.WizMessageResponse, error) {
+		return wizClient.TurnOffContext(ctx)
+	})
+}
+
+// runColor sets a color on the targets, optionally with a brightness. Colors given by hex code, name or
+// temperature are mixed for the capabilities of each device
+func runColor(ctx context.Context, env *Environment, flags *CommandFlags, args []string) (err error) {
+
+	if len(args) == 1 {
+		return runNamedColor(ctx, env, flags, args[0])
+	}
+
+	values, err := parseNumbers(args, 3, "<r> <g> <b> | <color>")
+	if err != nil {
+		return err
+	}
+
+	builder := wizgo.CreatePilotBuilder().Rgb(values[0], values[1], values[2])
+	return runPilot(ctx, env, flags, builder)
+}
+
+// runNamedColor sets a color given by hex code, name or temperature on the targets, optionally with a brightness
+func runNamedColor(ctx context.Context, env *Environment, flags *CommandFlags, text string) (err error) {
+
+	c, err := wizgo.ParseColor(text)
+	if err != nil {
+		return err
+	}
+
+	if flags.Brightness != 0 {
+		if _, err = wizgo.CreatePilotBuilder().Brightness(flags.Brightness).Params(); err != nil {
+			return err
+		}
+	}
+
+	return runAction(ctx, env, flags, func(ctx context.Context, wizClient *wizgo.WizClient) (response wizgotypes.WizMessageResponse, err error) {
+
+		capabilities, err := wizClient.CapabilitiesContext(ctx)
+		if err != nil {
+			return response, err
+		}
+
+		builder := wizgo.CreatePilotBuilderFromColor(c, capabilities)
+		if flags.Brightness != 0 {
+			builder.Brightness(flags.Brightness)
+		}
+		return wizClient.SetPilotContext(ctx, builder)
+	})
+}
+
+// runTemp sets a white temperature on the targets, optionally with a brightness
+func runTemp(ctx context.Context, env *Environment, flags *CommandFlags, args []string) (err error) {
+
+	values, err := parseNumbers(args, 1, "<kelvin>")
+	if err != nil {
+		return err
+	}
+
+	builder := wizgo.CreatePilotBuilder().Temperature(values[0])
+	return runPilot(ctx, env, flags, builder)
+}
+
+// runDim sets the brightness of the targets
+func runDim(ctx context.Context, env *Environment, flags *CommandFlags, args []string) (err error) {
+
+	values, err := parseNumbers(args, 1, "<brightness>")
+	if err != nil {
+		return err
+	}
+
+	builder := wizgo.CreatePilotBuilder().Brightness(values[0])
+	return runPilot(ctx, env, flags, builder)
+}
+
+// runScene plays a scene on the targets, optionally with a speed and a brightness
+func runScene(ctx context.Context, env *Environment, flags *CommandFlags, args []string) (err error) {
+
+	if len(args) != 1 {
+		return errors.New(fmt.Sprintf(ArgumentsErrorMessage, "<id|name>"))
+	}
+
+	sceneId, err := parseScene(args[0])
+	if err != nil {
+		return err
+	}
+
+	builder := wizgo.CreatePilotBuilder().Scene(sceneId)
+	if flags.Speed != 0 {
+		builder.Speed(flags.Speed)
+	}
+	return runPilot(ctx, env, flags, builder)
+}
+
+// runPulse sends a pulse of light to the targets
+func runPulse(ctx context.Context, env *Environment, flags *CommandFlags, args []string) (err error) {
+	return runAction(ctx, env, flags, func(ctx context.Context, wizClient *wizgo.WizClient) (wizgotypes.WizMessageResponse, error) {
+		return wizClient.PulseContext(ctx)
+	})
+}
+
+// runConfig prints the configuration and the capabilities of the targets
+func runConfig(ctx context.Context, env *Environment, flags *CommandFlags, args []string) (err error) {
+
+	targets, err := env.Resolve(ctx, flags.Targets)
+	if err != nil {
+		return err
+	}
+
+	reports := make([]DeviceConfigReport, len(targets))
+	forEachTarget(targets, func(index int, target Target) {
+		report := DeviceConfigReport{Target: target.Label(), Address: target.Client.Address()}
+		defer func() { reports[index] = report }()
+
+		systemConfig, err := target.Client.ReadSystemConfigContext(ctx)
+		if err != nil {
+			report.Error = err.Error()
+			return
+		}
+		report.SystemConfig = &systemConfig
+
+		// Not every firmware knows about the following methods
+		if modelConfig, err := target.Client.ReadModelConfigContext(ctx); err == nil {
+			report.ModelConfig = &modelConfig
+		}
+		if userConfig, err := target.Client.ReadUserConfigContext(ctx); err == nil {
+			report.UserConfig = &userConfig
+		}
+		if capabilities, err := target.Client.CapabilitiesContext(ctx); err == nil {
+			report.Capabilities = &capabilities
+		}
+	})
+
+	failed := 0
+	table := Table{Headers: []string{"TARGET", "ADDRESS", "MODULE", "FIRMWARE", "CLASS", "TEMPERATURE", "FEATURES"}}
+	for _, report := range reports {
+		if report.Error != "" {
+			failed++
+			table.Rows = append(table.Rows, []string{report.Target, report.Address, report.Error, "", "", "", ""})
+			continue
+		}
+
+		row := []string{report.Target, report.Address, report.SystemConfig.ModuleName, report.SystemConfig.FwVersion, "-", "-", "-"}
+		if capabilities := report.Capabilities; capabilities != nil {
+			row[4] = string(capabilities.Class)
+			if capabilities.ColorTemperature {
+				row[5] = fmt.Sprintf("%d-%dK", capabilities.KelvinMin, capabilities.KelvinMax)
+			}
+			row[6] = describeFeatures(*capabilities)
+		}
+		table.Rows = append(table.Rows, row)
+	}
+
+	if err = env.Print(reports, table); err != nil {
+		return err
+	}
+
+	if failed > 0 {
+		return targetsError(failed, len(reports))
+	}
+	return nil
+}
+
+// runWatch prints the state changes pushed by the targets until the context is done.
+// Without targets, all the devices found on the network are watched
+func runWatch(ctx context.Context, env *Environment, flags *CommandFlags, args []string) (err error) {
+
+	var targets []Target
+	if len(flags.Targets) == 0 && env.Config.DefaultTarget == "" {
+		if err = env.Discover(ctx); err != nil {
+			return err
+		}
+		targets, err = env.inventoryTargets(env.Inventory.Devices())
+	} else {
+		targets, err = env.Resolve(ctx, flags.Targets)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Devices push their messages from the same IP they are reached at
+	labels := map[string]string{}
+	for _, target := range targets {
+		host, _, _ := net.SplitHostPort(target.Client.Address())
+		labels[host] = target.Label()
+	}
+
+	listener, err := wizgo.CreateListener(wizgo.ListenerOptions{
+		Address: env.Config.Listener.Address,
+		PhoneIp: env.Config.Listener.PhoneIp,
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, target := range targets {
+		listener.Register(target.Client)
+	}
+
+	go func() {
+		_ = listener.Run(ctx)
+	}()
+
+	for event := range listener.Events() {
+		label, found := labels[event.Ip]
+		if !found {
+			label = event.Ip
+		}
+
+		watchEvent := WatchEvent{
+			Time:   event.ReceivedAt,
+			Target: label,
+			Ip:     event.Ip,
+			Mac:    event.Mac,
+			Method: event.Method,
+			Pilot:  event.Pilot,
+		}
+
+		// Events are printed one per line, so JSON output can be piped to other tools
+		if env.Output == OutputJson {
+			content, err := jsonLine(watchEvent)
+			if err != nil {
+				return err
+			}
+			fmt.Println(content)
+			continue
+		}
+
+		fmt.Printf("%s  %s  %s  %s\n", watchEvent.Time.Format(time.TimeOnly), label, event.Method, describePilot(event.Pilot))
+	}
+
+	if errors.Is(ctx.Err(), context.Canceled) {
+		return nil
+	}
+	return ctx.Err()
+}
+
+// runApply applies a scene file, printing the changes per device. Devices are discovered only when
+// some selector of the scene is not known by the config
+func runApply(ctx context.Context, env *Environment, flags *CommandFlags, args []string) (err error) {
+
+	if len(args) != 1 {
+		return errors.New(fmt.Sprintf(ArgumentsErrorMessage, "<file>"))
+	}
+
+	scene, err := wizgo.LoadSceneFile(args[0])
+	if err != nil {
+		return err
+	}
+
+	options := wizgo.ApplySceneOptions{DryRun: flags.DryRun}
+	report, applyErr := env.Inventory.ApplySceneContext(ctx, scene, options)
+	if errors.Is(applyErr, wizgo.ErrNotFound) {
+		if err = env.Discover(ctx); err != nil {
+			return err
+		}
+		report, applyErr = env.Inventory.ApplySceneContext(ctx, scene, options)
+	}
+
+	if len(report.Devices) == 0 {
+		return applyErr
+	}
+
+	result := SceneResult{Scene: report.Scene, DryRun: report.DryRun, Devices: make([]SceneDeviceResult, len(report.Devices))}
+	table := Table{Headers: []string{"NAME", "MAC", "ADDRESS", "CHANGES", "RESULT"}}
+	failed := 0
+
+	for index, device := range report.Devices {
+		result.Devices[index] = SceneDeviceResult{SceneDeviceReport: device}
+
+		outcome := "unchanged"
+		switch {
+		case device.Err != nil:
+			failed++
+			outcome = device.Err.Error()
+			result.Devices[index].Error = outcome
+		case device.Applied:
+			outcome = "applied"
+		case len(device.Changes) > 0:
+			outcome = "pending"
+		}
+
+		table.Rows = append(table.Rows, []string{device.Name, device.Mac, device.Address, describeChanges(device.Changes), outcome})
+	}
+
+	if err = env.Print(result, table); err != nil {
+		return err
+	}
+
+	// Failures while applying are already printed per device, unlike the reason why the scene was rejected
+	if _, ok := applyErr.(*wizgo.GroupError); ok {
+		return targetsError(failed, len(report.Devices))
+	}
+	return applyErr
+}
+
+// runPilot sends the changes composed in the builder to the targets, adding the brightness when it is given
+func runPilot(ctx context.Context, env *Environment, flags *CommandFlags, builder *wizgo.PilotBuilder) (err error) {
+
+	if flags.Brightness != 0 {
+		builder.Brightness(flags.Brightness)
+	}
+
+	// Invalid values are reported once, before reaching the devices
+	if _, err = builder.Params(); err != nil {
+		return err
+	}
+
+	return runAction(ctx, env, flags, func(ctx context.Context, wizClient *wizgo.WizClient) (wizgotypes.WizMessageResponse, error) {
+		return wizClient.SetPilotContext(ctx, builder)
+	})
+}
+
+// runAction sends the action to all the targets at once and prints the outcome per device
+func runAction(ctx context.Context, env *Environment, flags *CommandFlags, action func(ctx context.Context, wizClient *wizgo.WizClient) (wizgotypes.WizMessageResponse, error)) (err error) {
+
+	targets, err := env.Resolve(ctx, flags.Targets)
+	if err != nil {
+		return err
+	}
+
+	group := wizgo.CreateGroup(clientsOf(targets), wizgo.GroupOptions{})
+	groupResults, groupErr := group.Do(ctx, action)
+
+	results := make([]ActionResult, len(targets))
+	table := Table{Headers: []string{"TARGET", "ADDRESS", "RESULT"}}
+	for index, target := range targets {
+		results[index] = ActionResult{
+			Target:  target.Label(),
+			Address: target.Client.Address(),
+			Mac:     target.Mac,
+			Ok:      groupResults[index].Err == nil,
+		}
+		if groupResults[index].Err != nil {
+			results[index].Error = groupResults[index].Err.Error()
+		}
+		table.Rows = append(table.Rows, []string{results[index].Target, results[index].Address, errorText(groupResults[index].Err)})
+	}
+
+	if err = env.Print(results, table); err != nil {
+		return err
+	}
+
+	if groupErr != nil {
+		return targetsError(len(groupResults.Failed()), len(groupResults))
+	}
+	return nil
+}
+
+// forEachTarget runs the function for every target concurrently and waits for all of them
+func forEachTarget(targets []Target, function func(index int, target Target)) {
+
+	var wg sync.WaitGroup
+	for index, target := range targets {
+		wg.Add(1)
+		go func(index int, target Target) {
+			defer wg.Done()
+			function(index, target)
+		}(index, target)
+	}
+	wg.Wait()
+}
+
+// parseNumbers return the arguments as integers, checking there are as many as expected
+func parseNumbers(args []string, expected int, usage string) (values []int, err error) {
+
+	if len(args) != expected {
+		return values, errors.New(fmt.Sprintf(ArgumentsErrorMessage, usage))
+	}
+
+	for _, arg := range args {
+		value, err := strconv.Atoi(arg)
+		if err != nil {
+			return values, errors.New(fmt.Sprintf(InvalidNumberErrorMessage, arg))
+		}
+		values = append(values, value)
+	}
+
+	return values, nil
+}
+
+// parseScene return the id of the scene given by id or by name, ignoring the case
+func parseScene(selector string) (sceneId int, err error) {
+
+	if sceneId, err = strconv.Atoi(selector); err == nil {
+		return sceneId, nil
+	}
+
+	for id, name := range wizgo.WizScenes {
+		if strings.EqualFold(name, selector) {
+			return id, nil
+		}
+	}
+
+	return sceneId, errors.New(fmt.Sprintf(UnknownSceneErrorMessage, selector))
+}
+
+// describeFeatures return the features supported by the device, separated by commas
+func describeFeatures(capabilities wizgo.Capabilities) string {
+
+	features := map[string]bool{
+		"color":       capabilities.Color,
+		"temperature": capabilities.ColorTemperature,
+		"brightness":  capabilities.Brightness,
+		"effects":     capabilities.Effects,
+		"fan":         capabilities.Fan,
+		"power":       capabilities.PowerMetering,
+		"dual-head":   capabilities.DualHead,
+	}
+
+	var supported []string
+	for feature, enabled := range features {
+		if enabled {
+			supported = append(supported, feature)
+		}
+	}
+	sort.Strings(supported)
+
+	if len(supported) == 0 {
+		return "-"
+	}
+	return strings.Join(supported, ",")
+}
+
+// newCommandFlags return the flag set of the command, bound to the given flags
+func newCommandFlags(command Command, flags *CommandFlags) *flag.FlagSet {
+
+	flagSet := flag.NewFlagSet(command.Name, flag.ContinueOnError)
+	flagSet.Var(&flags.Targets, "t", "target device: IP[:port], MAC, name or 'room:<name|id>'. Can be repeated")
+
+	switch command.Name {
+	case "color", "temp", "scene":
+		flagSet.IntVar(&flags.Brightness, "dim", 0, "brightness to set at the same time (10-100)")
+	}
+	if command.Name == "scene" {
+		flagSet.IntVar(&flags.Speed, "speed", 0, "changing speed of the scene (10-200)")
+	}
+	if command.Name == "apply" {
+		flagSet.BoolVar(&flags.DryRun, "dry-run", false, "show the changes without sending them to the devices")
+	}
+
+	flagSet.Usage = func() {
+		fmt.Fprintf(flagSet.Output(), "Usage: wizctl %s [flags] %s\n\n%s\n\nFlags:\n", command.Name, command.Arguments, command.Description)
+		flagSet.PrintDefaults()
+	}
+
+	return flagSet
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// DefaultConfigFile is read when no other config file is given. Relative to the user config directory
+	DefaultConfigFile = "wizgo/wizctl.json"
+)
+
+// Config represents the defaults read from the config file
+type Config struct {
+	Output        string          `json:"output,omitempty"`        // Output format: human or json
+	Timeout       Duration        `json:"timeout,omitempty"`       // Timeout of each request
+	DefaultTarget string          `json:"defaultTarget,omitempty"` // DefaultTarget is used when no target is given
+	Discovery     DiscoveryConfig `json:"discovery,omitempty"`
+	Devices       []DeviceConfig  `json:"devices,omitempty"`
+	Rooms         map[int]string  `json:"rooms,omitempty"` // Rooms gives names to the room ids configured in the WiZ app
+	Listener      ListenerConfig  `json:"listener,omitempty"`
+}
+
+// DiscoveryConfig represents how devices are looked for on the network
+type DiscoveryConfig struct {
+	BroadcastAddresses []string `json:"broadcastAddresses,omitempty"`
+	Interfaces         []string `json:"interfaces,omitempty"`
+	Window             Duration `json:"window,omitempty"`
+}
+
+// DeviceConfig represents a device known beforehand. When Ip is empty, it is looked for on the network
+type DeviceConfig struct {
+	Name string `json:"name"`
+	Mac  string `json:"mac"`
+	Ip   string `json:"ip,omitempty"`
+	Port int    `json:"port,omitempty"`
+}
+
+// ListenerConfig represents how the state changes pushed by the devices are received
+type ListenerConfig struct {
+	Address string `json:"address,omitempty"`
+	PhoneIp string `json:"phoneIp,omitempty"`
+}
+
+// Duration is a time.Duration written as a string in the config file. I.E: "1m30s"
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(content []byte) (err error) {
+	var text string
+	if err = json.Unmarshal(content, &text); err != nil {
+		return err
+	}
+
+	duration, err := time.ParseDuration(text)
+	*d = Duration(duration)
+	return err
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// LoadConfig reads the config file at the given path. When the path is empty, the default config file is read
+// if it exists
+func LoadConfig(path string) (config Config, err error) {
+
+	explicit := path != ""
+	if !explicit {
+		configDir, err := os.UserConfigDir()
+		if err != nil {
+			return config, nil
+		}
+		path = filepath.Join(configDir, DefaultConfigFile)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		if !explicit && errors.Is(err, os.ErrNotExist) {
+			return config, nil
+		}
+		return config, err
+	}
+
+	err = json.Unmarshal(content, &config)
+	return config, err
+}
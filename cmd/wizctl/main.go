@@ -0,0 +1,137 @@
+// wizctl controls WiZ devices from the command line.
+//
+// Usage:
+//
+//	wizctl [global flags] <command> [flags] [arguments]
+//
+// Devices are targeted with -t by IP, MAC, name or room ('room:<name|id>').
+// Names are given to devices and rooms in the config file, which also holds the defaults
+// for the global flags. It is read from the user config directory (I.E: ~/.config/wizgo/wizctl.json)
+// unless another one is given with -config
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/achetronic/wizgo/pkg/wizgo"
+)
+
+const (
+	// Exit codes
+	ExitOk    = 0
+	ExitError = 1
+	ExitUsage = 2
+
+	// Error messages
+	UnknownCommandErrorMessage = "unknown command '%s'"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
+
+// run executes the command line and return the exit code
+func run(arguments []string) int {
+
+	globalFlags := flag.NewFlagSet("wizctl", flag.ContinueOnError)
+	configPath := globalFlags.String("config", "", "path to the config file (default: <user config dir>/"+DefaultConfigFile+")")
+	output := globalFlags.String("o", OutputHuman, "output format: human or json")
+	timeout := globalFlags.Duration("timeout", wizgo.DefaultTimeout, "timeout of each request")
+	globalFlags.Usage = func() { printUsage(globalFlags) }
+
+	if err := globalFlags.Parse(arguments); err != nil {
+		return usageExitCode(err)
+	}
+
+	if globalFlags.NArg() == 0 {
+		printUsage(globalFlags)
+		return ExitUsage
+	}
+
+	config, err := LoadConfig(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error reading the config file: %s\n", err)
+		return ExitError
+	}
+
+	// Flags given on the command line take precedence over the config file
+	setFlags := map[string]bool{}
+	globalFlags.Visit(func(f *flag.Flag) { setFlags[f.Name] = true })
+
+	if !setFlags["o"] && config.Output != "" {
+		*output = config.Output
+	}
+	if !setFlags["timeout"] && config.Timeout != 0 {
+		*timeout = time.Duration(config.Timeout)
+	}
+
+	if *output != OutputHuman && *output != OutputJson {
+		fmt.Fprintf(os.Stderr, UnknownOutputErrorMessage+"\n", *output, OutputHuman, OutputJson)
+		return ExitUsage
+	}
+
+	command, err := findCommand(globalFlags.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		printUsage(globalFlags)
+		return ExitUsage
+	}
+
+	commandFlags := &CommandFlags{}
+	flagSet := newCommandFlags(command, commandFlags)
+	if err = flagSet.Parse(globalFlags.Args()[1:]); err != nil {
+		return usageExitCode(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	env := CreateEnvironment(config, *output, *timeout)
+	defer env.Close()
+
+	if err = command.Run(ctx, env, commandFlags, flagSet.Args()); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+		return ExitError
+	}
+
+	return ExitOk
+}
+
+// usageExitCode return the exit code for an error parsing the flags. Asking for help is not an error
+func usageExitCode(err error) int {
+	if errors.Is(err, flag.ErrHelp) {
+		return ExitOk
+	}
+	return ExitUsage
+}
+
+// findCommand return the command with the given name
+func findCommand(name string) (command Command, err error) {
+	for _, command := range Commands {
+		if command.Name == name {
+			return command, nil
+		}
+	}
+	return command, errors.New(fmt.Sprintf(UnknownCommandErrorMessage, name))
+}
+
+// printUsage writes the usage of wizctl, listing the global flags and the commands
+func printUsage(globalFlags *flag.FlagSet) {
+
+	out := globalFlags.Output()
+	fmt.Fprintf(out, "Usage: wizctl [global flags] <command> [flags] [arguments]\n\nCommands:\n")
+	for _, command := range Commands {
+		fmt.Fprintf(out, "  %-9s %-13s %s\n", command.Name, command.Arguments, command.Description)
+	}
+
+	fmt.Fprintf(out, "\nGlobal flags:\n")
+	globalFlags.PrintDefaults()
+	fmt.Fprintf(out, "\nRun 'wizctl <command> -h' to see the flags of a command\n")
+}
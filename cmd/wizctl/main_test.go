@@ -0,0 +1,371 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	wizgotypes "github.com/achetronic/wizgo/api/types"
+	"github.com/achetronic/wizgo/pkg/emulator"
+	"github.com/achetronic/wizgo/pkg/wizgo"
+)
+
+// startEmulator starts an emulated device that is closed when the test ends
+func startEmulator(t *testing.T, options emulator.Options) *emulator.Emulator {
+	t.Helper()
+
+	device, err := emulator.Start(options)
+	if err != nil {
+		t.Fatalf("error starting the emulator: %s", err)
+	}
+	t.Cleanup(func() { _ = device.Close() })
+
+	return device
+}
+
+// writeFile writes the content into a file of a temporary directory and return its path
+func writeFile(t *testing.T, name string, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("error writing '%s': %s", name, err)
+	}
+	return path
+}
+
+// runWizctl runs the command line, return the exit code and what was written on stdout and stderr
+func runWizctl(t *testing.T, arguments ...string) (code int, stdout string, stderr string) {
+	t.Helper()
+
+	stdoutFile, err := os.CreateTemp(t.TempDir(), "stdout")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	stderrFile, err := os.CreateTemp(t.TempDir(), "stderr")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	originalStdout, originalStderr := os.Stdout, os.Stderr
+	os.Stdout, os.Stderr = stdoutFile, stderrFile
+	code = run(arguments)
+	os.Stdout, os.Stderr = originalStdout, originalStderr
+
+	stdoutContent, _ := os.ReadFile(stdoutFile.Name())
+	stderrContent, _ := os.ReadFile(stderrFile.Name())
+	_, _ = stdoutFile.Close(), stderrFile.Close()
+
+	return code, string(stdoutContent), string(stderrContent)
+}
+
+func TestRun(t *testing.T) {
+
+	desk := startEmulator(t, emulator.Options{Model: emulator.ModelRgb})
+	hall := startEmulator(t, emulator.Options{Model: emulator.ModelDw})
+
+	// Devices are known by the config, and the discovery only looks at an address where nothing answers
+	config := writeFile(t, "wizctl.json", `{
+		"timeout": "500ms",
+		"defaultTarget": "desk",
+		"discovery": {"broadcastAddresses": ["127.0.0.1"], "window": "100ms"},
+		"devices": [
+			{"name": "desk", "mac": "`+desk.Mac()+`", "ip": "`+desk.Host()+`", "port": `+strconv.Itoa(desk.Port())+`},
+			{"name": "hall", "mac": "`+hall.Mac()+`", "ip": "`+hall.Host()+`", "port": `+strconv.Itoa(hall.Port())+`}
+		]
+	}`)
+
+	scene := writeFile(t, "evening.yaml", "name: evening\ndevices:\n  - device: desk\n    pilot: {temp: 2700, dimming: 40}\n")
+
+	tests := []struct {
+		name      string
+		arguments []string
+
+		wantCode   int
+		wantStdout string                                 // wantStdout is a part of the output expected, when set
+		wantStderr string                                 // wantStderr is a part of the errors expected, when set
+		wantParams map[string]wizgotypes.WizMessageParams // wantParams holds the 'setPilot' expected per device name, the rest must get none
+	}{
+		{
+			name:       "status as JSON",
+			arguments:  []string{"-o", "json", "status", "-t", "desk", "-t", "hall"},
+			wantCode:   ExitOk,
+			wantStdout: `"target": "hall"`,
+		},
+		{
+			name:       "status of the default target",
+			arguments:  []string{"status"},
+			wantCode:   ExitOk,
+			wantStdout: "desk",
+		},
+		{
+			name:       "status of a device given by address",
+			arguments:  []string{"status", "-t", desk.Address()},
+			wantCode:   ExitOk,
+			wantStdout: desk.Address(),
+		},
+		{
+			name:       "color with brightness",
+			arguments:  []string{"color", "-t", "desk", "-dim", "50", "255", "0", "0"},
+			wantCode:   ExitOk,
+			wantParams: map[string]wizgotypes.WizMessageParams{"desk": {"r": 255.0, "g": 0.0, "b": 0.0, "dimming": 50.0}},
+		},
+		{
+			name:       "scene by name",
+			arguments:  []string{"scene", "-t", "desk", "-speed", "150", "party"},
+			wantCode:   ExitOk,
+			wantParams: map[string]wizgotypes.WizMessageParams{"desk": {"sceneId": 4.0, "speed": 150.0}},
+		},
+		{
+			name:       "brightness on several devices",
+			arguments:  []string{"dim", "-t", "desk", "-t", "hall", "30"},
+			wantCode:   ExitOk,
+			wantParams: map[string]wizgotypes.WizMessageParams{"desk": {"dimming": 30.0}, "hall": {"dimming": 30.0}},
+		},
+		{
+			name:       "temperature not supported by one of the devices",
+			arguments:  []string{"temp", "-t", "desk", "-t", "hall", "2700"},
+			wantCode:   ExitError,
+			wantStderr: "1 of 2 devices failed",
+			wantParams: map[string]wizgotypes.WizMessageParams{"desk": {"temp": 2700.0}},
+		},
+		{
+			name:       "brightness out of range",
+			arguments:  []string{"dim", "-t", "desk", "5"},
+			wantCode:   ExitError,
+			wantStderr: "error:",
+		},
+		{
+			name:       "missing arguments",
+			arguments:  []string{"color", "-t", "desk", "255", "0"},
+			wantCode:   ExitError,
+			wantStderr: "expected <r> <g> <b> | <color>",
+		},
+		{
+			name:       "scene file in a dry run",
+			arguments:  []string{"-o", "json", "apply", "-dry-run", scene},
+			wantCode:   ExitOk,
+			wantStdout: `"dryRun": true`,
+		},
+		{
+			name:       "scene file",
+			arguments:  []string{"apply", scene},
+			wantCode:   ExitOk,
+			wantStdout: "applied",
+			wantParams: map[string]wizgotypes.WizMessageParams{"desk": {"state": true, "temp": 2700.0, "dimming": 40.0}},
+		},
+		{
+			name:       "unknown device",
+			arguments:  []string{"on", "-t", "garage"},
+			wantCode:   ExitError,
+			wantStderr: "garage",
+		},
+		{
+			name:       "unknown command",
+			arguments:  []string{"blink"},
+			wantCode:   ExitUsage,
+			wantStderr: "unknown command 'blink'",
+		},
+		{
+			name:       "unknown output format",
+			arguments:  []string{"-o", "yaml", "status"},
+			wantCode:   ExitUsage,
+			wantStderr: "unknown output format 'yaml'",
+		},
+		{
+			name:      "help",
+			arguments: []string{"on", "-h"},
+			wantCode:  ExitOk,
+		},
+	}
+
+	devices := map[string]*emulator.Emulator{"desk": desk, "hall": hall}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sent := map[string]int{}
+			for name, device := range devices {
+				sent[name] = len(setPilots(device))
+			}
+
+			code, stdout, stderr := runWizctl(t, append([]string{"-config", config}, test.arguments...)...)
+
+			if code != test.wantCode {
+				t.Errorf("expected the exit code %d, got %d. Errors: %s", test.wantCode, code, stderr)
+			}
+			if !strings.Contains(stdout, test.wantStdout) {
+				t.Errorf("expected '%s' in the output, got: %s", test.wantStdout, stdout)
+			}
+			if !strings.Contains(stderr, test.wantStderr) {
+				t.Errorf("expected '%s' in the errors, got: %s", test.wantStderr, stderr)
+			}
+
+			for name, device := range devices {
+				messages := setPilots(device)[sent[name]:]
+				want, found := test.wantParams[name]
+				if !found {
+					if len(messages) > 0 {
+						t.Errorf("expected nothing sent to '%s', got %v", name, messages[0].Params)
+					}
+					continue
+				}
+				if len(messages) != 1 || !reflect.DeepEqual(messages[0].Params, want) {
+					t.Errorf("expected %v sent to '%s', got %v", want, name, messages)
+				}
+			}
+		})
+	}
+}
+
+func TestRunOutputs(t *testing.T) {
+
+	desk := startEmulator(t, emulator.Options{Model: emulator.ModelTw, FwVersion: "1.28.0"})
+	if err := desk.SetPilotState(wizgotypes.PilotState{Temp: intPointer(3000), Dimming: intPointer(70)}); err != nil {
+		t.Fatalf("error setting the initial state: %s", err)
+	}
+	config := writeFile(t, "wizctl.json", `{"devices": [{"name": "desk", "mac": "`+desk.Mac()+`", "ip": "`+desk.Host()+`", "port": `+strconv.Itoa(desk.Port())+`}]}`)
+
+	t.Run("status", func(t *testing.T) {
+		code, stdout, stderr := runWizctl(t, "-config", config, "-o", "json", "status", "-t", "desk")
+		if code != ExitOk {
+			t.Fatalf("unexpected exit code %d: %s", code, stderr)
+		}
+
+		var statuses []DeviceStatus
+		if err := json.Unmarshal([]byte(stdout), &statuses); err != nil {
+			t.Fatalf("error decoding the output: %s", err)
+		}
+		if len(statuses) != 1 || statuses[0].Target != "desk" || statuses[0].Mac != wizgo.NormalizeMac(desk.Mac()) || statuses[0].Mode != wizgotypes.PilotModeCct {
+			t.Fatalf("expected the status of the desk, got %+v", statuses)
+		}
+		if intText(statuses[0].Pilot.Temp) != "3000" || intText(statuses[0].Pilot.Dimming) != "70" {
+			t.Errorf("expected the desk at 3000K and 70%%, got %+v", statuses[0].Pilot)
+		}
+
+		// The same state is described to humans
+		code, stdout, _ = runWizctl(t, "-config", config, "status", "-t", "desk")
+		if code != ExitOk || !strings.Contains(stdout, "on, 3000K, 70%") {
+			t.Errorf("expected the state described, got: %s", stdout)
+		}
+	})
+
+	t.Run("config", func(t *testing.T) {
+		code, stdout, stderr := runWizctl(t, "-config", config, "-o", "json", "config", "-t", "desk")
+		if code != ExitOk {
+			t.Fatalf("unexpected exit code %d: %s", code, stderr)
+		}
+
+		var reports []DeviceConfigReport
+		if err := json.Unmarshal([]byte(stdout), &reports); err != nil {
+			t.Fatalf("error decoding the output: %s", err)
+		}
+		if len(reports) != 1 || reports[0].SystemConfig == nil || reports[0].Capabilities == nil {
+			t.Fatalf("expected the config of the desk, got %+v", reports)
+		}
+		if reports[0].SystemConfig.FwVersion != "1.28.0" || reports[0].Capabilities.Class != wizgo.BulbClassTw {
+			t.Errorf("expected a TW device with firmware 1.28.0, got %+v", reports[0])
+		}
+
+		code, stdout, _ = runWizctl(t, "-config", config, "config", "-t", "desk")
+		if code != ExitOk || !strings.Contains(stdout, "2700-6500K") || !strings.Contains(stdout, "brightness,effects,temperature") {
+			t.Errorf("expected the temperature range and the features, got: %s", stdout)
+		}
+	})
+
+	t.Run("unreachable device", func(t *testing.T) {
+		desk.SetLossRate(1)
+		defer desk.SetLossRate(0)
+
+		started := time.Now()
+		code, stdout, stderr := runWizctl(t, "-config", config, "-timeout", "200ms", "-o", "json", "status", "-t", "desk")
+		if code != ExitError || !strings.Contains(stderr, "1 of 1 devices failed") {
+			t.Errorf("expected the device reported as failed, got exit code %d: %s", code, stderr)
+		}
+		if !strings.Contains(stdout, `"error"`) {
+			t.Errorf("expected the error of the device in the output, got: %s", stdout)
+		}
+		if elapsed := time.Since(started); elapsed > 2*time.Second {
+			t.Errorf("expected the timeout given honoured, it took %s", elapsed)
+		}
+	})
+}
+
+func TestParseScene(t *testing.T) {
+
+	tests := []struct {
+		selector string
+		want     int
+		wantErr  bool
+	}{
+		{selector: "4", want: 4},
+		{selector: "party", want: 4},
+		{selector: "Warm White", want: 11},
+		{selector: "disco", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.selector, func(t *testing.T) {
+			sceneId, err := parseScene(test.selector)
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("expected an error, got scene %d", sceneId)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if sceneId != test.want {
+				t.Errorf("expected scene %d, got %d", test.want, sceneId)
+			}
+		})
+	}
+}
+
+func TestParseAddress(t *testing.T) {
+
+	tests := []struct {
+		selector string
+		wantHost string
+		wantPort int
+		wantOk   bool
+	}{
+		{selector: "192.168.1.20", wantHost: "192.168.1.20", wantPort: wizgo.DefaultPort, wantOk: true},
+		{selector: "192.168.1.20:40000", wantHost: "192.168.1.20", wantPort: 40000, wantOk: true},
+		{selector: "a8bb50000001"},
+		{selector: "living room"},
+		{selector: "192.168.1.20:port"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.selector, func(t *testing.T) {
+			host, port, ok := parseAddress(test.selector)
+			if ok != test.wantOk {
+				t.Fatalf("expected '%s' taken as an address to be %t", test.selector, test.wantOk)
+			}
+			if ok && (host != test.wantHost || port != test.wantPort) {
+				t.Errorf("expected %s:%d, got %s:%d", test.wantHost, test.wantPort, host, port)
+			}
+		})
+	}
+}
+
+// setPilots return the 'setPilot' messages received by the emulated device
+func setPilots(device *emulator.Emulator) (messages []wizgotypes.WizMessage) {
+	for _, message := range device.Messages() {
+		if message.Method == "setPilot" {
+			messages = append(messages, message)
+		}
+	}
+	return messages
+}
+
+// intPointer return a pointer to the given value
+func intPointer(value int) *int {
+	return &value
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	wizgotypes "github.com/achetronic/wizgo/api/types"
+	"github.com/achetronic/wizgo/pkg/wizgo"
+)
+
+const (
+	// Output formats
+	OutputHuman = "human"
+	OutputJson  = "json"
+
+	// Error messages
+	UnknownOutputErrorMessage = "unknown output format '%s': use '%s' or '%s'"
+)
+
+// Table represents the human output of a command
+type Table struct {
+	Headers []string
+	Rows    [][]string
+}
+
+// Print writes the value as indented JSON, or the table when the output is meant for humans
+func (e *Environment) Print(value interface{}, table Table) error {
+	return printTo(os.Stdout, e.Output, value, table)
+}
+
+// printTo writes the value or the table into the given writer
+func printTo(out io.Writer, output string, value interface{}, table Table) error {
+
+	if output == OutputJson {
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(value)
+	}
+
+	writer := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	if len(table.Headers) > 0 {
+		fmt.Fprintln(writer, strings.Join(table.Headers, "\t"))
+	}
+	for _, row := range table.Rows {
+		fmt.Fprintln(writer, strings.Join(row, "\t"))
+	}
+	return writer.Flush()
+}
+
+// describePilot return a short human description of the state of a device. I.E: 'on, rgb(255,0,0), 80%'
+func describePilot(state wizgotypes.PilotState) string {
+
+	if state.State != nil && !*state.State {
+		return "off"
+	}
+
+	parts := []string{"on"}
+
+	switch state.Mode() {
+	case wizgotypes.PilotModeScene:
+		if state.SceneId != nil && *state.SceneId > 0 {
+			parts = append(parts, fmt.Sprintf("scene %s", sceneName(*state.SceneId)))
+		} else if state.SchdPsetId != nil {
+			parts = append(parts, fmt.Sprintf("rhythm %d", *state.SchdPsetId))
+		}
+		if state.Speed != nil {
+			parts = append(parts, fmt.Sprintf("speed %d", *state.Speed))
+		}
+
+	case wizgotypes.PilotModeRgb:
+		parts = append(parts, fmt.Sprintf("rgb(%s,%s,%s)", intText(state.R), intText(state.G), intText(state.B)))
+
+	case wizgotypes.PilotModeCct:
+		if state.Temp != nil && *state.Temp > 0 {
+			parts = append(parts, fmt.Sprintf("%dK", *state.Temp))
+		} else {
+			parts = append(parts, fmt.Sprintf("c=%s w=%s", intText(state.C), intText(state.W)))
+		}
+	}
+
+	if state.Dimming != nil {
+		parts = append(parts, fmt.Sprintf("%d%%", *state.Dimming))
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+// describeChanges return a short human description of the changes of a device. I.E: 'dimming 100→40, temp 6500→2700'
+func describeChanges(changes []wizgo.PilotChange) string {
+
+	if len(changes) == 0 {
+		return "-"
+	}
+
+	parts := make([]string, 0, len(changes))
+	for _, change := range changes {
+		from := "-"
+		if change.From != nil {
+			from = fmt.Sprintf("%v", change.From)
+		}
+		parts = append(parts, fmt.Sprintf("%s %s→%v", change.Field, from, change.To))
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+// sceneName return the name of the scene with the given id, or the id when it is unknown
+func sceneName(sceneId int) string {
+	if name, found := wizgo.WizScenes[sceneId]; found {
+		return name
+	}
+	return fmt.Sprintf("%d", sceneId)
+}
+
+// intText return the value of an optional field, or '-' when it was not reported
+func intText(value *int) string {
+	if value == nil {
+		return "-"
+	}
+	return fmt.Sprintf("%d", *value)
+}
+
+// errorText return the error message, or 'ok' when there is no error
+func errorText(err error) string {
+	if err == nil {
+		return "ok"
+	}
+	return err.Error()
+}
+
+// jsonLine return the value encoded as JSON in a single line
+func jsonLine(value interface{}) (string, error) {
+	content, err := json.Marshal(value)
+	return string(content), err
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/achetronic/wizgo/pkg/wizgo"
+)
+
+const (
+	// RoomSelectorPrefix marks the targets that select all the devices of a room. I.E: 'room:living room'
+	RoomSelectorPrefix = "room:"
+
+	// Error messages
+	NoTargetErrorMessage = "no target given: use -t or set 'defaultTarget' in the config file"
+)
+
+// Target represents a device selected on the command line
+type Target struct {
+	Name   string // Name of the device, when it is known
+	Mac    string // Mac of the device, when it is known
+	Client *wizgo.WizClient
+}
+
+// Label return the way the target is shown to the user
+func (t Target) Label() string {
+	if t.Name != "" {
+		return t.Name
+	}
+	return t.Client.Address()
+}
+
+// Environment holds what every command needs: the config, the inventory and the output settings
+type Environment struct {
+	Config     Config
+	Output     string
+	Timeout    time.Duration
+	Inventory  *wizgo.Inventory
+	discovered bool
+
+	// directClients are the clients created for targets given by IP, not owned by the inventory
+	directClients []*wizgo.WizClient
+}
+
+// CreateEnvironment creates the inventory and fills it with the devices known by the config
+func CreateEnvironment(config Config, output string, timeout time.Duration) *Environment {
+
+	inventory := wizgo.CreateInventory(wizgo.WizClientOptions{Timeout: timeout})
+
+	for _, device := range config.Devices {
+		inventory.SetDeviceName(device.Mac, device.Name)
+		if device.Ip == "" {
+			continue
+		}
+
+		port := device.Port
+		if port == 0 {
+			port = wizgo.DefaultPort
+		}
+		inventory.Update(wizgo.DiscoveredDevice{Ip: device.Ip, Port: port, Mac: device.Mac})
+	}
+
+	for roomId, name := range config.Rooms {
+		inventory.SetRoomName(roomId, name)
+	}
+
+	return &Environment{
+		Config:    config,
+		Output:    output,
+		Timeout:   timeout,
+		Inventory: inventory,
+	}
+}
+
+// DiscoverOptions return the discovery options set in the config
+func (e *Environment) DiscoverOptions() wizgo.DiscoverOptions {
+	return wizgo.DiscoverOptions{
+		BroadcastAddresses: e.Config.Discovery.BroadcastAddresses,
+		Interfaces:         e.Config.Discovery.Interfaces,
+		Window:             time.Duration(e.Config.Discovery.Window),
+	}
+}
+
+// Discover looks for the devices on the network once, adding them to the inventory
+func (e *Environment) Discover(ctx context.Context) (err error) {
+	if e.discovered {
+		return nil
+	}
+
+	err = e.Inventory.Refresh(ctx, e.DiscoverOptions())
+	if err != nil {
+		return err
+	}
+
+	e.discovered = true
+	return nil
+}
+
+// Resolve return the devices matching the given selectors, which can be an IP (with optional port),
+// a MAC, a name or a room prefixed by RoomSelectorPrefix. Devices are discovered only when needed
+func (e *Environment) Resolve(ctx context.Context, selectors []string) (targets []Target, err error) {
+
+	if len(selectors) == 0 && e.Config.DefaultTarget != "" {
+		selectors = []string{e.Config.DefaultTarget}
+	}
+
+	if len(selectors) == 0 {
+		return targets, errors.New(NoTargetErrorMessage)
+	}
+
+	seen := map[string]bool{}
+	for _, selector := range selectors {
+		selected, err := e.resolveSelector(ctx, selector)
+		if err != nil {
+			return targets, err
+		}
+
+		for _, target := range selected {
+			if seen[target.Client.Address()] {
+				continue
+			}
+			seen[target.Client.Address()] = true
+			targets = append(targets, target)
+		}
+	}
+
+	return targets, nil
+}
+
+// resolveSelector return the devices matching one selector
+func (e *Environment) resolveSelector(ctx context.Context, selector string) (targets []Target, err error) {
+
+	if host, port, ok := parseAddress(selector); ok {
+
+		// Devices known by the inventory keep their name
+		for _, device := range e.Inventory.Devices() {
+			if device.Ip == host && device.Port == port {
+				return e.inventoryTargets([]wizgo.InventoryDevice{device})
+			}
+		}
+
+		wizClient, err := wizgo.CreateWizClientWithOptions(host, port, wizgo.WizClientOptions{Timeout: e.Timeout})
+		if err != nil {
+			return targets, err
+		}
+		e.directClients = append(e.directClients, &wizClient)
+		return []Target{{Client: &wizClient}}, nil
+	}
+
+	if strings.HasPrefix(selector, RoomSelectorPrefix) {
+		roomSelector := strings.TrimPrefix(selector, RoomSelectorPrefix)
+
+		// Room ids are only known after asking the devices
+		if err = e.Discover(ctx); err != nil {
+			return targets, err
+		}
+
+		room, err := e.Inventory.Room(roomSelector)
+		if err != nil {
+			return targets, err
+		}
+		return e.inventoryTargets(room.Devices)
+	}
+
+	device, err := e.Inventory.Lookup(selector)
+	if err != nil {
+		if err = e.Discover(ctx); err != nil {
+			return targets, err
+		}
+
+		device, err = e.Inventory.Lookup(selector)
+		if err != nil {
+			return targets, err
+		}
+	}
+
+	return e.inventoryTargets([]wizgo.InventoryDevice{device})
+}
+
+// inventoryTargets return the targets for the given devices of the inventory
+func (e *Environment) inventoryTargets(devices []wizgo.InventoryDevice) (targets []Target, err error) {
+
+	for _, device := range devices {
+		wizClient, err := e.Inventory.Client(device.Mac)
+		if err != nil {
+			return targets, err
+		}
+		targets = append(targets, Target{Name: device.Name, Mac: device.Mac, Client: wizClient})
+	}
+
+	return targets, nil
+}
+
+// Close closes all the clients created while resolving the targets
+func (e *Environment) Close() (err error) {
+
+	errs := []error{e.Inventory.Close()}
+	for _, wizClient := range e.directClients {
+		errs = append(errs, wizClient.Close())
+	}
+
+	return errors.Join(errs...)
+}
+
+// parseAddress return the host and port of selectors given as 'ip' or 'ip:port'
+func parseAddress(selector string) (host string, port int, ok bool) {
+
+	host, portText, err := net.SplitHostPort(selector)
+	if err != nil {
+		host, portText = selector, strconv.Itoa(wizgo.DefaultPort)
+	}
+
+	if net.ParseIP(host) == nil {
+		return host, port, false
+	}
+
+	port, err = strconv.Atoi(portText)
+	if err != nil {
+		return host, port, false
+	}
+
+	return host, port, true
+}
+
+// clientsOf return the clients of the given targets
+func clientsOf(targets []Target) []*wizgo.WizClient {
+	wizClients := make([]*wizgo.WizClient, 0, len(targets))
+	for _, target := range targets {
+		wizClients = append(wizClients, target.Client)
+	}
+	return wizClients
+}
+
+// targetsError is used to tell the user about several failed targets at once
+func targetsError(failed, total int) error {
+	return errors.New(fmt.Sprintf("%d of %d devices failed", failed, total))
+}
@@ -0,0 +1,151 @@
+// wizgo-scheduler runs the jobs kept in a JSON file at their times, on the WiZ devices found on the network.
+// The outcome of each run is saved back to the file, so missed runs are detected across restarts
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/achetronic/wizgo/pkg/scheduler"
+	"github.com/achetronic/wizgo/pkg/wizgo"
+)
+
+func main() {
+
+	jobsFile := flag.String("jobs", "jobs.json", "file keeping the jobs")
+	timezone := flag.String("timezone", "", "timezone of the jobs without one. Default: the local one")
+	coordinates := flag.String("coordinates", "", "latitude,longitude of the place, for the solar jobs without their own. I.E: 40.42,-3.70")
+	broadcast := flag.String("broadcast", "", "comma-separated broadcast addresses used to discover devices")
+	interfaces := flag.String("interfaces", "", "comma-separated interfaces whose broadcast addresses are used to discover devices")
+	refresh := flag.Duration("refresh", 5*time.Minute, "how often devices are discovered again")
+	timeout := flag.Duration("timeout", wizgo.DefaultTimeout, "timeout of each request to the devices")
+	flag.Parse()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	location := time.Local
+	if *timezone != "" {
+		var err error
+		if location, err = time.LoadLocation(*timezone); err != nil {
+			log.Fatalf("invalid timezone: %s", err)
+		}
+	}
+
+	var place *scheduler.Coordinates
+	if *coordinates != "" {
+		var err error
+		if place, err = parseCoordinates(*coordinates); err != nil {
+			log.Fatalf("error parsing the coordinates: %s", err)
+		}
+	}
+
+	discoverOptions := wizgo.DiscoverOptions{
+		BroadcastAddresses: splitList(*broadcast),
+		Interfaces:         splitList(*interfaces),
+	}
+
+	inventory := wizgo.CreateInventory(wizgo.WizClientOptions{Timeout: *timeout})
+	defer inventory.Close()
+
+	// Devices must be known before running the jobs missed while the scheduler was down
+	if err := inventory.Refresh(ctx, discoverOptions); err != nil {
+		log.Printf("error discovering devices: %s", err)
+	}
+	log.Printf("%d devices in the inventory", len(inventory.Devices()))
+
+	jobScheduler, err := scheduler.CreateScheduler(inventory, scheduler.Options{
+		Store:       scheduler.CreateFileStore(*jobsFile),
+		Location:    location,
+		Coordinates: place,
+		Handler:     logRun,
+	})
+	if err != nil {
+		log.Fatalf("error loading the jobs: %s", err)
+	}
+
+	for _, job := range jobScheduler.Jobs() {
+		log.Printf("job %s (%s): next run at %s", job.Id, job.Name, describeTime(jobScheduler.NextRun(job)))
+	}
+
+	// Devices may change their IP or join the network at any time
+	go func() {
+		ticker := time.NewTicker(*refresh)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+
+			if err := inventory.Refresh(ctx, discoverOptions); err != nil {
+				log.Printf("error discovering devices: %s", err)
+			}
+		}
+	}()
+
+	_ = jobScheduler.Run(ctx)
+}
+
+// logRun logs the outcome of a run
+func logRun(event scheduler.RunEvent) {
+	switch {
+	case event.Job.Id == "":
+		log.Printf("error: %s", event.Err)
+	case event.Skipped && event.Err != nil:
+		log.Printf("job %s (%s): skipped run of %s: %s", event.Job.Id, event.Job.Name, describeTime(event.ScheduledAt), event.Err)
+	case event.Skipped:
+		log.Printf("job %s (%s): skipped missed run of %s", event.Job.Id, event.Job.Name, describeTime(event.ScheduledAt))
+	case event.Err != nil:
+		log.Printf("job %s (%s): run of %s failed after %s: %s", event.Job.Id, event.Job.Name, describeTime(event.ScheduledAt), event.Duration, event.Err)
+	default:
+		log.Printf("job %s (%s): run of %s done in %s", event.Job.Id, event.Job.Name, describeTime(event.ScheduledAt), event.Duration)
+	}
+}
+
+// describeTime return the time in a readable form, or 'never' for the zero time
+func describeTime(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	return t.Format(time.DateTime + " MST")
+}
+
+// parseCoordinates return the coordinates given as 'latitude,longitude'
+func parseCoordinates(text string) (coordinates *scheduler.Coordinates, err error) {
+
+	latitude, longitude, found := strings.Cut(text, ",")
+	if !found {
+		return coordinates, errors.New("use 'latitude,longitude'")
+	}
+
+	coordinates = &scheduler.Coordinates{}
+	if coordinates.Latitude, err = strconv.ParseFloat(strings.TrimSpace(latitude), 64); err != nil {
+		return coordinates, err
+	}
+	if coordinates.Longitude, err = strconv.ParseFloat(strings.TrimSpace(longitude), 64); err != nil {
+		return coordinates, err
+	}
+
+	return coordinates, coordinates.Validate()
+}
+
+// splitList return the non-empty items of a comma-separated list
+func splitList(list string) (items []string) {
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,89 @@
+// wizgo-server exposes the WiZ devices found on the network over a REST API.
+// The OpenAPI spec of the API is served at /openapi.json
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/achetronic/wizgo/pkg/server"
+	"github.com/achetronic/wizgo/pkg/wizgo"
+)
+
+func main() {
+
+	address := flag.String("address", ":8080", "address to serve the API on")
+	broadcast := flag.String("broadcast", "", "comma-separated broadcast addresses used to discover devices")
+	interfaces := flag.String("interfaces", "", "comma-separated interfaces whose broadcast addresses are used to discover devices")
+	refresh := flag.Duration("refresh", 5*time.Minute, "how often devices are discovered again")
+	timeout := flag.Duration("timeout", wizgo.DefaultTimeout, "timeout of each request to the devices")
+	flag.Parse()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	discoverOptions := wizgo.DiscoverOptions{
+		BroadcastAddresses: splitList(*broadcast),
+		Interfaces:         splitList(*interfaces),
+	}
+
+	inventory := wizgo.CreateInventory(wizgo.WizClientOptions{Timeout: *timeout})
+	defer inventory.Close()
+
+	// Devices may change their IP or join the network at any time
+	go func() {
+		ticker := time.NewTicker(*refresh)
+		defer ticker.Stop()
+
+		for {
+			err := inventory.Refresh(ctx, discoverOptions)
+			if err != nil {
+				log.Printf("error discovering devices: %s", err)
+			} else {
+				log.Printf("%d devices in the inventory", len(inventory.Devices()))
+			}
+
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	httpServer := &http.Server{
+		Addr:    *address,
+		Handler: server.CreateServer(inventory, server.Options{}).Handler(),
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = httpServer.Shutdown(shutdownCtx)
+	}()
+
+	log.Printf("serving the API on %s", *address)
+	err := httpServer.ListenAndServe()
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Fatalf("error serving the API: %s", err)
+	}
+}
+
+// splitList return the non-empty items of a comma-separated list
+func splitList(list string) (items []string) {
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -11,6 +11,10 @@ func main() {
 
 	// The first step is to start a client to interact with your devices
 	wizClient, err := wizgo.CreateWizClient("192.168.2.107", 38899)
+	if err != nil {
+		log.Fatalf("error creating the client: %s", err)
+	}
+	defer wizClient.Close()
 
 	// Devices can be turned on/off with single commands
 	_, err = wizClient.TurnOn()
@@ -68,6 +72,7 @@ func main() {
 
 	// There are a lot of more things implemented
 	_, err = wizClient.GetPilot()
+	_, err = wizClient.ReadPilotState()
 	_, err = wizClient.GetModelConfig()
 	_, err = wizClient.GetDevInfo()
 	_, err = wizClient.GetSystemConfig()
@@ -0,0 +1,551 @@
+package emulator
+
+import (
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	wizgotypes "github.com/achetronic/wizgo/api/types"
+)
+
+// This package emulates WiZ devices over UDP, speaking the same JSON protocol,
+// so the library can be exercised end to end without physical devices
+
+const (
+	// DefaultAddress is where emulators listen when no other address is given. The port is chosen by the system
+	DefaultAddress = "127.0.0.1:0"
+
+	// DefaultFwVersion is the firmware version reported by emulators
+	DefaultFwVersion = "1.28.0"
+
+	// DefaultPushPort is the port of the registered phones where state changes are pushed
+	DefaultPushPort = 38900
+
+	// JSON-RPC error codes answered by the emulators
+	ParseErrorCode     = -32700
+	MethodNotFoundCode = -32601
+	InvalidParamsCode  = -32602
+)
+
+// Model represents the kind of device being emulated
+type Model struct {
+	ModuleName string // ModuleName reported on 'getSystemConfig', which tells the class of the device
+	CctRange   []int  // CctRange reported on 'getModelConfig'. Empty for devices without white temperature
+
+	Color            bool // Color is true when the device accepts r, g, b
+	ColorTemperature bool // ColorTemperature is true when the device accepts temp, c, w
+	Brightness       bool // Brightness is true when the device accepts dimming
+	Effects          bool // Effects is true when the device accepts sceneId and speed
+}
+
+var (
+	ModelRgb    = Model{ModuleName: "ESP01_SHRGB1C_31", CctRange: []int{2200, 6500}, Color: true, ColorTemperature: true, Brightness: true, Effects: true}
+	ModelTw     = Model{ModuleName: "ESP56_SHTW3_01", CctRange: []int{2700, 6500}, ColorTemperature: true, Brightness: true, Effects: true}
+	ModelDw     = Model{ModuleName: "ESP06_SHDW9_01", Brightness: true, Effects: true}
+	ModelSocket = Model{ModuleName: "ESP10_SOCKET_06"}
+)
+
+// Options represents the settings used to start an emulator
+type Options struct {
+	Address   string // Address to listen on. Default: DefaultAddress
+	Model     Model  // Model to emulate. Default: ModelRgb
+	Mac       string // Mac reported by the device. Default: derived from the port
+	FwVersion string // FwVersion reported by the device. Default: DefaultFwVersion
+	HomeId    int
+	RoomId    int
+	GroupId   int
+
+	Latency  time.Duration // Latency is waited before answering each message
+	LossRate float64       // LossRate is the fraction (0-1) of received datagrams silently dropped
+	PushPort int           // PushPort is where registered phones receive 'syncPilot' messages. Default: DefaultPushPort
+}
+
+// Emulator represents an emulated device listening on UDP
+type Emulator struct {
+	options    Options
+	connection *net.UDPConn
+
+	mutex         sync.Mutex
+	pilot         pilot
+	latency       time.Duration
+	lossRate      float64
+	errors        map[string]int
+	registrations map[string]*net.UDPAddr
+	messages      []wizgotypes.WizMessage
+
+	random *rand.Rand
+	wg     sync.WaitGroup
+}
+
+// pilot represents the state of the light, as the firmware keeps it
+type pilot struct {
+	mode    wizgotypes.PilotMode
+	state   bool
+	r, g, b int
+	c, w    int
+	temp    int
+	sceneId int
+	speed   int
+	ratio   int
+	dimming int
+	rssi    int
+}
+
+// Start starts an emulated device listening on UDP. It must be stopped with Close
+func Start(options Options) (emulator *Emulator, err error) {
+
+	if options.Address == "" {
+		options.Address = DefaultAddress
+	}
+
+	if options.Model.ModuleName == "" {
+		options.Model = ModelRgb
+	}
+
+	if options.FwVersion == "" {
+		options.FwVersion = DefaultFwVersion
+	}
+
+	if options.PushPort <= 0 {
+		options.PushPort = DefaultPushPort
+	}
+
+	address, err := net.ResolveUDPAddr("udp", options.Address)
+	if err != nil {
+		return emulator, err
+	}
+
+	connection, err := net.ListenUDP("udp", address)
+	if err != nil {
+		return emulator, err
+	}
+
+	if options.Mac == "" {
+		options.Mac = "a8bb50" + strconv.FormatInt(int64(connection.LocalAddr().(*net.UDPAddr).Port)+0x100000, 16)
+	}
+
+	emulator = &Emulator{
+		options:       options,
+		connection:    connection,
+		latency:       options.Latency,
+		lossRate:      options.LossRate,
+		errors:        map[string]int{},
+		registrations: map[string]*net.UDPAddr{},
+		random:        rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+
+	emulator.pilot = pilot{mode: wizgotypes.PilotModeWhite, state: true, dimming: 100, speed: 100, ratio: 50, rssi: -55}
+	if options.Model.ColorTemperature {
+		emulator.pilot.mode = wizgotypes.PilotModeCct
+		emulator.pilot.temp = 4200
+	}
+
+	emulator.wg.Add(1)
+	go emulator.serve()
+
+	return emulator, nil
+}
+
+// Host return the IP the emulator listens on
+func (e *Emulator) Host() string {
+	return e.connection.LocalAddr().(*net.UDPAddr).IP.String()
+}
+
+// Port return the port the emulator listens on
+func (e *Emulator) Port() int {
+	return e.connection.LocalAddr().(*net.UDPAddr).Port
+}
+
+// Address return the address the emulator listens on in host:port form
+func (e *Emulator) Address() string {
+	return e.connection.LocalAddr().String()
+}
+
+// Mac return the MAC reported by the emulated device
+func (e *Emulator) Mac() string {
+	return e.options.Mac
+}
+
+// Close stops the emulator and waits for the pending answers
+func (e *Emulator) Close() error {
+	err := e.connection.Close()
+	e.wg.Wait()
+	return err
+}
+
+// SetLatency changes the time waited before answering each message
+func (e *Emulator) SetLatency(latency time.Duration) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.latency = latency
+}
+
+// SetLossRate changes the fraction (0-1) of received datagrams silently dropped
+func (e *Emulator) SetLossRate(lossRate float64) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.lossRate = lossRate
+}
+
+// InjectError makes the emulator answer every message with the given method with the given error code
+func (e *Emulator) InjectError(method string, code int) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.errors[method] = code
+}
+
+// ClearErrors removes all the errors injected
+func (e *Emulator) ClearErrors() {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.errors = map[string]int{}
+}
+
+// Messages return the messages received so far, in order. Dropped datagrams are not included
+func (e *Emulator) Messages() []wizgotypes.WizMessage {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return append([]wizgotypes.WizMessage{}, e.messages...)
+}
+
+// PilotState return the current state of the light, as it would be answered on 'getPilot'
+func (e *Emulator) PilotState() (state wizgotypes.PilotState) {
+	e.mutex.Lock()
+	result := e.pilotResult()
+	e.mutex.Unlock()
+
+	content, _ := json.Marshal(result)
+	_ = json.Unmarshal(content, &state)
+	return state
+}
+
+// SetPilotState changes the state of the light from outside, like a wall switch or the mobile app do,
+// and pushes the change to the registered phones
+func (e *Emulator) SetPilotState(state wizgotypes.PilotState) error {
+	content, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	var params wizgotypes.WizMessageParams
+	if err = json.Unmarshal(content, &params); err != nil {
+		return err
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if code := e.applyPilot(params); code != 0 {
+		return errors.New("invalid params")
+	}
+
+	e.pushState()
+	return nil
+}
+
+// FirstBeat pushes a 'firstBeat' message to the registered phones, as devices do when they boot
+func (e *Emulator) FirstBeat() {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.push("firstBeat", map[string]interface{}{
+		"mac":       e.options.Mac,
+		"homeId":    e.options.HomeId,
+		"fwVersion": e.options.FwVersion,
+	})
+}
+
+// serve reads the datagrams and answers each one in its own goroutine, so latency does not pile up
+func (e *Emulator) serve() {
+	defer e.wg.Done()
+
+	buffer := make([]byte, 4096)
+	for {
+		n, remote, err := e.connection.ReadFromUDP(buffer)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+
+		content := append([]byte(nil), buffer[:n]...)
+
+		e.wg.Add(1)
+		go func() {
+			defer e.wg.Done()
+			e.handle(content, remote)
+		}()
+	}
+}
+
+// handle answers a single datagram
+func (e *Emulator) handle(content []byte, remote *net.UDPAddr) {
+
+	e.mutex.Lock()
+	dropped := e.random.Float64() < e.lossRate
+	latency := e.latency
+	e.mutex.Unlock()
+
+	if dropped {
+		return
+	}
+
+	var message wizgotypes.WizMessage
+	var response map[string]interface{}
+
+	if err := json.Unmarshal(content, &message); err != nil {
+		response = errorResponse(message, ParseErrorCode, "Parse error")
+	} else {
+		response = e.process(message, remote)
+	}
+
+	time.Sleep(latency)
+
+	answer, err := json.Marshal(response)
+	if err != nil {
+		return
+	}
+	_, _ = e.connection.WriteToUDP(answer, remote)
+}
+
+// process applies the message to the emulated device and return the answer
+func (e *Emulator) process(message wizgotypes.WizMessage, remote *net.UDPAddr) map[string]interface{} {
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.messages = append(e.messages, message)
+
+	if code, found := e.errors[message.Method]; found {
+		return errorResponse(message, code, "Injected error")
+	}
+
+	switch message.Method {
+	case "getPilot":
+		return resultResponse(message, e.pilotResult())
+
+	case "setPilot":
+		if code := e.applyPilot(message.Params); code != 0 {
+			return errorResponse(message, code, "Invalid params")
+		}
+		e.pushState()
+		return resultResponse(message, map[string]interface{}{"success": true})
+
+	case "setState":
+		state, ok := message.Params["state"].(bool)
+		if !ok {
+			return errorResponse(message, InvalidParamsCode, "Invalid params")
+		}
+		e.pilot.state = state
+		e.pushState()
+		return resultResponse(message, map[string]interface{}{"success": true})
+
+	case "pulse":
+		return resultResponse(message, map[string]interface{}{"success": true})
+
+	case "registration":
+		return e.register(message, remote)
+
+	case "getSystemConfig":
+		return resultResponse(message, map[string]interface{}{
+			"mac":        e.options.Mac,
+			"homeId":     e.options.HomeId,
+			"roomId":     e.options.RoomId,
+			"groupId":    e.options.GroupId,
+			"rgn":        "eu",
+			"moduleName": e.options.Model.ModuleName,
+			"fwVersion":  e.options.FwVersion,
+			"ping":       0,
+			"drvConf":    []int{20, 2},
+		})
+
+	case "getModelConfig":
+		result := map[string]interface{}{"ps": 1, "pwmFreq": 1000, "pwmRange": []int{0, 100}}
+		if len(e.options.Model.CctRange) > 0 {
+			result["cctRange"] = e.options.Model.CctRange
+		}
+		return resultResponse(message, result)
+
+	case "getUserConfig":
+		return resultResponse(message, map[string]interface{}{
+			"fadeIn": 450, "fadeOut": 500, "dftDim": 100, "opMode": 0, "po": false, "minDimming": 10, "tapSensor": 0,
+		})
+
+	case "getDevInfo":
+		return resultResponse(message, map[string]interface{}{"devMac": e.options.Mac})
+	}
+
+	return errorResponse(message, MethodNotFoundCode, "Method not found")
+}
+
+// register stores or removes the phone that will receive the state changes
+func (e *Emulator) register(message wizgotypes.WizMessage, remote *net.UDPAddr) map[string]interface{} {
+
+	phoneIp, _ := message.Params["phoneIp"].(string)
+	register, _ := message.Params["register"].(bool)
+
+	if register && phoneIp != "" {
+		e.registrations[phoneIp] = &net.UDPAddr{IP: net.ParseIP(phoneIp), Port: e.options.PushPort}
+	} else {
+		delete(e.registrations, phoneIp)
+	}
+
+	return resultResponse(message, map[string]interface{}{"mac": e.options.Mac, "success": true})
+}
+
+// applyPilot changes the light as the firmware does on 'setPilot'. It return a non-zero code when params are wrong.
+// It must be called holding the mutex
+func (e *Emulator) applyPilot(params wizgotypes.WizMessageParams) (code int) {
+
+	model := e.options.Model
+	next := e.pilot
+
+	value := func(field string, min, max int, supported bool) (int, bool) {
+		raw, found := params[field]
+		if !found {
+			return 0, false
+		}
+		number, ok := raw.(float64)
+		if !ok || !supported || int(number) < min || int(number) > max {
+			code = InvalidParamsCode
+			return 0, false
+		}
+		return int(number), true
+	}
+
+	if state, found := params["state"]; found {
+		on, ok := state.(bool)
+		if !ok {
+			return InvalidParamsCode
+		}
+		next.state = on
+	}
+
+	r, foundR := value("r", 0, 255, model.Color)
+	g, foundG := value("g", 0, 255, model.Color)
+	b, foundB := value("b", 0, 255, model.Color)
+	c, foundC := value("c", 0, 255, model.ColorTemperature)
+	w, foundW := value("w", 0, 255, model.ColorTemperature)
+	temp, foundTemp := value("temp", 1000, 10000, model.ColorTemperature)
+	sceneId, foundScene := value("sceneId", 0, 1000, model.Effects)
+	speed, foundSpeed := value("speed", 10, 200, model.Effects)
+	ratio, foundRatio := value("ratio", 1, 100, model.Brightness)
+	dimming, foundDimming := value("dimming", 0, 100, model.Brightness)
+
+	if code != 0 {
+		return code
+	}
+
+	switch {
+	case foundR || foundG || foundB:
+		next.mode, next.r, next.g, next.b, next.c, next.w = wizgotypes.PilotModeRgb, r, g, b, c, w
+	case foundTemp:
+		next.mode, next.temp = wizgotypes.PilotModeCct, temp
+	case foundC || foundW:
+		next.mode, next.r, next.g, next.b, next.c, next.w = wizgotypes.PilotModeRgb, 0, 0, 0, c, w
+	case foundScene && sceneId > 0:
+		next.mode, next.sceneId = wizgotypes.PilotModeScene, sceneId
+	}
+
+	if next.mode != wizgotypes.PilotModeScene {
+		next.sceneId = 0
+	}
+
+	if foundSpeed {
+		next.speed = speed
+	}
+	if foundRatio {
+		next.ratio = ratio
+	}
+	if foundDimming {
+		next.dimming = dimming
+	}
+
+	// Changing the light turns the device on, as the firmware does
+	if _, found := params["state"]; !found && len(params) > 0 {
+		next.state = true
+	}
+
+	e.pilot = next
+	return 0
+}
+
+// pilotResult return the fields reported on 'getPilot', which depend on the current mode.
+// It must be called holding the mutex
+func (e *Emulator) pilotResult() map[string]interface{} {
+
+	result := map[string]interface{}{
+		"mac":   e.options.Mac,
+		"rssi":  e.pilot.rssi,
+		"src":   "",
+		"state": e.pilot.state,
+	}
+
+	model := e.options.Model
+	if !model.Brightness {
+		return result
+	}
+
+	result["sceneId"] = e.pilot.sceneId
+	result["dimming"] = e.pilot.dimming
+
+	switch e.pilot.mode {
+	case wizgotypes.PilotModeRgb:
+		result["r"], result["g"], result["b"] = e.pilot.r, e.pilot.g, e.pilot.b
+		result["c"], result["w"] = e.pilot.c, e.pilot.w
+	case wizgotypes.PilotModeCct:
+		result["temp"] = e.pilot.temp
+	case wizgotypes.PilotModeScene:
+		result["speed"] = e.pilot.speed
+	}
+
+	return result
+}
+
+// pushState sends the current state to the registered phones, as devices do after each change.
+// It must be called holding the mutex
+func (e *Emulator) pushState() {
+
+	params := e.pilotResult()
+	params["src"] = "udp"
+
+	e.push("syncPilot", params)
+}
+
+// push sends a message to the registered phones. It must be called holding the mutex
+func (e *Emulator) push(method string, params map[string]interface{}) {
+
+	content, err := json.Marshal(map[string]interface{}{"method": method, "env": "pro", "params": params})
+	if err != nil {
+		return
+	}
+
+	for _, phone := range e.registrations {
+		_, _ = e.connection.WriteToUDP(content, phone)
+	}
+}
+
+// resultResponse builds a successful answer to the message
+func resultResponse(message wizgotypes.WizMessage, result map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"method": message.Method,
+		"id":     message.Id,
+		"env":    "pro",
+		"result": result,
+	}
+}
+
+// errorResponse builds an error answer to the message
+func errorResponse(message wizgotypes.WizMessage, code int, errorMessage string) map[string]interface{} {
+	return map[string]interface{}{
+		"method": message.Method,
+		"id":     message.Id,
+		"env":    "pro",
+		"error":  map[string]interface{}{"code": code, "message": errorMessage},
+	}
+}
@@ -0,0 +1,297 @@
+package exporter
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	wizgotypes "github.com/achetronic/wizgo/api/types"
+	"github.com/achetronic/wizgo/pkg/wizgo"
+)
+
+const (
+	// DefaultNamespace is the prefix of all the metrics when no other is given
+	DefaultNamespace = "wizgo"
+
+	// DefaultPollInterval is how often the devices are read when no other interval is given
+	DefaultPollInterval = 30 * time.Second
+)
+
+var (
+	// DefaultLatencyBuckets are the buckets of the request latency histogram, in seconds.
+	// Devices answer in a few milliseconds, unless datagrams are lost and re-sent
+	DefaultLatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+)
+
+// Options represents the settings used when creating an Exporter
+type Options struct {
+	Namespace      string        // Namespace is the prefix of all the metrics. Default: DefaultNamespace
+	PollInterval   time.Duration // PollInterval is how often the devices are read. Default: DefaultPollInterval
+	LatencyBuckets []float64     // LatencyBuckets of the request latency histogram. Default: DefaultLatencyBuckets
+}
+
+// Exporter polls the devices of an inventory and exposes their telemetry as Prometheus metrics.
+// Request latencies and errors are measured from the clients, once Observe is set as their observer:
+//
+//	metrics := exporter.CreateExporter(exporter.Options{})
+//	inventory := wizgo.CreateInventory(wizgo.WizClientOptions{Observer: metrics.Observe})
+type Exporter struct {
+	options Options
+
+	// Device metrics are built from the last poll on each scrape, so devices gone from the inventory disappear
+	devices      map[string]deviceSnapshot
+	addresses    map[string]string // addresses maps the address of each device to its MAC
+	devicesMutex sync.RWMutex
+
+	rssi        *prometheus.Desc
+	up          *prometheus.Desc
+	on          *prometheus.Desc
+	dimming     *prometheus.Desc
+	temperature *prometheus.Desc
+	sceneId     *prometheus.Desc
+	info        *prometheus.Desc
+
+	requestDuration *prometheus.HistogramVec
+	requestTimeouts *prometheus.CounterVec
+	requestErrors   *prometheus.CounterVec
+
+	registry *prometheus.Registry
+}
+
+// deviceSnapshot represents what was known about a device on the last poll
+type deviceSnapshot struct {
+	device wizgo.InventoryDevice
+	state  wizgotypes.PilotState
+	up     bool
+}
+
+// CreateExporter creates an exporter with its own registry, served by Handler
+func CreateExporter(options Options) *Exporter {
+
+	if options.Namespace == "" {
+		options.Namespace = DefaultNamespace
+	}
+
+	if options.PollInterval <= 0 {
+		options.PollInterval = DefaultPollInterval
+	}
+
+	if len(options.LatencyBuckets) == 0 {
+		options.LatencyBuckets = DefaultLatencyBuckets
+	}
+
+	deviceLabels := []string{"mac", "name"}
+	requestLabels := []string{"device", "method"}
+
+	e := &Exporter{
+		options:   options,
+		devices:   map[string]deviceSnapshot{},
+		addresses: map[string]string{},
+
+		rssi: prometheus.NewDesc(prometheus.BuildFQName(options.Namespace, "device", "rssi_dbm"),
+			"WiFi signal strength reported by the device.", deviceLabels, nil),
+		up: prometheus.NewDesc(prometheus.BuildFQName(options.Namespace, "device", "up"),
+			"Whether the device answered the last poll.", deviceLabels, nil),
+		on: prometheus.NewDesc(prometheus.BuildFQName(options.Namespace, "device", "on"),
+			"Whether the device is turned on.", deviceLabels, nil),
+		dimming: prometheus.NewDesc(prometheus.BuildFQName(options.Namespace, "device", "dimming_percent"),
+			"Brightness of the device (10-100).", deviceLabels, nil),
+		temperature: prometheus.NewDesc(prometheus.BuildFQName(options.Namespace, "device", "temperature_kelvin"),
+			"White temperature of the device, when it is in white temperature mode.", deviceLabels, nil),
+		sceneId: prometheus.NewDesc(prometheus.BuildFQName(options.Namespace, "device", "scene_id"),
+			"Scene played by the device, 0 when none.", deviceLabels, nil),
+		info: prometheus.NewDesc(prometheus.BuildFQName(options.Namespace, "device", "info"),
+			"Module and firmware of the device.", append(deviceLabels, "module_name", "fw_version"), nil),
+
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: options.Namespace,
+			Subsystem: "request",
+			Name:      "duration_seconds",
+			Help:      "Duration of the requests to the devices, including retransmissions.",
+			Buckets:   options.LatencyBuckets,
+		}, requestLabels),
+		requestTimeouts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: options.Namespace,
+			Subsystem: "request",
+			Name:      "timeouts_total",
+			Help:      "Requests not answered by the devices in time.",
+		}, requestLabels),
+		requestErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: options.Namespace,
+			Subsystem: "request",
+			Name:      "errors_total",
+			Help:      "Requests failed for any reason, timeouts included.",
+		}, requestLabels),
+
+		registry: prometheus.NewRegistry(),
+	}
+
+	e.registry.MustRegister(e, e.requestDuration, e.requestTimeouts, e.requestErrors)
+	return e
+}
+
+// Registry return the registry holding the metrics of the exporter, so other collectors can be added
+func (e *Exporter) Registry() *prometheus.Registry {
+	return e.registry
+}
+
+// Handler return the HTTP handler serving the metrics
+func (e *Exporter) Handler() http.Handler {
+	return promhttp.HandlerFor(e.registry, promhttp.HandlerOpts{})
+}
+
+// Observe measures the outcome of a request. It is meant to be set as WizClientOptions.Observer.
+// Requests are labelled with the MAC of the device, or with its address when it is not in the polled inventory
+func (e *Exporter) Observe(observation wizgo.RequestObservation) {
+
+	e.devicesMutex.RLock()
+	device, found := e.addresses[observation.Address]
+	e.devicesMutex.RUnlock()
+
+	if !found {
+		device = observation.Address
+	}
+
+	e.requestDuration.WithLabelValues(device, observation.Method).Observe(observation.Duration.Seconds())
+
+	if observation.Err == nil {
+		return
+	}
+
+	e.requestErrors.WithLabelValues(device, observation.Method).Inc()
+
+	var timeoutErr *wizgo.TimeoutError
+	if errors.As(observation.Err, &timeoutErr) {
+		e.requestTimeouts.WithLabelValues(device, observation.Method).Inc()
+	}
+}
+
+// Run polls the devices of the inventory on every interval until the context is done
+func (e *Exporter) Run(ctx context.Context, inventory *wizgo.Inventory) error {
+
+	ticker := time.NewTicker(e.options.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		e.Poll(ctx, inventory)
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// Poll reads the state of all the devices of the inventory concurrently and keeps it for the next scrapes
+func (e *Exporter) Poll(ctx context.Context, inventory *wizgo.Inventory) {
+
+	devices := inventory.Devices()
+	snapshots := make([]deviceSnapshot, len(devices))
+
+	// Requests made while polling are already labelled with the MAC
+	addresses := make(map[string]string, len(devices))
+	for _, device := range devices {
+		addresses[device.Address()] = device.Mac
+	}
+
+	e.devicesMutex.Lock()
+	e.addresses = addresses
+	e.devicesMutex.Unlock()
+
+	var wg sync.WaitGroup
+	for index, device := range devices {
+		wg.Add(1)
+		go func(index int, device wizgo.InventoryDevice) {
+			defer wg.Done()
+
+			snapshots[index].device = device
+
+			wizClient, err := inventory.Client(device.Mac)
+			if err != nil {
+				return
+			}
+
+			// Devices not found by the discovery lack the info labels, which the capabilities hold.
+			// They are cached by the client, so the device is only asked once
+			if device.ModuleName == "" || device.FwVersion == "" {
+				if capabilities, err := wizClient.CapabilitiesContext(ctx); err == nil {
+					snapshots[index].device.ModuleName = capabilities.ModuleName
+					snapshots[index].device.FwVersion = capabilities.FwVersion
+				}
+			}
+
+			snapshots[index].state, err = wizClient.ReadPilotStateContext(ctx)
+			snapshots[index].up = err == nil
+		}(index, device)
+	}
+	wg.Wait()
+
+	e.devicesMutex.Lock()
+	defer e.devicesMutex.Unlock()
+
+	e.devices = make(map[string]deviceSnapshot, len(snapshots))
+	for _, snapshot := range snapshots {
+		e.devices[snapshot.device.Mac] = snapshot
+	}
+}
+
+// Describe sends the descriptors of the device metrics. It implements prometheus.Collector
+func (e *Exporter) Describe(descs chan<- *prometheus.Desc) {
+	for _, desc := range []*prometheus.Desc{e.rssi, e.up, e.on, e.dimming, e.temperature, e.sceneId, e.info} {
+		descs <- desc
+	}
+}
+
+// Collect sends the device metrics built from the last poll. It implements prometheus.Collector
+func (e *Exporter) Collect(metrics chan<- prometheus.Metric) {
+
+	e.devicesMutex.RLock()
+	defer e.devicesMutex.RUnlock()
+
+	for mac, snapshot := range e.devices {
+		labels := []string{mac, snapshot.device.Name}
+
+		metrics <- prometheus.MustNewConstMetric(e.up, prometheus.GaugeValue, boolValue(snapshot.up), labels...)
+
+		// The module is known from the discovery or the capabilities, even when the device does not answer now
+		if snapshot.device.ModuleName != "" || snapshot.device.FwVersion != "" {
+			metrics <- prometheus.MustNewConstMetric(e.info, prometheus.GaugeValue, 1,
+				append(labels, snapshot.device.ModuleName, snapshot.device.FwVersion)...)
+		}
+
+		if !snapshot.up {
+			continue
+		}
+
+		state := snapshot.state
+		if state.Rssi != nil {
+			metrics <- prometheus.MustNewConstMetric(e.rssi, prometheus.GaugeValue, float64(*state.Rssi), labels...)
+		}
+		if state.State != nil {
+			metrics <- prometheus.MustNewConstMetric(e.on, prometheus.GaugeValue, boolValue(*state.State), labels...)
+		}
+		if state.Dimming != nil {
+			metrics <- prometheus.MustNewConstMetric(e.dimming, prometheus.GaugeValue, float64(*state.Dimming), labels...)
+		}
+		if state.Temp != nil && *state.Temp > 0 {
+			metrics <- prometheus.MustNewConstMetric(e.temperature, prometheus.GaugeValue, float64(*state.Temp), labels...)
+		}
+		if state.SceneId != nil {
+			metrics <- prometheus.MustNewConstMetric(e.sceneId, prometheus.GaugeValue, float64(*state.SceneId), labels...)
+		}
+	}
+}
+
+// boolValue return 1 for true and 0 for false
+func boolValue(value bool) float64 {
+	if value {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,226 @@
+package homeassistant
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/achetronic/wizgo/pkg/wizgo"
+)
+
+const (
+	// DefaultDiscoveryPrefix is the prefix where Home Assistant looks for discovery payloads by default
+	DefaultDiscoveryPrefix = "homeassistant"
+
+	// StatusTopicLevel is the level under the prefix where Home Assistant announces it is online
+	StatusTopicLevel = "status"
+
+	// Manufacturer shown in the device registry of Home Assistant
+	Manufacturer = "WiZ"
+
+	// Schema of the MQTT light. Templates map the PilotState JSON of the bridge topics without a translation layer
+	Schema = "template"
+)
+
+// Topics represents the MQTT topics of a device, as published by the bridge
+type Topics struct {
+	State        string   // State receives the JSON PilotState of the device
+	Command      string   // Command accepts a JSON PilotState to apply
+	Availability []string // Availability topics, all of them must be 'online' for the device to be available
+}
+
+// Availability represents an availability topic in a discovery payload
+type Availability struct {
+	Topic               string `json:"topic"`
+	PayloadAvailable    string `json:"payload_available,omitempty"`
+	PayloadNotAvailable string `json:"payload_not_available,omitempty"`
+}
+
+// Device represents the entry of the device registry of Home Assistant the light belongs to
+type Device struct {
+	Identifiers  []string    `json:"identifiers"`
+	Connections  [][2]string `json:"connections,omitempty"`
+	Name         string      `json:"name,omitempty"`
+	Manufacturer string      `json:"manufacturer,omitempty"`
+	Model        string      `json:"model,omitempty"`
+	SwVersion    string      `json:"sw_version,omitempty"`
+}
+
+// LightConfig represents the discovery payload of an MQTT light using the template schema
+type LightConfig struct {
+	Name             *string        `json:"name"` // Name is null, so the light takes the name of its device
+	UniqueId         string         `json:"unique_id"`
+	Schema           string         `json:"schema"`
+	StateTopic       string         `json:"state_topic"`
+	CommandTopic     string         `json:"command_topic"`
+	Availability     []Availability `json:"availability,omitempty"`
+	AvailabilityMode string         `json:"availability_mode,omitempty"`
+
+	CommandOnTemplate  string `json:"command_on_template"`
+	CommandOffTemplate string `json:"command_off_template"`
+	StateTemplate      string `json:"state_template"`
+	BrightnessTemplate string `json:"brightness_template,omitempty"`
+	RedTemplate        string `json:"red_template,omitempty"`
+	GreenTemplate      string `json:"green_template,omitempty"`
+	BlueTemplate       string `json:"blue_template,omitempty"`
+	ColorTempTemplate  string `json:"color_temp_template,omitempty"`
+	EffectTemplate     string `json:"effect_template,omitempty"`
+
+	EffectList []string `json:"effect_list,omitempty"`
+	MinMireds  int      `json:"min_mireds,omitempty"` // MinMireds matches the highest temperature of the device
+	MaxMireds  int      `json:"max_mireds,omitempty"` // MaxMireds matches the lowest temperature of the device
+
+	Device Device `json:"device"`
+}
+
+// ConfigTopic return the topic where the discovery payload of the device with the given MAC is published
+func ConfigTopic(discoveryPrefix string, mac string) string {
+	if discoveryPrefix == "" {
+		discoveryPrefix = DefaultDiscoveryPrefix
+	}
+	return fmt.Sprintf("%s/light/%s/config", discoveryPrefix, wizgo.NormalizeMac(mac))
+}
+
+// StatusTopic return the topic where Home Assistant announces it is 'online', so payloads must be published again
+func StatusTopic(discoveryPrefix string) string {
+	if discoveryPrefix == "" {
+		discoveryPrefix = DefaultDiscoveryPrefix
+	}
+	return discoveryPrefix + "/" + StatusTopicLevel
+}
+
+// CreateLightConfig return the discovery payload of the device, typed after its capabilities:
+// colors for RGB devices, white temperature in the advertised range for RGB and TW devices,
+// brightness for dimmable ones, and the scenes each class can play as effects
+func CreateLightConfig(device wizgo.InventoryDevice, capabilities wizgo.Capabilities, topics Topics) LightConfig {
+
+	mac := wizgo.NormalizeMac(device.Mac)
+
+	name := device.Name
+	if name == "" {
+		name = fmt.Sprintf("%s %s", Manufacturer, mac)
+	}
+
+	moduleName := capabilities.ModuleName
+	if moduleName == "" {
+		moduleName = device.ModuleName
+	}
+
+	fwVersion := capabilities.FwVersion
+	if fwVersion == "" {
+		fwVersion = device.FwVersion
+	}
+
+	config := LightConfig{
+		UniqueId:     "wizgo_" + mac,
+		Schema:       Schema,
+		StateTopic:   topics.State,
+		CommandTopic: topics.Command,
+		Device: Device{
+			Identifiers:  []string{mac},
+			Connections:  [][2]string{{"mac", colonMac(mac)}},
+			Name:         name,
+			Manufacturer: Manufacturer,
+			Model:        moduleName,
+			SwVersion:    fwVersion,
+		},
+
+		CommandOffTemplate: `{"state": false}`,
+		StateTemplate:      `{{ "on" if value_json.state else "off" }}`,
+	}
+
+	for _, topic := range topics.Availability {
+		config.Availability = append(config.Availability, Availability{Topic: topic})
+	}
+	if len(config.Availability) > 1 {
+		config.AvailabilityMode = "all"
+	}
+
+	// Fields are added to the 'on' command only when Home Assistant gives them
+	commandOn := []string{`"state": true`}
+
+	if capabilities.Brightness {
+		commandOn = append(commandOn,
+			fmt.Sprintf(`{%% if brightness is defined %%}, "dimming": {{ [%d, (brightness * 100 / 255) | round(0) | int] | max }}{%% endif %%}`, wizgo.MinBrightness))
+		config.BrightnessTemplate = `{{ ((value_json.dimming | default(100)) * 255 / 100) | round(0) | int }}`
+	}
+
+	if capabilities.Color {
+		commandOn = append(commandOn,
+			`{% if red is defined and green is defined and blue is defined %}, "r": {{ red }}, "g": {{ green }}, "b": {{ blue }}{% endif %}`)
+		config.RedTemplate = `{{ value_json.r | default(0) }}`
+		config.GreenTemplate = `{{ value_json.g | default(0) }}`
+		config.BlueTemplate = `{{ value_json.b | default(0) }}`
+	}
+
+	if capabilities.ColorTemperature {
+		kelvinMin, kelvinMax := capabilities.KelvinMin, capabilities.KelvinMax
+		if kelvinMax == 0 {
+			kelvinMin, kelvinMax = wizgo.MinTemperature, wizgo.MaxTemperature
+		}
+
+		config.MinMireds = mireds(kelvinMax)
+		config.MaxMireds = mireds(kelvinMin)
+
+		commandOn = append(commandOn,
+			`{% if color_temp is defined %}, "temp": {{ (1000000 / color_temp) | round(0) | int }}{% endif %}`)
+		config.ColorTempTemplate = `{% if value_json.temp is defined and value_json.temp > 0 %}{{ (1000000 / value_json.temp) | round(0) | int }}{% endif %}`
+	}
+
+	if scenes := capabilities.Scenes(); len(scenes) > 0 {
+		idsByName := make([]string, 0, len(scenes))
+		namesById := make([]string, 0, len(scenes))
+
+		for _, sceneId := range scenes {
+			sceneName := wizgo.WizScenes[sceneId]
+			config.EffectList = append(config.EffectList, sceneName)
+			idsByName = append(idsByName, fmt.Sprintf("%q: %d", sceneName, sceneId))
+			namesById = append(namesById, fmt.Sprintf("%d: %q", sceneId, sceneName))
+		}
+
+		commandOn = append(commandOn, fmt.Sprintf(
+			`{%% if effect is defined %%}{%% set scenes = {%s} %%}, "sceneId": {{ scenes[effect] }}{%% endif %%}`,
+			strings.Join(idsByName, ", ")))
+		config.EffectTemplate = fmt.Sprintf(
+			`{%% set scenes = {%s} %%}{{ scenes[value_json.sceneId] if value_json.sceneId is defined and value_json.sceneId in scenes else "" }}`,
+			strings.Join(namesById, ", "))
+	}
+
+	config.CommandOnTemplate = "{" + strings.Join(commandOn, "") + "}"
+
+	return config
+}
+
+// Payload return the config encoded as JSON, keeping the templates readable
+func (c LightConfig) Payload() ([]byte, error) {
+
+	buffer := &bytes.Buffer{}
+	encoder := json.NewEncoder(buffer)
+	encoder.SetEscapeHTML(false)
+
+	if err := encoder.Encode(c); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSpace(buffer.Bytes()), nil
+}
+
+// mireds return the given temperature in mireds, the unit used by Home Assistant
+func mireds(kelvin int) int {
+	return int(math.Round(1000000 / float64(kelvin)))
+}
+
+// colonMac return the MAC in the colon-separated form used by the device registry
+func colonMac(mac string) string {
+
+	if len(mac) != 12 {
+		return mac
+	}
+
+	pairs := make([]string, 0, 6)
+	for index := 0; index < len(mac); index += 2 {
+		pairs = append(pairs, mac[index:index+2])
+	}
+	return strings.Join(pairs, ":")
+}
@@ -0,0 +1,180 @@
+package homeassistant
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/achetronic/wizgo/pkg/emulator"
+	"github.com/achetronic/wizgo/pkg/wizgo"
+)
+
+// capabilitiesOf return the capabilities reported by an emulated device of the given model
+func capabilitiesOf(t *testing.T, model emulator.Model) (device *emulator.Emulator, capabilities wizgo.Capabilities) {
+	t.Helper()
+
+	device, err := emulator.Start(emulator.Options{Model: model, FwVersion: "1.28.0"})
+	if err != nil {
+		t.Fatalf("error starting the emulator: %s", err)
+	}
+	t.Cleanup(func() { _ = device.Close() })
+
+	wizClient, err := wizgo.CreateWizClient(device.Host(), device.Port())
+	if err != nil {
+		t.Fatalf("error creating the client: %s", err)
+	}
+	t.Cleanup(func() { _ = wizClient.Close() })
+
+	capabilities, err = wizClient.Capabilities()
+	if err != nil {
+		t.Fatalf("error reading the capabilities: %s", err)
+	}
+	return device, capabilities
+}
+
+func TestCreateLightConfig(t *testing.T) {
+
+	tests := []struct {
+		name  string
+		model emulator.Model
+
+		wantTemplates []string // wantTemplates holds the templates expected in the payload, the rest must be missing
+		wantCommands  []string // wantCommands holds the fields the 'on' command can set, besides the state
+		wantMireds    [2]int   // wantMireds holds the min and max mireds, zero when there is no white temperature
+		wantEffects   int
+	}{
+		{
+			name:          "RGB",
+			model:         emulator.ModelRgb,
+			wantTemplates: []string{"brightness_template", "red_template", "green_template", "blue_template", "color_temp_template", "effect_template"},
+			wantCommands:  []string{`"dimming"`, `"r"`, `"g"`, `"b"`, `"temp"`, `"sceneId"`},
+			wantMireds:    [2]int{154, 455},
+			wantEffects:   len(wizgo.WizScenes),
+		},
+		{
+			name:          "TW",
+			model:         emulator.ModelTw,
+			wantTemplates: []string{"brightness_template", "color_temp_template", "effect_template"},
+			wantCommands:  []string{`"dimming"`, `"temp"`, `"sceneId"`},
+			wantMireds:    [2]int{154, 370},
+			wantEffects:   len(wizgo.WizTwScenes),
+		},
+		{
+			name:          "DW",
+			model:         emulator.ModelDw,
+			wantTemplates: []string{"brightness_template", "effect_template"},
+			wantCommands:  []string{`"dimming"`, `"sceneId"`},
+			wantEffects:   len(wizgo.WizDwScenes),
+		},
+		{
+			name:  "socket",
+			model: emulator.ModelSocket,
+		},
+	}
+
+	templates := []string{"brightness_template", "red_template", "green_template", "blue_template", "color_temp_template", "effect_template"}
+	commands := []string{`"dimming"`, `"r"`, `"g"`, `"b"`, `"temp"`, `"sceneId"`}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			device, capabilities := capabilitiesOf(t, test.model)
+
+			inventoryDevice := wizgo.InventoryDevice{DiscoveredDevice: wizgo.DiscoveredDevice{Mac: device.Mac()}, Name: "desk"}
+			config := CreateLightConfig(inventoryDevice, capabilities, Topics{
+				State:        "wizgo/desk/state",
+				Command:      "wizgo/desk/set",
+				Availability: []string{"wizgo/status", "wizgo/desk/availability"},
+			})
+
+			payload, err := config.Payload()
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			// Templates compare with '>', which must reach Home Assistant as it is
+			if strings.Contains(string(payload), `\u003e`) {
+				t.Errorf("expected the templates not escaped, got %s", payload)
+			}
+
+			var decoded map[string]any
+			if err = json.Unmarshal(payload, &decoded); err != nil {
+				t.Fatalf("error decoding the payload: %s", err)
+			}
+
+			mac := wizgo.NormalizeMac(device.Mac())
+			if name, found := decoded["name"]; !found || name != nil {
+				t.Errorf("expected a null name, so the light takes the name of the device, got %v", name)
+			}
+			if decoded["unique_id"] != "wizgo_"+mac || decoded["schema"] != Schema || decoded["availability_mode"] != "all" {
+				t.Errorf("expected the light of %s with the template schema and all the availability topics, got %s", mac, payload)
+			}
+
+			wantDevice := Device{
+				Identifiers:  []string{mac},
+				Connections:  [][2]string{{"mac", colonMac(mac)}},
+				Name:         "desk",
+				Manufacturer: Manufacturer,
+				Model:        test.model.ModuleName,
+				SwVersion:    "1.28.0",
+			}
+			if !reflect.DeepEqual(config.Device, wantDevice) {
+				t.Errorf("expected the device %+v, got %+v", wantDevice, config.Device)
+			}
+
+			for _, template := range templates {
+				_, found := decoded[template]
+				want := false
+				for _, wantTemplate := range test.wantTemplates {
+					want = want || wantTemplate == template
+				}
+				if found != want {
+					t.Errorf("expected '%s' in the payload to be %t", template, want)
+				}
+			}
+
+			for _, command := range commands {
+				found := strings.Contains(config.CommandOnTemplate, command)
+				want := false
+				for _, wantCommand := range test.wantCommands {
+					want = want || wantCommand == command
+				}
+				if found != want {
+					t.Errorf("expected %s in the 'on' command to be %t, got: %s", command, want, config.CommandOnTemplate)
+				}
+			}
+
+			if mireds := [2]int{config.MinMireds, config.MaxMireds}; mireds != test.wantMireds {
+				t.Errorf("expected the mireds %v, got %v", test.wantMireds, mireds)
+			}
+			if len(config.EffectList) != test.wantEffects {
+				t.Errorf("expected %d effects, got %d", test.wantEffects, len(config.EffectList))
+			}
+			for _, sceneId := range capabilities.Scenes() {
+				if !strings.Contains(config.EffectTemplate, wizgo.WizScenes[sceneId]) {
+					t.Errorf("expected the scene '%s' in the effect template", wizgo.WizScenes[sceneId])
+				}
+			}
+		})
+	}
+}
+
+func TestConfigTopic(t *testing.T) {
+
+	tests := []struct {
+		prefix string
+		mac    string
+		want   string
+	}{
+		{prefix: "", mac: "A8:BB:50:00:00:01", want: "homeassistant/light/a8bb50000001/config"},
+		{prefix: "ha", mac: "a8bb50000001", want: "ha/light/a8bb50000001/config"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.want, func(t *testing.T) {
+			if topic := ConfigTopic(test.prefix, test.mac); topic != test.want {
+				t.Errorf("expected '%s', got '%s'", test.want, topic)
+			}
+		})
+	}
+}
@@ -0,0 +1,502 @@
+package mqttbridge
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+
+	wizgotypes "github.com/achetronic/wizgo/api/types"
+	"github.com/achetronic/wizgo/pkg/homeassistant"
+	"github.com/achetronic/wizgo/pkg/wizgo"
+)
+
+const (
+	// DefaultTopicPrefix is the first level of all the topics when no other is given
+	DefaultTopicPrefix = "wizgo"
+
+	// DefaultPollInterval is how often the state of the devices is read when no other is given
+	DefaultPollInterval = 30 * time.Second
+
+	// DefaultClientId is the MQTT client id used when no other is given
+	DefaultClientId = "wizgo-bridge"
+
+	// Payloads of the availability topics
+	PayloadOnline  = "online"
+	PayloadOffline = "offline"
+
+	// Levels of the topics under the prefix
+	StateTopicLevel        = "state"
+	SetTopicLevel          = "set"
+	AvailabilityTopicLevel = "availability"
+	BridgeTopicLevel       = "bridge"
+
+	// Error messages
+	ConnectErrorMessage   = "error connecting to the broker: %s"
+	SubscribeErrorMessage = "error subscribing to '%s': %s"
+	PublishErrorMessage   = "error publishing to '%s': %s"
+	CommandErrorMessage   = "error applying command from '%s': %s"
+)
+
+// Options represents the settings used when creating a Bridge
+type Options struct {
+	// Broker is the URL of the MQTT broker. I.E: 'tcp://localhost:1883'
+	Broker   string
+	ClientId string // ClientId of the bridge on the broker. Default: DefaultClientId
+	Username string
+	Password string
+
+	// TopicPrefix is the first level of all the topics. Default: DefaultTopicPrefix
+	TopicPrefix string
+
+	// Qos used for publishing and subscribing. Default: 0
+	Qos byte
+
+	// PollInterval is how often the state of the devices is read. Negative disables polling,
+	// which only makes sense when Listener is set. Default: DefaultPollInterval
+	PollInterval time.Duration
+
+	// Listener enables receiving the state changes pushed by the devices with 'syncPilot', when set.
+	// Its Handler is replaced by the bridge
+	Listener *wizgo.ListenerOptions
+
+	// HomeAssistant enables publishing the discovery payloads of Home Assistant, so devices appear there
+	// correctly typed. Payloads are published again when Home Assistant announces it is online
+	HomeAssistant bool
+
+	// HomeAssistantPrefix is the discovery prefix configured in Home Assistant. Default: homeassistant.DefaultDiscoveryPrefix
+	HomeAssistantPrefix string
+
+	// Logger receives the errors that can not be returned, such as failed commands. Default: the standard logger
+	Logger *log.Logger
+}
+
+// Bridge publishes the state of the devices of an inventory to an MQTT broker, and applies the commands
+// received from it. Topics, under the prefix:
+//
+//	<prefix>/<mac>/state         retained PilotState of the device, as JSON
+//	<prefix>/<mac>/set           JSON PilotState to apply with a single 'setPilot'
+//	<prefix>/<mac>/availability  retained 'online' or 'offline', depending on whether the device answers
+//	<prefix>/bridge/availability retained 'online' or 'offline', set by the broker through the LWT
+type Bridge struct {
+	inventory *wizgo.Inventory
+	options   Options
+	client    mqtt.Client
+
+	// published holds the last state and availability published per device, to publish only changes
+	published      map[string]wizgotypes.PilotState
+	availability   map[string]string
+	announced      map[string]bool
+	publishedMutex sync.Mutex
+}
+
+// CreateBridge creates a bridge for the devices of the given inventory. The inventory is not refreshed
+// by the bridge, so devices must be discovered or added by the caller
+func CreateBridge(inventory *wizgo.Inventory, options Options) *Bridge {
+
+	if options.TopicPrefix == "" {
+		options.TopicPrefix = DefaultTopicPrefix
+	}
+
+	if options.ClientId == "" {
+		options.ClientId = DefaultClientId
+	}
+
+	if options.PollInterval == 0 {
+		options.PollInterval = DefaultPollInterval
+	}
+
+	if options.HomeAssistantPrefix == "" {
+		options.HomeAssistantPrefix = homeassistant.DefaultDiscoveryPrefix
+	}
+
+	if options.Logger == nil {
+		options.Logger = log.Default()
+	}
+
+	b := &Bridge{
+		inventory:    inventory,
+		options:      options,
+		published:    map[string]wizgotypes.PilotState{},
+		availability: map[string]string{},
+		announced:    map[string]bool{},
+	}
+
+	clientOptions := mqtt.NewClientOptions().
+		AddBroker(options.Broker).
+		SetClientID(options.ClientId).
+		SetUsername(options.Username).
+		SetPassword(options.Password).
+		SetAutoReconnect(true).
+		SetOnConnectHandler(b.onConnect)
+	SetWill(clientOptions, b.BridgeAvailabilityTopic(), options.Qos)
+
+	b.client = mqtt.NewClient(clientOptions)
+	return b
+}
+
+// SetWill configures the broker to publish 'offline' as retained message on the given topic when the
+// connection is lost without disconnecting
+func SetWill(clientOptions *mqtt.ClientOptions, topic string, qos byte) *mqtt.ClientOptions {
+	return clientOptions.SetWill(topic, PayloadOffline, qos, true)
+}
+
+// StateTopic return the topic where the state of the device is published
+func (b *Bridge) StateTopic(mac string) string {
+	return b.topic(wizgo.NormalizeMac(mac), StateTopicLevel)
+}
+
+// SetTopic return the topic where the commands for the device are received
+func (b *Bridge) SetTopic(mac string) string {
+	return b.topic(wizgo.NormalizeMac(mac), SetTopicLevel)
+}
+
+// AvailabilityTopic return the topic where the availability of the device is published
+func (b *Bridge) AvailabilityTopic(mac string) string {
+	return b.topic(wizgo.NormalizeMac(mac), AvailabilityTopicLevel)
+}
+
+// BridgeAvailabilityTopic return the topic where the availability of the bridge is published
+func (b *Bridge) BridgeAvailabilityTopic() string {
+	return b.topic(BridgeTopicLevel, AvailabilityTopicLevel)
+}
+
+// Client return the MQTT client of the bridge, so other publishers can share its connection
+func (b *Bridge) Client() mqtt.Client {
+	return b.client
+}
+
+// topic joins the given levels under the prefix
+func (b *Bridge) topic(levels ...string) string {
+	return strings.Join(append([]string{b.options.TopicPrefix}, levels...), "/")
+}
+
+// Run connects to the broker and bridges the devices until the context is done.
+// The bridge is marked as offline before disconnecting
+func (b *Bridge) Run(ctx context.Context) (err error) {
+
+	token := b.client.Connect()
+	if err = waitToken(ctx, token); err != nil {
+		return errors.New(fmt.Sprintf(ConnectErrorMessage, err))
+	}
+
+	defer func() {
+		_ = b.publish(b.BridgeAvailabilityTopic(), PayloadOffline)
+		b.client.Disconnect(250)
+	}()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var listener *wizgo.Listener
+	if b.options.Listener != nil {
+		listenerOptions := *b.options.Listener
+		listenerOptions.Handler = b.onListenerEvent
+
+		listener, err = wizgo.CreateListener(listenerOptions)
+		if err != nil {
+			return err
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = listener.Run(ctx)
+		}()
+	}
+
+	registered := map[string]bool{}
+	var ticker <-chan time.Time
+	if b.options.PollInterval > 0 {
+		pollTicker := time.NewTicker(b.options.PollInterval)
+		defer pollTicker.Stop()
+		ticker = pollTicker.C
+	}
+
+	for {
+		// New devices may join the inventory at any time
+		if listener != nil {
+			for _, device := range b.inventory.Devices() {
+				if registered[device.Mac] {
+					continue
+				}
+				if wizClient, err := b.inventory.Client(device.Mac); err == nil {
+					listener.Register(wizClient)
+					registered[device.Mac] = true
+				}
+			}
+		}
+
+		b.Poll(ctx)
+
+		select {
+		case <-ticker:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// Poll reads the state of all the devices of the inventory concurrently and publishes the changes
+func (b *Bridge) Poll(ctx context.Context) {
+
+	var wg sync.WaitGroup
+	for _, device := range b.inventory.Devices() {
+		wg.Add(1)
+		go func(mac string) {
+			defer wg.Done()
+			b.pollDevice(ctx, mac)
+		}(device.Mac)
+	}
+	wg.Wait()
+}
+
+// pollDevice reads the state of one device and publishes it, along with its availability
+func (b *Bridge) pollDevice(ctx context.Context, mac string) {
+
+	wizClient, err := b.inventory.Client(mac)
+	if err != nil {
+		return
+	}
+
+	state, err := wizClient.ReadPilotStateContext(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			b.publishAvailability(mac, PayloadOffline)
+		}
+		return
+	}
+
+	b.announce(ctx, mac)
+	b.publishAvailability(mac, PayloadOnline)
+	b.publishState(mac, state)
+}
+
+// onConnect announces the bridge and subscribes to the commands. It runs on every reconnection
+func (b *Bridge) onConnect(client mqtt.Client) {
+
+	if err := b.publish(b.BridgeAvailabilityTopic(), PayloadOnline); err != nil {
+		b.options.Logger.Print(err)
+	}
+
+	// Retained messages are published again, as the broker may have lost them
+	b.publishedMutex.Lock()
+	b.published = map[string]wizgotypes.PilotState{}
+	b.availability = map[string]string{}
+	b.announced = map[string]bool{}
+	b.publishedMutex.Unlock()
+
+	topic := b.topic("+", SetTopicLevel)
+	token := client.Subscribe(topic, b.options.Qos, b.onCommand)
+	if token.Wait() && token.Error() != nil {
+		b.options.Logger.Printf(SubscribeErrorMessage, topic, token.Error())
+	}
+
+	if !b.options.HomeAssistant {
+		return
+	}
+
+	topic = homeassistant.StatusTopic(b.options.HomeAssistantPrefix)
+	token = client.Subscribe(topic, b.options.Qos, b.onHomeAssistantStatus)
+	if token.Wait() && token.Error() != nil {
+		b.options.Logger.Printf(SubscribeErrorMessage, topic, token.Error())
+	}
+}
+
+// onHomeAssistantStatus publishes the discovery payloads again when Home Assistant starts,
+// as it may not keep them
+func (b *Bridge) onHomeAssistantStatus(client mqtt.Client, message mqtt.Message) {
+
+	if string(message.Payload()) != PayloadOnline {
+		return
+	}
+
+	b.publishedMutex.Lock()
+	b.announced = map[string]bool{}
+	b.publishedMutex.Unlock()
+
+	go func() {
+		for _, device := range b.inventory.Devices() {
+			b.announce(context.Background(), device.Mac)
+		}
+	}()
+}
+
+// announce publishes the discovery payload of the device for Home Assistant, once per connection
+func (b *Bridge) announce(ctx context.Context, mac string) {
+
+	if !b.options.HomeAssistant {
+		return
+	}
+
+	b.publishedMutex.Lock()
+	announced := b.announced[mac]
+	b.announced[mac] = true
+	b.publishedMutex.Unlock()
+
+	if announced {
+		return
+	}
+
+	err := b.publishHomeAssistantConfig(ctx, mac)
+	if err != nil {
+		b.publishedMutex.Lock()
+		delete(b.announced, mac)
+		b.publishedMutex.Unlock()
+		b.options.Logger.Print(err)
+	}
+}
+
+// publishHomeAssistantConfig publishes the discovery payload built from the capabilities of the device
+func (b *Bridge) publishHomeAssistantConfig(ctx context.Context, mac string) (err error) {
+
+	device, found := b.inventory.Device(mac)
+	if !found {
+		return nil
+	}
+
+	wizClient, err := b.inventory.Client(mac)
+	if err != nil {
+		return err
+	}
+
+	capabilities, err := wizClient.CapabilitiesContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	config := homeassistant.CreateLightConfig(device, capabilities, homeassistant.Topics{
+		State:        b.StateTopic(mac),
+		Command:      b.SetTopic(mac),
+		Availability: []string{b.BridgeAvailabilityTopic(), b.AvailabilityTopic(mac)},
+	})
+
+	payload, err := config.Payload()
+	if err != nil {
+		return err
+	}
+
+	return b.publish(homeassistant.ConfigTopic(b.options.HomeAssistantPrefix, mac), string(payload))
+}
+
+// onCommand applies a JSON PilotState received on a set topic, and publishes the resulting state
+func (b *Bridge) onCommand(client mqtt.Client, message mqtt.Message) {
+
+	levels := strings.Split(message.Topic(), "/")
+	if len(levels) < 2 {
+		return
+	}
+	mac := wizgo.NormalizeMac(levels[len(levels)-2])
+
+	var state wizgotypes.PilotState
+	if err := json.Unmarshal(message.Payload(), &state); err != nil {
+		b.options.Logger.Printf(CommandErrorMessage, message.Topic(), err)
+		return
+	}
+
+	// Commands are applied in their own goroutine, so the MQTT client is not blocked by slow devices
+	go func() {
+		wizClient, err := b.inventory.Client(mac)
+		if err != nil {
+			b.options.Logger.Printf(CommandErrorMessage, message.Topic(), err)
+			return
+		}
+
+		_, err = wizClient.SetPilot(wizgo.CreatePilotBuilderFromState(state))
+		if err != nil {
+			b.options.Logger.Printf(CommandErrorMessage, message.Topic(), err)
+			return
+		}
+
+		b.pollDevice(context.Background(), mac)
+	}()
+}
+
+// onListenerEvent publishes the states pushed by the devices
+func (b *Bridge) onListenerEvent(event wizgo.ListenerEvent) {
+
+	if event.Method != wizgo.SyncPilotMethod || event.Mac == "" {
+		return
+	}
+
+	// Only the state is published. The source of the change is not part of it
+	state := event.Pilot
+	state.Src = ""
+
+	// Announcing may need to ask the device, so the reading goroutine is not blocked
+	go b.announce(context.Background(), event.Mac)
+
+	b.publishAvailability(event.Mac, PayloadOnline)
+	b.publishState(event.Mac, state)
+}
+
+// publishState publishes the state of the device when it changed since the last time
+func (b *Bridge) publishState(mac string, state wizgotypes.PilotState) {
+
+	state.Mac = mac
+
+	b.publishedMutex.Lock()
+	previous, found := b.published[mac]
+	b.published[mac] = state
+	b.publishedMutex.Unlock()
+
+	if found && reflect.DeepEqual(previous, state) {
+		return
+	}
+
+	payload, err := json.Marshal(state)
+	if err != nil {
+		return
+	}
+
+	if err = b.publish(b.StateTopic(mac), string(payload)); err != nil {
+		b.options.Logger.Print(err)
+	}
+}
+
+// publishAvailability publishes the availability of the device when it changed since the last time
+func (b *Bridge) publishAvailability(mac string, availability string) {
+
+	b.publishedMutex.Lock()
+	previous := b.availability[mac]
+	b.availability[mac] = availability
+	b.publishedMutex.Unlock()
+
+	if previous == availability {
+		return
+	}
+
+	if err := b.publish(b.AvailabilityTopic(mac), availability); err != nil {
+		b.options.Logger.Print(err)
+	}
+}
+
+// publish sends a retained message and waits for the broker to acknowledge it
+func (b *Bridge) publish(topic string, payload string) error {
+
+	token := b.client.Publish(topic, b.options.Qos, true, payload)
+	if token.Wait() && token.Error() != nil {
+		return errors.New(fmt.Sprintf(PublishErrorMessage, topic, token.Error()))
+	}
+	return nil
+}
+
+// waitToken waits for the token to complete or the context to be done
+func waitToken(ctx context.Context, token mqtt.Token) error {
+	select {
+	case <-token.Done():
+		return token.Error()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
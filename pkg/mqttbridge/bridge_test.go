@@ -0,0 +1,255 @@
+package mqttbridge
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	mochi "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/auth"
+	"github.com/mochi-mqtt/server/v2/listeners"
+
+	wizgotypes "github.com/achetronic/wizgo/api/types"
+	"github.com/achetronic/wizgo/pkg/emulator"
+	"github.com/achetronic/wizgo/pkg/wizgo"
+)
+
+// waitTimeout bounds the wait for each expected message
+const waitTimeout = 5 * time.Second
+
+// startBroker starts an in-process MQTT broker that is closed when the test ends
+func startBroker(t *testing.T) (broker *mochi.Server, address string) {
+	t.Helper()
+
+	broker = mochi.New(&mochi.Options{Logger: slog.New(slog.NewTextHandler(io.Discard, nil))})
+	if err := broker.AddHook(new(auth.AllowHook), nil); err != nil {
+		t.Fatalf("error configuring the broker: %s", err)
+	}
+
+	listener := listeners.NewTCP(listeners.Config{ID: "tcp", Address: "127.0.0.1:0"})
+	if err := broker.AddListener(listener); err != nil {
+		t.Fatalf("error starting the broker: %s", err)
+	}
+
+	if err := broker.Serve(); err != nil {
+		t.Fatalf("error starting the broker: %s", err)
+	}
+	t.Cleanup(func() { _ = broker.Close() })
+
+	return broker, "tcp://" + listener.Address()
+}
+
+// startEmulator starts an emulated device that is closed when the test ends
+func startEmulator(t *testing.T, options emulator.Options) *emulator.Emulator {
+	t.Helper()
+
+	device, err := emulator.Start(options)
+	if err != nil {
+		t.Fatalf("error starting the emulator: %s", err)
+	}
+	t.Cleanup(func() { _ = device.Close() })
+
+	return device
+}
+
+// connect connects a new client to the broker that is disconnected when the test ends
+func connect(t *testing.T, broker string, clientId string) mqtt.Client {
+	t.Helper()
+
+	client := mqtt.NewClient(mqtt.NewClientOptions().AddBroker(broker).SetClientID(clientId))
+	if token := client.Connect(); !token.WaitTimeout(waitTimeout) || token.Error() != nil {
+		t.Fatalf("error connecting to the broker: %v", token.Error())
+	}
+	t.Cleanup(func() { client.Disconnect(0) })
+
+	return client
+}
+
+// recorder keeps the messages received by a subscriber, in order
+type recorder struct {
+	mutex    sync.Mutex
+	messages []mqtt.Message
+}
+
+// subscribe connects a new client to the broker and records the messages received on the topic
+func subscribe(t *testing.T, broker string, clientId string, topic string) *recorder {
+	t.Helper()
+
+	r := &recorder{}
+
+	token := connect(t, broker, clientId).Subscribe(topic, 1, func(client mqtt.Client, message mqtt.Message) {
+		r.mutex.Lock()
+		r.messages = append(r.messages, message)
+		r.mutex.Unlock()
+	})
+	if !token.WaitTimeout(waitTimeout) || token.Error() != nil {
+		t.Fatalf("error subscribing to '%s': %v", topic, token.Error())
+	}
+
+	return r
+}
+
+// count return the number of messages recorded so far
+func (r *recorder) count() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return len(r.messages)
+}
+
+// wait return the first message recorded on the topic from the given position on, satisfying the condition,
+// along with its position. It waits for the message when it was not received yet
+func (r *recorder) wait(t *testing.T, from int, topic string, condition func(message mqtt.Message) bool) (message mqtt.Message, index int) {
+	t.Helper()
+
+	deadline := time.Now().Add(waitTimeout)
+	for time.Now().Before(deadline) {
+		r.mutex.Lock()
+		messages := r.messages
+		r.mutex.Unlock()
+
+		for index = from; index < len(messages); index++ {
+			if messages[index].Topic() == topic && condition(messages[index]) {
+				return messages[index], index
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatalf("no expected message received on '%s'", topic)
+	return message, index
+}
+
+// payloadIs return a condition matching the messages with the given payload
+func payloadIs(payload string) func(message mqtt.Message) bool {
+	return func(message mqtt.Message) bool {
+		return string(message.Payload()) == payload
+	}
+}
+
+// anyMessage is a condition matching all the messages
+func anyMessage(message mqtt.Message) bool {
+	return true
+}
+
+// statePayload return the payload published for the given state of the device
+func statePayload(t *testing.T, mac string, state wizgotypes.PilotState) string {
+	t.Helper()
+
+	state.Mac = mac
+	payload, err := json.Marshal(state)
+	if err != nil {
+		t.Fatalf("error encoding the state: %s", err)
+	}
+	return string(payload)
+}
+
+func TestBridge(t *testing.T) {
+
+	broker, address := startBroker(t)
+
+	light := startEmulator(t, emulator.Options{})
+	unreachable := startEmulator(t, emulator.Options{LossRate: 1})
+
+	inventory := wizgo.CreateInventory(wizgo.WizClientOptions{Timeout: 200 * time.Millisecond, RetryPolicy: wizgo.NoRetryPolicy})
+	t.Cleanup(func() { _ = inventory.Close() })
+	for _, device := range []*emulator.Emulator{light, unreachable} {
+		inventory.Update(wizgo.DiscoveredDevice{Ip: device.Host(), Port: device.Port(), Mac: device.Mac()})
+	}
+
+	bridge := CreateBridge(inventory, Options{
+		Broker:       address,
+		PollInterval: 100 * time.Millisecond,
+		Logger:       log.New(io.Discard, "", 0),
+	})
+
+	messages := subscribe(t, address, "observer", DefaultTopicPrefix+"/#")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- bridge.Run(ctx)
+	}()
+
+	t.Run("bridge availability", func(t *testing.T) {
+		messages.wait(t, 0, bridge.BridgeAvailabilityTopic(), payloadIs(PayloadOnline))
+	})
+
+	t.Run("device availability", func(t *testing.T) {
+		messages.wait(t, 0, bridge.AvailabilityTopic(light.Mac()), payloadIs(PayloadOnline))
+		messages.wait(t, 0, bridge.AvailabilityTopic(unreachable.Mac()), payloadIs(PayloadOffline))
+	})
+
+	t.Run("retained state", func(t *testing.T) {
+		messages.wait(t, 0, bridge.StateTopic(light.Mac()), payloadIs(statePayload(t, light.Mac(), light.PilotState())))
+
+		// Clients connecting later get the last state from the broker
+		late := subscribe(t, address, "late-observer", bridge.StateTopic(light.Mac()))
+		if message, _ := late.wait(t, 0, bridge.StateTopic(light.Mac()), anyMessage); !message.Retained() {
+			t.Errorf("expected the state retained")
+		}
+	})
+
+	t.Run("set command", func(t *testing.T) {
+		from := messages.count()
+
+		command := `{"r": 0, "g": 255, "b": 0, "dimming": 30}`
+		token := connect(t, address, "publisher").Publish(bridge.SetTopic(light.Mac()), 1, false, command)
+		if !token.WaitTimeout(waitTimeout) || token.Error() != nil {
+			t.Fatalf("error publishing the command: %v", token.Error())
+		}
+
+		// The resulting state is published right after applying the command
+		messages.wait(t, from, bridge.StateTopic(light.Mac()), func(message mqtt.Message) bool {
+			var state wizgotypes.PilotState
+			return json.Unmarshal(message.Payload(), &state) == nil &&
+				state.G != nil && *state.G == 255 && state.Dimming != nil && *state.Dimming == 30
+		})
+
+		frames := 0
+		for _, message := range light.Messages() {
+			if message.Method == "setPilot" {
+				frames++
+			}
+		}
+		if frames != 1 {
+			t.Errorf("expected the command applied with a single 'setPilot', got %d", frames)
+		}
+	})
+
+	t.Run("last will", func(t *testing.T) {
+		client, found := broker.Clients.Get(DefaultClientId)
+		if !found {
+			t.Fatalf("bridge not connected to the broker")
+		}
+
+		// The connection is lost without disconnecting, so the broker publishes the will.
+		// Then the bridge reconnects and announces itself again
+		from := messages.count()
+		client.Stop(errors.New("connection lost"))
+
+		_, index := messages.wait(t, from, bridge.BridgeAvailabilityTopic(), payloadIs(PayloadOffline))
+		messages.wait(t, index+1, bridge.BridgeAvailabilityTopic(), payloadIs(PayloadOnline))
+	})
+
+	t.Run("stop", func(t *testing.T) {
+		cancel()
+		if err := <-done; err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		late := subscribe(t, address, "availability-observer", bridge.BridgeAvailabilityTopic())
+		message, _ := late.wait(t, 0, bridge.BridgeAvailabilityTopic(), anyMessage)
+		if string(message.Payload()) != PayloadOffline || !message.Retained() {
+			t.Errorf("expected the bridge retained as offline, got '%s'", message.Payload())
+		}
+	})
+}
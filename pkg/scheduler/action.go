@@ -0,0 +1,228 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	wizgotypes "github.com/achetronic/wizgo/api/types"
+	"github.com/achetronic/wizgo/pkg/wizgo"
+)
+
+const (
+	// Error messages
+	ActionTypeErrorMessage      = "unknown action type '%s'"
+	ActionTargetsErrorMessage   = "action '%s' needs some devices or rooms"
+	ActionFieldErrorMessage     = "action '%s' needs '%s'"
+	ActionEasingErrorMessage    = "unknown easing '%s': use linear, in, out or inOut"
+	ActionSceneFileErrorMessage = "action '%s' does not take devices or rooms, the scene file selects them"
+)
+
+// ActionType represents what an action does
+type ActionType string
+
+const (
+	ActionTurnOn         ActionType = "turnOn"
+	ActionTurnOff        ActionType = "turnOff"
+	ActionSetPilot       ActionType = "setPilot"       // ActionSetPilot sends Pilot in a single 'setPilot'
+	ActionSetScene       ActionType = "setScene"       // ActionSetScene plays SceneId, at Speed when it is set
+	ActionApplySceneFile ActionType = "applySceneFile" // ActionApplySceneFile applies SceneFile, read on every run
+	ActionTransition     ActionType = "transition"     // ActionTransition fades to Pilot over Duration, following Easing
+)
+
+var (
+	// easings are the easings an action can be given by name
+	easings = map[string]wizgo.Easing{
+		"":       wizgo.EaseLinear,
+		"linear": wizgo.EaseLinear,
+		"in":     wizgo.EaseIn,
+		"out":    wizgo.EaseOut,
+		"inOut":  wizgo.EaseInOut,
+	}
+)
+
+// Targets represents the devices an action is run on
+type Targets struct {
+	Devices []string `json:"devices,omitempty"` // Devices selected by MAC, name or IP, as Inventory.Lookup
+	Rooms   []string `json:"rooms,omitempty"`   // Rooms selected by name or id, as Inventory.Room
+}
+
+// Action represents what a job does. It is plain data, so it can be kept in the job store
+type Action struct {
+	Type ActionType `json:"type"`
+	Targets
+
+	Pilot     *wizgotypes.PilotState `json:"pilot,omitempty"`
+	SceneId   int                    `json:"sceneId,omitempty"`
+	Speed     int                    `json:"speed,omitempty"`
+	SceneFile string                 `json:"sceneFile,omitempty"`
+	Duration  Duration               `json:"duration,omitempty"`
+	Easing    string                 `json:"easing,omitempty"` // Easing of the transition: linear, in, out or inOut. Default: linear
+}
+
+// TurnOn return an action turning on the targets
+func TurnOn(targets Targets) Action {
+	return Action{Type: ActionTurnOn, Targets: targets}
+}
+
+// TurnOff return an action turning off the targets
+func TurnOff(targets Targets) Action {
+	return Action{Type: ActionTurnOff, Targets: targets}
+}
+
+// SetPilot return an action sending the given state to the targets
+func SetPilot(targets Targets, pilot wizgotypes.PilotState) Action {
+	return Action{Type: ActionSetPilot, Targets: targets, Pilot: &pilot}
+}
+
+// SetScene return an action playing a scene on the targets
+func SetScene(targets Targets, sceneId int) Action {
+	return Action{Type: ActionSetScene, Targets: targets, SceneId: sceneId}
+}
+
+// ApplySceneFile return an action applying the scene file at the given path
+func ApplySceneFile(path string) Action {
+	return Action{Type: ActionApplySceneFile, SceneFile: path}
+}
+
+// Transition return an action fading the targets from their current state to the given one
+func Transition(targets Targets, to wizgotypes.PilotState, duration time.Duration, easing string) Action {
+	return Action{Type: ActionTransition, Targets: targets, Pilot: &to, Duration: Duration(duration), Easing: easing}
+}
+
+// Validate checks the action has the fields its type needs, with valid values
+func (a Action) Validate() error {
+
+	hasTargets := len(a.Devices) > 0 || len(a.Rooms) > 0
+
+	switch a.Type {
+	case ActionTurnOn, ActionTurnOff, ActionSetPilot, ActionSetScene, ActionTransition:
+		if !hasTargets {
+			return errors.New(fmt.Sprintf(ActionTargetsErrorMessage, a.Type))
+		}
+
+	case ActionApplySceneFile:
+		if hasTargets {
+			return errors.New(fmt.Sprintf(ActionSceneFileErrorMessage, a.Type))
+		}
+		if a.SceneFile == "" {
+			return errors.New(fmt.Sprintf(ActionFieldErrorMessage, a.Type, "sceneFile"))
+		}
+		return nil
+
+	default:
+		return errors.New(fmt.Sprintf(ActionTypeErrorMessage, a.Type))
+	}
+
+	switch a.Type {
+	case ActionSetPilot:
+		if a.Pilot == nil {
+			return errors.New(fmt.Sprintf(ActionFieldErrorMessage, a.Type, "pilot"))
+		}
+		_, err := wizgo.CreatePilotBuilderFromState(*a.Pilot).Params()
+		return err
+
+	case ActionSetScene:
+		if a.SceneId <= 0 {
+			return errors.New(fmt.Sprintf(ActionFieldErrorMessage, a.Type, "sceneId"))
+		}
+		_, err := a.sceneBuilder().Params()
+		return err
+
+	case ActionTransition:
+		if a.Pilot == nil {
+			return errors.New(fmt.Sprintf(ActionFieldErrorMessage, a.Type, "pilot"))
+		}
+		if a.Duration <= 0 {
+			return errors.New(fmt.Sprintf(ActionFieldErrorMessage, a.Type, "duration"))
+		}
+		if _, found := easings[a.Easing]; !found {
+			return errors.New(fmt.Sprintf(ActionEasingErrorMessage, a.Easing))
+		}
+	}
+
+	return nil
+}
+
+// Run runs the action on the devices of the inventory. It return a GroupError when some of them failed
+func (a Action) Run(ctx context.Context, inventory *wizgo.Inventory) (err error) {
+
+	if err = a.Validate(); err != nil {
+		return err
+	}
+
+	if a.Type == ActionApplySceneFile {
+		scene, err := wizgo.LoadSceneFile(a.SceneFile)
+		if err != nil {
+			return err
+		}
+
+		_, err = inventory.ApplySceneContext(ctx, scene, wizgo.ApplySceneOptions{})
+		return err
+	}
+
+	group, err := a.Targets.Group(inventory)
+	if err != nil {
+		return err
+	}
+
+	switch a.Type {
+	case ActionTurnOn:
+		_, err = group.TurnOnContext(ctx)
+	case ActionTurnOff:
+		_, err = group.TurnOffContext(ctx)
+	case ActionSetPilot:
+		_, err = group.SetPilotContext(ctx, wizgo.CreatePilotBuilderFromState(*a.Pilot))
+	case ActionSetScene:
+		_, err = group.SetPilotContext(ctx, a.sceneBuilder())
+	case ActionTransition:
+		_, err = group.Transition(ctx, wizgotypes.PilotState{}, *a.Pilot, time.Duration(a.Duration), easings[a.Easing])
+	}
+
+	return err
+}
+
+// sceneBuilder return the builder playing the scene of the action
+func (a Action) sceneBuilder() *wizgo.PilotBuilder {
+
+	builder := wizgo.CreatePilotBuilder().Scene(a.SceneId)
+	if a.Speed != 0 {
+		builder.Speed(a.Speed)
+	}
+	return builder
+}
+
+// Group return a group with the devices selected by the targets, each of them once
+func (t Targets) Group(inventory *wizgo.Inventory) (group *wizgo.Group, err error) {
+
+	var devices []wizgo.InventoryDevice
+	seen := map[string]bool{}
+
+	add := func(selected ...wizgo.InventoryDevice) {
+		for _, device := range selected {
+			if !seen[device.Mac] {
+				seen[device.Mac] = true
+				devices = append(devices, device)
+			}
+		}
+	}
+
+	for _, selector := range t.Devices {
+		device, err := inventory.Lookup(selector)
+		if err != nil {
+			return group, err
+		}
+		add(device)
+	}
+
+	for _, selector := range t.Rooms {
+		room, err := inventory.Room(selector)
+		if err != nil {
+			return group, err
+		}
+		add(room.Devices...)
+	}
+
+	return inventory.Group(devices, wizgo.GroupOptions{})
+}
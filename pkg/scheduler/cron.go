@@ -0,0 +1,230 @@
+package scheduler
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// Error messages
+	CronFieldsErrorMessage = "cron expression '%s' must have 5 fields: minute hour day-of-month month day-of-week"
+	CronFieldErrorMessage  = "invalid %s '%s' in cron expression '%s'"
+	CronNeverErrorMessage  = "cron expression '%s' never matches"
+)
+
+var (
+	// cronDescriptors are the shortcuts accepted instead of the 5 fields
+	cronDescriptors = map[string]string{
+		"@yearly":   "0 0 1 1 *",
+		"@annually": "0 0 1 1 *",
+		"@monthly":  "0 0 1 * *",
+		"@weekly":   "0 0 * * 0",
+		"@daily":    "0 0 * * *",
+		"@midnight": "0 0 * * *",
+		"@hourly":   "0 * * * *",
+	}
+
+	monthNames = map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}
+
+	weekdayNames = map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}
+)
+
+// cronField represents the bounds and names of one of the fields of a cron expression
+type cronField struct {
+	name  string
+	min   int
+	max   int
+	names map[string]int
+}
+
+var cronFields = []cronField{
+	{name: "minute", min: 0, max: 59},
+	{name: "hour", min: 0, max: 23},
+	{name: "day of month", min: 1, max: 31},
+	{name: "month", min: 1, max: 12, names: monthNames},
+	{name: "day of week", min: 0, max: 7, names: weekdayNames}, // Both 0 and 7 are Sunday
+}
+
+// Cron represents a parsed cron expression with the standard 5 fields (minute hour day-of-month month day-of-week).
+// Fields accept '*', lists (1,15), ranges (1-5), steps (*/10, 8-18/2), and month and weekday names (JAN, MON).
+// The descriptors @yearly, @monthly, @weekly, @daily and @hourly are accepted too.
+// As in Vixie cron, when both day fields are restricted, a day matches when any of them matches
+type Cron struct {
+	expression string
+	fields     [5]uint64 // fields holds a bit per accepted value of each field
+	anyDay     [2]bool   // anyDay is true for the day fields given as '*'
+}
+
+// ParseCron parses a cron expression
+func ParseCron(expression string) (cron Cron, err error) {
+
+	cron.expression = expression
+
+	text := strings.TrimSpace(expression)
+	if descriptor, found := cronDescriptors[strings.ToLower(text)]; found {
+		text = descriptor
+	}
+
+	parts := strings.Fields(text)
+	if len(parts) != len(cronFields) {
+		return cron, errors.New(fmt.Sprintf(CronFieldsErrorMessage, expression))
+	}
+
+	for index, part := range parts {
+		cron.fields[index], err = parseCronField(part, cronFields[index])
+		if err != nil {
+			return cron, errors.New(fmt.Sprintf(CronFieldErrorMessage, cronFields[index].name, part, expression))
+		}
+	}
+
+	// Sunday is accepted as 7, but matched as 0 like time.Weekday
+	if cron.fields[4]&(1<<7) != 0 {
+		cron.fields[4] |= 1
+	}
+
+	cron.anyDay = [2]bool{parts[2] == "*" || parts[2] == "?", parts[4] == "*" || parts[4] == "?"}
+
+	if cron.Next(time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)).IsZero() {
+		return cron, errors.New(fmt.Sprintf(CronNeverErrorMessage, expression))
+	}
+
+	return cron, nil
+}
+
+// String return the expression the cron was parsed from
+func (c Cron) String() string {
+	return c.expression
+}
+
+// Next return the first time matching the expression strictly after the given one, in its location.
+// Times skipped by daylight saving changes do not match. The zero time is returned when nothing matches
+func (c Cron) Next(after time.Time) time.Time {
+
+	location := after.Location()
+	t := after.Truncate(time.Minute).Add(time.Minute)
+
+	// Matching times are always found within a few years, unless the expression asks for a 30th of February.
+	// Leap days may be 8 years apart, as in 2096 and 2104
+	yearLimit := t.Year() + 8
+
+	for t.Year() <= yearLimit {
+		switch {
+		case !c.matches(3, int(t.Month())):
+			t = advance(t, time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, location))
+
+		case !c.dayMatches(t):
+			t = advance(t, time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, location))
+
+		case !c.matches(1, t.Hour()):
+			// Adding the minutes left keeps zones with half-hour offsets, and repeated hours, right
+			t = t.Add(time.Duration(60-t.Minute()) * time.Minute)
+
+		case !c.matches(0, t.Minute()):
+			t = t.Add(time.Minute)
+
+		default:
+			return t
+		}
+	}
+
+	return time.Time{}
+}
+
+// advance return the next time to check, which is always later than the current one,
+// even when the local time asked for does not exist because of a daylight saving change
+func advance(current, next time.Time) time.Time {
+	if !next.After(current) {
+		return current.Add(time.Minute)
+	}
+	return next
+}
+
+// matches return true when the value is accepted by the field with the given index
+func (c Cron) matches(field int, value int) bool {
+	return c.fields[field]&(1<<uint(value)) != 0
+}
+
+// dayMatches return true when the day of the given time is accepted by the day fields
+func (c Cron) dayMatches(t time.Time) bool {
+
+	dayOfMonth := c.matches(2, t.Day())
+	dayOfWeek := c.matches(4, int(t.Weekday()))
+
+	if c.anyDay[0] || c.anyDay[1] {
+		return dayOfMonth && dayOfWeek
+	}
+	return dayOfMonth || dayOfWeek
+}
+
+// parseCronField return the bits of the values accepted by one field of the expression
+func parseCronField(text string, field cronField) (bits uint64, err error) {
+
+	for _, item := range strings.Split(text, ",") {
+
+		rangeText, stepText, hasStep := strings.Cut(item, "/")
+
+		step := 1
+		if hasStep {
+			if step, err = strconv.Atoi(stepText); err != nil || step <= 0 {
+				return bits, errors.New("invalid step")
+			}
+		}
+
+		low, high := field.min, field.max
+		switch {
+		case rangeText == "*" || rangeText == "?":
+
+		case strings.Contains(rangeText, "-"):
+			lowText, highText, _ := strings.Cut(rangeText, "-")
+			if low, err = parseCronValue(lowText, field); err != nil {
+				return bits, err
+			}
+			if high, err = parseCronValue(highText, field); err != nil {
+				return bits, err
+			}
+
+		default:
+			if low, err = parseCronValue(rangeText, field); err != nil {
+				return bits, err
+			}
+
+			// A single value with a step runs from the value to the end of the field. I.E: '5/15'
+			if !hasStep {
+				high = low
+			}
+		}
+
+		if low > high {
+			return bits, errors.New("invalid range")
+		}
+
+		for value := low; value <= high; value += step {
+			bits |= 1 << uint(value)
+		}
+	}
+
+	return bits, nil
+}
+
+// parseCronValue return the value given by number or by name
+func parseCronValue(text string, field cronField) (value int, err error) {
+
+	if named, found := field.names[strings.ToLower(text)]; found {
+		return named, nil
+	}
+
+	value, err = strconv.Atoi(text)
+	if err != nil || value < field.min || value > field.max {
+		return value, errors.New("out of range")
+	}
+
+	return value, nil
+}
@@ -0,0 +1,153 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronNext(t *testing.T) {
+
+	madridTimezone := loadLocation(t, "Europe/Madrid")
+
+	tests := []struct {
+		name       string
+		expression string
+		after      time.Time
+		want       []time.Time // want holds the next times, each one after the previous
+	}{
+		{
+			name:       "every 15 minutes",
+			expression: "*/15 * * * *",
+			after:      time.Date(2024, time.June, 19, 10, 7, 30, 0, time.UTC),
+			want: []time.Time{
+				time.Date(2024, time.June, 19, 10, 15, 0, 0, time.UTC),
+				time.Date(2024, time.June, 19, 10, 30, 0, 0, time.UTC),
+			},
+		},
+		{
+			name:       "hourly",
+			expression: "@hourly",
+			after:      time.Date(2024, time.June, 19, 10, 15, 0, 0, time.UTC),
+			want: []time.Time{
+				time.Date(2024, time.June, 19, 11, 0, 0, 0, time.UTC),
+				time.Date(2024, time.June, 19, 12, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			name:       "daily",
+			expression: "@daily",
+			after:      time.Date(2024, time.June, 19, 10, 15, 0, 0, madridTimezone),
+			want: []time.Time{
+				time.Date(2024, time.June, 20, 0, 0, 0, 0, madridTimezone),
+				time.Date(2024, time.June, 21, 0, 0, 0, 0, madridTimezone),
+			},
+		},
+		{
+			name:       "sunday given as 7",
+			expression: "0 9 * * 7",
+			after:      time.Date(2024, time.June, 19, 0, 0, 0, 0, time.UTC), // Wednesday
+			want: []time.Time{
+				time.Date(2024, time.June, 23, 9, 0, 0, 0, time.UTC),
+				time.Date(2024, time.June, 30, 9, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			name:       "sunday given by name",
+			expression: "0 9 * * SUN",
+			after:      time.Date(2024, time.June, 19, 0, 0, 0, 0, time.UTC),
+			want:       []time.Time{time.Date(2024, time.June, 23, 9, 0, 0, 0, time.UTC)},
+		},
+		{
+			name:       "both day fields restricted match any of them",
+			expression: "0 0 13 * FRI",
+			after:      time.Date(2024, time.June, 1, 0, 0, 0, 0, time.UTC), // Saturday
+			want: []time.Time{
+				time.Date(2024, time.June, 7, 0, 0, 0, 0, time.UTC),  // Friday
+				time.Date(2024, time.June, 13, 0, 0, 0, 0, time.UTC), // Thursday 13th
+				time.Date(2024, time.June, 14, 0, 0, 0, 0, time.UTC), // Friday
+			},
+		},
+		{
+			name:       "day of month restricted alone",
+			expression: "0 0 13 * *",
+			after:      time.Date(2024, time.June, 1, 0, 0, 0, 0, time.UTC),
+			want: []time.Time{
+				time.Date(2024, time.June, 13, 0, 0, 0, 0, time.UTC),
+				time.Date(2024, time.July, 13, 0, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			name:       "leap day",
+			expression: "0 12 29 2 *",
+			after:      time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC),
+			want:       []time.Time{time.Date(2028, time.February, 29, 12, 0, 0, 0, time.UTC)},
+		},
+		{
+			name:       "time skipped when clocks spring forward",
+			expression: "30 2 * * *",
+			after:      time.Date(2024, time.March, 30, 12, 0, 0, 0, madridTimezone),
+			want:       []time.Time{time.Date(2024, time.April, 1, 2, 30, 0, 0, madridTimezone)},
+		},
+		{
+			name:       "hours around clocks springing forward",
+			expression: "0 * * * *",
+			after:      time.Date(2024, time.March, 31, 0, 30, 0, 0, time.UTC).In(madridTimezone), // 01:30 CET
+			want: []time.Time{
+				time.Date(2024, time.March, 31, 1, 0, 0, 0, time.UTC), // 03:00 CEST
+				time.Date(2024, time.March, 31, 2, 0, 0, 0, time.UTC), // 04:00 CEST
+			},
+		},
+		{
+			name:       "time repeated when clocks fall back",
+			expression: "30 2 * * *",
+			after:      time.Date(2024, time.October, 27, 0, 0, 0, 0, madridTimezone),
+			want: []time.Time{
+				time.Date(2024, time.October, 27, 0, 30, 0, 0, time.UTC), // 02:30 CEST
+				time.Date(2024, time.October, 27, 1, 30, 0, 0, time.UTC), // 02:30 CET
+				time.Date(2024, time.October, 28, 1, 30, 0, 0, time.UTC),
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cron, err := ParseCron(test.expression)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			after := test.after
+			for _, want := range test.want {
+				next := cron.Next(after)
+				if !next.Equal(want) {
+					t.Fatalf("expected %s after %s, got %s", want.In(test.after.Location()), after, next)
+				}
+				after = next
+			}
+		})
+	}
+}
+
+func TestParseCronErrors(t *testing.T) {
+
+	tests := []struct {
+		name       string
+		expression string
+	}{
+		{name: "missing fields", expression: "0 0 * *"},
+		{name: "minute out of range", expression: "60 * * * *"},
+		{name: "unknown name", expression: "0 0 * * FUNDAY"},
+		{name: "reversed range", expression: "0 18-8 * * *"},
+		{name: "zero step", expression: "*/0 * * * *"},
+		{name: "unknown descriptor", expression: "@fortnightly"},
+		{name: "never matching", expression: "0 0 30 2 *"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := ParseCron(test.expression); err == nil {
+				t.Errorf("expected an error parsing '%s'", test.expression)
+			}
+		})
+	}
+}
@@ -0,0 +1,201 @@
+package scheduler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	// Error messages
+	JobScheduleErrorMessage = "job '%s' must set exactly one of 'cron', 'at' or 'solar'"
+	JobTimezoneErrorMessage = "invalid timezone '%s': %s"
+	JobAtErrorMessage       = "invalid time '%s': use RFC 3339 or '2006-01-02 15:04' in the timezone of the job"
+)
+
+var (
+	// atLayouts are the layouts accepted for fixed times without offset, read in the timezone of the job
+	atLayouts = []string{"2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02 15:04:05", "2006-01-02 15:04"}
+)
+
+// MissedRunPolicy represents what happens with the runs missed while the scheduler was not running
+type MissedRunPolicy string
+
+const (
+	// MissedRunSkip forgets the missed runs, so the job waits for its next time
+	MissedRunSkip MissedRunPolicy = "skip"
+
+	// MissedRunOnce runs the job once as soon as possible when any of its runs was missed,
+	// I.E: lights are turned on after a reboot if the scheduler was down at sunset
+	MissedRunOnce MissedRunPolicy = "once"
+)
+
+// Schedule represents when a job runs
+type Schedule interface {
+	// Next return the first time to run strictly after the given one, or the zero time when there is none
+	Next(after time.Time) time.Time
+}
+
+// Once represents a schedule running a single time
+type Once time.Time
+
+// Next return the time of the schedule when it is after the given one
+func (o Once) Next(after time.Time) time.Time {
+	if time.Time(o).After(after) {
+		return time.Time(o)
+	}
+	return time.Time{}
+}
+
+// Job represents an action run on a schedule. Jobs are encoded as JSON in the store,
+// along with the outcome of their last run, so they survive restarts
+type Job struct {
+	Id   string `json:"id"`
+	Name string `json:"name,omitempty"`
+
+	Cron     string        `json:"cron,omitempty"`     // Cron runs the job on a cron expression. See ParseCron
+	At       string        `json:"at,omitempty"`       // At runs the job once, at a time in RFC 3339 or '2006-01-02 15:04'
+	Solar    *SolarTrigger `json:"solar,omitempty"`    // Solar runs the job every day at a solar event, as sunset
+	Timezone string        `json:"timezone,omitempty"` // Timezone the schedule is read in. I.E: 'Europe/Madrid'. Default: the one of the scheduler
+
+	Action          Action          `json:"action"`
+	MissedRunPolicy MissedRunPolicy `json:"missedRunPolicy,omitempty"` // Default: MissedRunSkip
+	Disabled        bool            `json:"disabled,omitempty"`
+
+	CreatedAt     time.Time `json:"createdAt"`
+	LastScheduled time.Time `json:"lastScheduled"`       // LastScheduled is the scheduled time of the last run, or skip
+	LastRun       time.Time `json:"lastRun"`             // LastRun is when the last run started
+	LastError     string    `json:"lastError,omitempty"` // LastError is the error of the last run, when it failed
+}
+
+// AtCron return a job running the action on the given cron expression
+func AtCron(expression string, action Action) Job {
+	return Job{Cron: expression, Action: action}
+}
+
+// AtTime return a job running the action once, at the given time
+func AtTime(at time.Time, action Action) Job {
+	return Job{At: at.Format(time.RFC3339), Action: action}
+}
+
+// AtSolarEvent return a job running the action every day at the solar event, moved by the offset.
+// I.E: AtSolarEvent(Sunset, -15*time.Minute, action) runs 15 minutes before sunset.
+// The coordinates of the scheduler are used, unless the job is given its own in Solar
+func AtSolarEvent(event SolarEvent, offset time.Duration, action Action) Job {
+	return Job{Solar: &SolarTrigger{Event: event, Offset: Duration(offset)}, Action: action}
+}
+
+// Location return the location the schedule of the job is read in
+func (j Job) Location(fallback *time.Location) (location *time.Location, err error) {
+
+	if j.Timezone == "" {
+		return fallback, nil
+	}
+
+	location, err = time.LoadLocation(j.Timezone)
+	if err != nil {
+		return location, errors.New(fmt.Sprintf(JobTimezoneErrorMessage, j.Timezone, err))
+	}
+	return location, nil
+}
+
+// Schedule return when the job runs, as given by its fields. The location and the coordinates
+// are used when the job does not set its own timezone and, for solar jobs, coordinates
+func (j Job) Schedule(fallback *time.Location, coordinates *Coordinates) (schedule Schedule, err error) {
+
+	location, err := j.Location(fallback)
+	if err != nil {
+		return schedule, err
+	}
+
+	switch {
+	case j.Cron != "" && j.At == "" && j.Solar == nil:
+		cron, err := ParseCron(j.Cron)
+		if err != nil {
+			return schedule, err
+		}
+		return locatedSchedule{schedule: cron, location: location}, nil
+
+	case j.At != "" && j.Cron == "" && j.Solar == nil:
+		at, err := parseAt(j.At, location)
+		if err != nil {
+			return schedule, err
+		}
+		return Once(at), nil
+
+	case j.Solar != nil && j.Cron == "" && j.At == "":
+		if err = j.Solar.Validate(); err != nil {
+			return schedule, err
+		}
+
+		if j.Solar.Coordinates != nil {
+			coordinates = j.Solar.Coordinates
+		}
+		if coordinates == nil {
+			return schedule, errors.New(fmt.Sprintf(SolarCoordinatesErrorMessage, j.Id))
+		}
+		if err = coordinates.Validate(); err != nil {
+			return schedule, err
+		}
+
+		return SolarSchedule{Event: j.Solar.Event, Offset: time.Duration(j.Solar.Offset), Coordinates: *coordinates, Location: location}, nil
+	}
+
+	return schedule, errors.New(fmt.Sprintf(JobScheduleErrorMessage, j.Id))
+}
+
+// Validate checks the schedule and the action of the job. Solar jobs without coordinates
+// are accepted, as they take the ones of the scheduler
+func (j Job) Validate() error {
+
+	if _, err := j.Schedule(time.Local, &Coordinates{}); err != nil {
+		return err
+	}
+
+	return j.Action.Validate()
+}
+
+// locatedSchedule reads a schedule in the given location, whatever the location of the times it is asked for
+type locatedSchedule struct {
+	schedule Schedule
+	location *time.Location
+}
+
+func (l locatedSchedule) Next(after time.Time) time.Time {
+	return l.schedule.Next(after.In(l.location))
+}
+
+// parseAt return the time given in RFC 3339, or without offset in the given location
+func parseAt(text string, location *time.Location) (at time.Time, err error) {
+
+	if at, err = time.Parse(time.RFC3339, text); err == nil {
+		return at, nil
+	}
+
+	for _, layout := range atLayouts {
+		if at, err = time.ParseInLocation(layout, text, location); err == nil {
+			return at, nil
+		}
+	}
+
+	return at, errors.New(fmt.Sprintf(JobAtErrorMessage, text))
+}
+
+// Duration is a time.Duration written as a string in the job store. I.E: "1m30s"
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(content []byte) (err error) {
+	var text string
+	if err = json.Unmarshal(content, &text); err != nil {
+		return err
+	}
+
+	duration, err := time.ParseDuration(text)
+	*d = Duration(duration)
+	return err
+}
@@ -0,0 +1,452 @@
+package scheduler
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/achetronic/wizgo/pkg/wizgo"
+)
+
+const (
+	// DefaultMissedRunGrace is how late a run can start without being considered missed
+	DefaultMissedRunGrace = time.Minute
+
+	// maxWait bounds the sleep between checks, so jumps of the wall clock (I.E: after a suspend) are noticed
+	maxWait = time.Minute
+
+	// Error messages
+	JobExistsErrorMessage   = "job '%s' already exists"
+	JobNotFoundErrorMessage = "job '%s' not found"
+	JobNeverErrorMessage    = "job '%s' would never run: its time has passed"
+	JobInvalidErrorMessage  = "invalid job '%s': %s"
+	JobRunningErrorMessage  = "job '%s' is still running"
+)
+
+// Options represents the settings used when creating a Scheduler
+type Options struct {
+	// Store keeps the jobs between restarts. Default: a MemoryStore
+	Store Store
+
+	// Location the schedules of the jobs without timezone are read in. Default: time.Local
+	Location *time.Location
+
+	// Coordinates of the place the solar jobs without their own are computed for
+	Coordinates *Coordinates
+
+	// MissedRunGrace is how late a run can start without being considered missed. Default: DefaultMissedRunGrace
+	MissedRunGrace time.Duration
+
+	// Handler receives an event for every run, and every run skipped.
+	// It is called from several goroutines, so it must be safe for concurrent use and return quickly
+	Handler func(event RunEvent)
+}
+
+// RunEvent represents the outcome of a run of a job
+type RunEvent struct {
+	Job         Job
+	ScheduledAt time.Time     // ScheduledAt is the time the run was scheduled for
+	Missed      bool          // Missed is true when the run started later than the grace allows
+	Skipped     bool          // Skipped is true when the run did not happen, by the missed-run policy or an overlapping run
+	Duration    time.Duration // Duration of the run
+	Err         error         // Err holds the error of the run, along with the ones saving its outcome in the store
+}
+
+// Scheduler runs actions on the devices of an inventory at the times given by its jobs.
+// A Scheduler is safe for concurrent use by multiple goroutines
+type Scheduler struct {
+	inventory *wizgo.Inventory
+	options   Options
+
+	mutex   sync.Mutex
+	jobs    map[string]*Job
+	running map[string]bool
+
+	// wake interrupts the wait for the next run when the jobs change
+	wake chan struct{}
+	wg   sync.WaitGroup
+}
+
+// CreateScheduler creates a scheduler with the jobs kept in the store
+func CreateScheduler(inventory *wizgo.Inventory, options Options) (scheduler *Scheduler, err error) {
+
+	if options.Store == nil {
+		options.Store = CreateMemoryStore()
+	}
+
+	if options.Location == nil {
+		options.Location = time.Local
+	}
+
+	if options.MissedRunGrace <= 0 {
+		options.MissedRunGrace = DefaultMissedRunGrace
+	}
+
+	scheduler = &Scheduler{
+		inventory: inventory,
+		options:   options,
+		jobs:      map[string]*Job{},
+		running:   map[string]bool{},
+		wake:      make(chan struct{}, 1),
+	}
+
+	jobs, err := options.Store.Load()
+	if err != nil {
+		return scheduler, err
+	}
+
+	changed := false
+	for index := range jobs {
+		job := jobs[index]
+		if err = scheduler.validate(job); err != nil {
+			return scheduler, errors.New(fmt.Sprintf(JobInvalidErrorMessage, job.Id, err))
+		}
+
+		// Jobs written by hand start counting when they are loaded, so the ones whose time has passed would never run
+		if job.Id == "" {
+			job.Id, changed = randomId(), true
+		}
+		if job.CreatedAt.IsZero() {
+			job.CreatedAt, changed = time.Now(), true
+			if !job.Disabled && scheduler.NextRun(job).IsZero() {
+				return scheduler, errors.New(fmt.Sprintf(JobNeverErrorMessage, job.Id))
+			}
+		}
+
+		if _, found := scheduler.jobs[job.Id]; found {
+			return scheduler, errors.New(fmt.Sprintf(JobExistsErrorMessage, job.Id))
+		}
+		scheduler.jobs[job.Id] = &job
+	}
+
+	if changed {
+		err = scheduler.save()
+	}
+
+	return scheduler, err
+}
+
+// Add validates the job and adds it to the scheduler, saving it in the store. A random id is given
+// to jobs without one. Runs scheduled before the job is added are not considered missed
+func (s *Scheduler) Add(job Job) (added Job, err error) {
+
+	if job.Id == "" {
+		job.Id = randomId()
+	}
+
+	if err = s.validate(job); err != nil {
+		return added, err
+	}
+
+	job.CreatedAt = time.Now()
+	job.LastScheduled, job.LastRun, job.LastError = time.Time{}, time.Time{}, ""
+
+	if s.NextRun(job).IsZero() {
+		return added, errors.New(fmt.Sprintf(JobNeverErrorMessage, job.Id))
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, found := s.jobs[job.Id]; found {
+		return added, errors.New(fmt.Sprintf(JobExistsErrorMessage, job.Id))
+	}
+
+	s.jobs[job.Id] = &job
+	if err = s.save(); err != nil {
+		delete(s.jobs, job.Id)
+		return added, err
+	}
+
+	s.notify()
+	return job, nil
+}
+
+// Remove removes the job with the given id, saving the change in the store. A running job is not interrupted
+func (s *Scheduler) Remove(id string) (err error) {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	job, found := s.jobs[id]
+	if !found {
+		return errors.New(fmt.Sprintf(JobNotFoundErrorMessage, id))
+	}
+
+	delete(s.jobs, id)
+	if err = s.save(); err != nil {
+		s.jobs[id] = job
+		return err
+	}
+
+	s.notify()
+	return nil
+}
+
+// Jobs return all the jobs, in the order they were added
+func (s *Scheduler) Jobs() (jobs []Job) {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.list()
+}
+
+// Job return the job with the given id
+func (s *Scheduler) Job(id string) (job Job, found bool) {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	known, found := s.jobs[id]
+	if !found {
+		return job, false
+	}
+	return *known, true
+}
+
+// NextRun return the next time the job is scheduled for, or the zero time when it will not run again
+func (s *Scheduler) NextRun(job Job) time.Time {
+
+	if job.Disabled {
+		return time.Time{}
+	}
+
+	schedule, err := job.Schedule(s.options.Location, s.options.Coordinates)
+	if err != nil {
+		return time.Time{}
+	}
+
+	next := schedule.Next(lastScheduled(job))
+	if next.IsZero() || next.After(time.Now()) {
+		return next
+	}
+
+	// Runs already due start as soon as the scheduler checks them
+	return time.Now()
+}
+
+// RunNow runs the job with the given id right away, whatever its schedule, and waits for it to finish
+func (s *Scheduler) RunNow(ctx context.Context, id string) (err error) {
+
+	s.mutex.Lock()
+	job, found := s.jobs[id]
+	if !found {
+		s.mutex.Unlock()
+		return errors.New(fmt.Sprintf(JobNotFoundErrorMessage, id))
+	}
+	if s.running[id] {
+		s.mutex.Unlock()
+		return errors.New(fmt.Sprintf(JobRunningErrorMessage, id))
+	}
+	s.running[id] = true
+	snapshot := *job
+	s.mutex.Unlock()
+
+	return s.run(ctx, RunEvent{Job: snapshot, ScheduledAt: time.Now()})
+}
+
+// Run starts the jobs when they are due until the context is done, then waits for the running ones,
+// which are cancelled with the context
+func (s *Scheduler) Run(ctx context.Context) (err error) {
+
+	defer s.wg.Wait()
+
+	for {
+		wait := s.dispatch(ctx, time.Now())
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-s.wake:
+			timer.Stop()
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		}
+	}
+}
+
+// dispatch starts the jobs that are due, following their missed-run policy,
+// and return how long to wait until the next check
+func (s *Scheduler) dispatch(ctx context.Context, now time.Time) (wait time.Duration) {
+
+	s.mutex.Lock()
+
+	wait = maxWait
+	var events []RunEvent
+	var starts []RunEvent
+
+	for _, job := range s.list() {
+		if job.Disabled {
+			continue
+		}
+
+		schedule, err := job.Schedule(s.options.Location, s.options.Coordinates)
+		if err != nil {
+			continue
+		}
+
+		due := schedule.Next(lastScheduled(job))
+		if due.IsZero() {
+			continue
+		}
+
+		if due.After(now) {
+			if until := due.Sub(now); until < wait {
+				wait = until
+			}
+			continue
+		}
+
+		// Several runs may have been missed, only the latest one matters
+		for next := schedule.Next(due); !next.IsZero() && !next.After(now); next = schedule.Next(due) {
+			due = next
+		}
+
+		s.jobs[job.Id].LastScheduled = due
+		job.LastScheduled = due
+
+		if next := schedule.Next(due); !next.IsZero() && next.Sub(now) < wait {
+			wait = next.Sub(now)
+		}
+
+		event := RunEvent{Job: job, ScheduledAt: due, Missed: now.Sub(due) > s.options.MissedRunGrace}
+		switch {
+		case s.running[job.Id]:
+			event.Skipped, event.Err = true, errors.New(fmt.Sprintf(JobRunningErrorMessage, job.Id))
+			events = append(events, event)
+
+		case event.Missed && job.MissedRunPolicy != MissedRunOnce:
+			event.Skipped = true
+			events = append(events, event)
+
+		default:
+			s.running[job.Id] = true
+			starts = append(starts, event)
+		}
+	}
+
+	// The scheduled times are saved before running, so a crash does not run them twice.
+	// Failing to save them is reported along with the outcome of each job
+	if len(events) > 0 || len(starts) > 0 {
+		if err := s.save(); err != nil {
+			for index := range events {
+				events[index].Err = errors.Join(events[index].Err, err)
+			}
+			for index := range starts {
+				starts[index].Err = err
+			}
+		}
+	}
+
+	s.mutex.Unlock()
+
+	for _, event := range events {
+		s.emit(event)
+	}
+
+	for _, event := range starts {
+		s.wg.Add(1)
+		go func(event RunEvent) {
+			defer s.wg.Done()
+			_ = s.run(ctx, event)
+		}(event)
+	}
+
+	return wait
+}
+
+// run runs the action of the job in the event, which must be marked as running, and records the outcome.
+// The error of the event, if any, is reported along with the one of the run
+func (s *Scheduler) run(ctx context.Context, scheduled RunEvent) (err error) {
+
+	job := scheduled.Job
+	start := time.Now()
+	err = job.Action.Run(ctx, s.inventory)
+
+	s.mutex.Lock()
+	delete(s.running, job.Id)
+
+	saveErr := error(nil)
+	if known, found := s.jobs[job.Id]; found {
+		known.LastRun, known.LastError = start, ""
+		if err != nil {
+			known.LastError = err.Error()
+		}
+		job = *known
+		saveErr = s.save()
+	}
+	s.mutex.Unlock()
+
+	s.emit(RunEvent{Job: job, ScheduledAt: scheduled.ScheduledAt, Missed: scheduled.Missed, Duration: time.Since(start), Err: errors.Join(scheduled.Err, err, saveErr)})
+	return err
+}
+
+// validate checks the job, along with the settings it takes from the scheduler
+func (s *Scheduler) validate(job Job) error {
+
+	if err := job.Validate(); err != nil {
+		return err
+	}
+
+	_, err := job.Schedule(s.options.Location, s.options.Coordinates)
+	return err
+}
+
+// list return the jobs in the order they were added. It must be called holding the mutex
+func (s *Scheduler) list() (jobs []Job) {
+
+	for _, job := range s.jobs {
+		jobs = append(jobs, *job)
+	}
+
+	sort.Slice(jobs, func(a, b int) bool {
+		if jobs[a].CreatedAt.Equal(jobs[b].CreatedAt) {
+			return jobs[a].Id < jobs[b].Id
+		}
+		return jobs[a].CreatedAt.Before(jobs[b].CreatedAt)
+	})
+
+	return jobs
+}
+
+// save writes all the jobs to the store. It must be called holding the mutex
+func (s *Scheduler) save() error {
+	return s.options.Store.Save(s.list())
+}
+
+// notify wakes up the loop of Run, so it checks the jobs again
+func (s *Scheduler) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// emit delivers the event to the handler, when there is one
+func (s *Scheduler) emit(event RunEvent) {
+	if s.options.Handler != nil {
+		s.options.Handler(event)
+	}
+}
+
+// lastScheduled return the time the schedule of the job continues from
+func lastScheduled(job Job) time.Time {
+	if job.LastScheduled.IsZero() {
+		return job.CreatedAt
+	}
+	return job.LastScheduled
+}
+
+// randomId return a random identifier for a job
+func randomId() string {
+	buffer := make([]byte, 8)
+	_, _ = rand.Read(buffer)
+	return hex.EncodeToString(buffer)
+}
@@ -0,0 +1,295 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/achetronic/wizgo/pkg/emulator"
+	"github.com/achetronic/wizgo/pkg/wizgo"
+)
+
+// failingStore is a MemoryStore whose saves fail when asked to
+type failingStore struct {
+	MemoryStore
+	fail bool
+}
+
+func (f *failingStore) Save(jobs []Job) (err error) {
+	if f.fail {
+		return errors.New("disk full")
+	}
+	return f.MemoryStore.Save(jobs)
+}
+
+// eventRecorder keeps the events given to the handler of a scheduler
+type eventRecorder struct {
+	events []RunEvent
+	mutex  sync.Mutex
+}
+
+func (e *eventRecorder) handle(event RunEvent) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.events = append(e.events, event)
+}
+
+func (e *eventRecorder) list() []RunEvent {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return append([]RunEvent{}, e.events...)
+}
+
+// createInventory return an inventory holding an emulated device, both closed when the test ends
+func createInventory(t *testing.T) (inventory *wizgo.Inventory, device *emulator.Emulator) {
+	t.Helper()
+
+	device, err := emulator.Start(emulator.Options{})
+	if err != nil {
+		t.Fatalf("error starting the emulator: %s", err)
+	}
+	t.Cleanup(func() { _ = device.Close() })
+
+	inventory = wizgo.CreateInventory(wizgo.WizClientOptions{RetryPolicy: wizgo.NoRetryPolicy})
+	t.Cleanup(func() { _ = inventory.Close() })
+	inventory.Update(wizgo.DiscoveredDevice{Ip: device.Host(), Port: device.Port(), Mac: device.Mac()})
+
+	return inventory, device
+}
+
+// turnOns return how many times the emulated device was turned on
+func turnOns(device *emulator.Emulator) (count int) {
+	for _, message := range device.Messages() {
+		if message.Method == "setState" {
+			count++
+		}
+	}
+	return count
+}
+
+func TestDispatchMissedRuns(t *testing.T) {
+
+	now := time.Date(2024, time.June, 19, 12, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		job  Job
+
+		wantScheduled time.Time
+		wantMissed    bool
+		wantSkipped   bool
+	}{
+		{
+			name:          "on time",
+			job:           Job{Cron: "*/10 * * * *", CreatedAt: now.Add(-15 * time.Minute)},
+			wantScheduled: now,
+		},
+		{
+			name:          "late within the grace",
+			job:           Job{Cron: "29 * * * *", CreatedAt: now.Add(-15 * time.Minute)},
+			wantScheduled: now.Add(-time.Minute),
+		},
+		{
+			name:          "several runs missed and skipped",
+			job:           Job{Cron: "0 * * * *", CreatedAt: now.Add(-5 * time.Hour), MissedRunPolicy: MissedRunSkip},
+			wantScheduled: now.Add(-30 * time.Minute),
+			wantMissed:    true,
+			wantSkipped:   true,
+		},
+		{
+			name:          "missed runs skipped by default",
+			job:           Job{Cron: "0 * * * *", CreatedAt: now.Add(-5 * time.Hour)},
+			wantScheduled: now.Add(-30 * time.Minute),
+			wantMissed:    true,
+			wantSkipped:   true,
+		},
+		{
+			name:          "several runs missed and run once",
+			job:           Job{Cron: "0 * * * *", CreatedAt: now.Add(-5 * time.Hour), MissedRunPolicy: MissedRunOnce},
+			wantScheduled: now.Add(-30 * time.Minute),
+			wantMissed:    true,
+		},
+		{
+			name:          "fixed time missed and run once",
+			job:           Job{At: "2024-06-19T09:00:00Z", CreatedAt: now.Add(-5 * time.Hour), MissedRunPolicy: MissedRunOnce},
+			wantScheduled: time.Date(2024, time.June, 19, 9, 0, 0, 0, time.UTC),
+			wantMissed:    true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			inventory, device := createInventory(t)
+
+			test.job.Id = "job"
+			test.job.Action = TurnOn(Targets{Devices: []string{device.Mac()}})
+
+			store := CreateMemoryStore()
+			if err := store.Save([]Job{test.job}); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			recorder := &eventRecorder{}
+			scheduler, err := CreateScheduler(inventory, Options{Store: store, Location: time.UTC, Handler: recorder.handle})
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			scheduler.dispatch(context.Background(), now)
+			scheduler.wg.Wait()
+
+			events := recorder.list()
+			if len(events) != 1 {
+				t.Fatalf("expected a single event, got %d", len(events))
+			}
+			event := events[0]
+
+			if event.Job.Id != "job" || !event.ScheduledAt.Equal(test.wantScheduled) {
+				t.Errorf("expected the run of 'job' scheduled at %s, got the one of '%s' at %s", test.wantScheduled, event.Job.Id, event.ScheduledAt)
+			}
+			if event.Missed != test.wantMissed || event.Skipped != test.wantSkipped {
+				t.Errorf("expected missed %t and skipped %t, got missed %t and skipped %t", test.wantMissed, test.wantSkipped, event.Missed, event.Skipped)
+			}
+			if event.Err != nil {
+				t.Errorf("unexpected error: %s", event.Err)
+			}
+
+			wantTurnOns := 1
+			if test.wantSkipped {
+				wantTurnOns = 0
+			}
+			if count := turnOns(device); count != wantTurnOns {
+				t.Errorf("expected the device turned on %d times, got %d", wantTurnOns, count)
+			}
+
+			// Skipped runs are not retried, so the scheduled time is kept either way
+			jobs, _ := store.Load()
+			if len(jobs) != 1 || !jobs[0].LastScheduled.Equal(test.wantScheduled) {
+				t.Errorf("expected the scheduled time saved in the store, got %+v", jobs)
+			}
+		})
+	}
+}
+
+func TestDispatchSaveError(t *testing.T) {
+
+	now := time.Date(2024, time.June, 19, 12, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		policy MissedRunPolicy
+
+		wantSkipped bool
+	}{
+		{name: "skipped run", policy: MissedRunSkip, wantSkipped: true},
+		{name: "run", policy: MissedRunOnce},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			inventory, device := createInventory(t)
+
+			store := &failingStore{}
+			job := Job{
+				Id: "job", Cron: "0 * * * *", CreatedAt: now.Add(-5 * time.Hour), MissedRunPolicy: test.policy,
+				Action: TurnOn(Targets{Devices: []string{device.Mac()}}),
+			}
+			if err := store.Save([]Job{job}); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			recorder := &eventRecorder{}
+			scheduler, err := CreateScheduler(inventory, Options{Store: store, Location: time.UTC, Handler: recorder.handle})
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			store.fail = true
+			scheduler.dispatch(context.Background(), now)
+			scheduler.wg.Wait()
+
+			// The error comes along with the outcome of the job it could not be saved for
+			events := recorder.list()
+			if len(events) != 1 {
+				t.Fatalf("expected a single event, got %d", len(events))
+			}
+			if events[0].Job.Id != "job" || events[0].Skipped != test.wantSkipped {
+				t.Errorf("expected the event of 'job' with skipped %t, got %+v", test.wantSkipped, events[0])
+			}
+			if events[0].Err == nil || !strings.Contains(events[0].Err.Error(), "disk full") {
+				t.Errorf("expected the error saving the store, got: %v", events[0].Err)
+			}
+		})
+	}
+}
+
+func TestCreateSchedulerLoadedJobs(t *testing.T) {
+
+	past := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+	future := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+
+	tests := []struct {
+		name string
+		job  Job
+
+		wantErr string
+	}{
+		{
+			name: "fixed time ahead written by hand",
+			job:  Job{Id: "job", At: future},
+		},
+		{
+			name:    "fixed time passed written by hand",
+			job:     Job{Id: "job", At: past},
+			wantErr: "job 'job' would never run: its time has passed",
+		},
+		{
+			name: "fixed time passed written by hand but disabled",
+			job:  Job{Id: "job", At: past, Disabled: true},
+		},
+		{
+			name: "fixed time passed after running",
+			job:  Job{Id: "job", At: past, CreatedAt: time.Now().Add(-2 * time.Hour), LastScheduled: time.Now().Add(-time.Hour)},
+		},
+		{
+			name:    "invalid schedule",
+			job:     Job{Id: "job", Cron: "0 0 30 2 *"},
+			wantErr: "invalid job 'job'",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.job.Action = TurnOn(Targets{Rooms: []string{"bedroom"}})
+
+			store := CreateMemoryStore()
+			if err := store.Save([]Job{test.job}); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			scheduler, err := CreateScheduler(wizgo.CreateInventory(wizgo.WizClientOptions{}), Options{Store: store})
+
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Errorf("expected error '%s', got: %v", test.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			// Jobs written by hand start counting when they are loaded, and are saved so
+			job, found := scheduler.Job("job")
+			if !found || job.CreatedAt.IsZero() {
+				t.Errorf("expected the job loaded with its creation time, got %+v", job)
+			}
+			if jobs, _ := store.Load(); len(jobs) != 1 || !jobs[0].CreatedAt.Equal(job.CreatedAt) {
+				t.Errorf("expected the creation time saved in the store, got %+v", jobs)
+			}
+		})
+	}
+}
@@ -0,0 +1,206 @@
+package scheduler
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+const (
+	// maxSolarOffset bounds the offset of solar triggers, so the time of an event stays close to its day
+	maxSolarOffset = 12 * time.Hour
+
+	// Error messages
+	SolarEventErrorMessage       = "unknown solar event '%s': use sunrise, sunset, civilDawn or civilDusk"
+	SolarOffsetErrorMessage      = "solar offset '%s' is out of range: it must be within 12h"
+	SolarCoordinatesErrorMessage = "solar job '%s' needs coordinates: set them in the job or in the scheduler"
+	CoordinatesErrorMessage      = "invalid coordinates %v, %v: latitude must be within ±90 and longitude within ±180"
+)
+
+// SolarEvent represents a moment of the day given by the position of the sun
+type SolarEvent string
+
+const (
+	Sunrise   SolarEvent = "sunrise"   // Sunrise is when the upper edge of the sun appears on the horizon
+	Sunset    SolarEvent = "sunset"    // Sunset is when the upper edge of the sun disappears below the horizon
+	CivilDawn SolarEvent = "civilDawn" // CivilDawn is when the sun is 6 degrees below the horizon in the morning
+	CivilDusk SolarEvent = "civilDusk" // CivilDusk is when the sun is 6 degrees below the horizon in the evening
+)
+
+var (
+	// solarZeniths are the angles between the sun and the zenith at each event, in degrees.
+	// Sunrise and sunset account for the atmospheric refraction and the radius of the sun
+	solarZeniths = map[SolarEvent]float64{
+		Sunrise:   90.833,
+		Sunset:    90.833,
+		CivilDawn: 96,
+		CivilDusk: 96,
+	}
+)
+
+// Coordinates represents a place on Earth, in decimal degrees. Longitudes are positive to the east
+type Coordinates struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// Validate checks the coordinates are on Earth
+func (c Coordinates) Validate() error {
+	if math.Abs(c.Latitude) > 90 || math.Abs(c.Longitude) > 180 {
+		return errors.New(fmt.Sprintf(CoordinatesErrorMessage, c.Latitude, c.Longitude))
+	}
+	return nil
+}
+
+// SolarTrigger represents a schedule relative to a solar event, as written in the job store.
+// I.E: {"event": "sunset", "offset": "-15m"}
+type SolarTrigger struct {
+	Event  SolarEvent `json:"event"`
+	Offset Duration   `json:"offset,omitempty"` // Offset from the event. I.E: '-15m' runs 15 minutes before
+
+	// Coordinates of the place. Default: the ones of the scheduler
+	*Coordinates
+}
+
+// Validate checks the event, the offset and, when set, the coordinates of the trigger
+func (s SolarTrigger) Validate() error {
+
+	if _, found := solarZeniths[s.Event]; !found {
+		return errors.New(fmt.Sprintf(SolarEventErrorMessage, s.Event))
+	}
+
+	if offset := time.Duration(s.Offset); offset > maxSolarOffset || offset < -maxSolarOffset {
+		return errors.New(fmt.Sprintf(SolarOffsetErrorMessage, offset))
+	}
+
+	if s.Coordinates != nil {
+		return s.Coordinates.Validate()
+	}
+	return nil
+}
+
+// SolarSchedule represents a schedule running every day at a solar event, moved by an offset.
+// Days when the event does not happen, as in polar days and nights, are skipped
+type SolarSchedule struct {
+	Event       SolarEvent
+	Offset      time.Duration
+	Coordinates Coordinates
+	Location    *time.Location // Location deciding where days start and end. Default: time.Local
+}
+
+// Next return the first time of the schedule strictly after the given one,
+// or the zero time when the event does not happen within a year
+func (s SolarSchedule) Next(after time.Time) time.Time {
+
+	location := s.Location
+	if location == nil {
+		location = time.Local
+	}
+
+	// The day before is checked too, as its event may be moved past midnight by the offset
+	local := after.In(location)
+	for days := -1; days <= 366; days++ {
+		day := time.Date(local.Year(), local.Month(), local.Day()+days, 12, 0, 0, 0, location)
+
+		at, found := SolarTime(s.Event, day, s.Coordinates)
+		if !found {
+			continue
+		}
+
+		if at = at.Add(s.Offset); at.After(after) {
+			return at
+		}
+	}
+
+	return time.Time{}
+}
+
+// SolarTime return the time of the event in the day of the given time, in its location,
+// computed with the equations of the NOAA solar calculator. They are accurate to a minute
+// between the polar circles. Found is false when the event does not happen that day
+func SolarTime(event SolarEvent, day time.Time, coordinates Coordinates) (at time.Time, found bool) {
+
+	zenith, found := solarZeniths[event]
+	if !found {
+		return at, false
+	}
+
+	morning := event == Sunrise || event == CivilDawn
+	midnight := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, time.UTC)
+
+	// The position of the sun is computed at solar noon first, then again at the estimated time of the event
+	minutes := 720 - 4*coordinates.Longitude
+	for pass := 0; pass < 2; pass++ {
+		declination, equationOfTime := sunPosition(midnight.Add(time.Duration(minutes * float64(time.Minute))))
+
+		hourAngle, found := sunHourAngle(zenith, coordinates.Latitude, declination)
+		if !found {
+			return at, false
+		}
+		if !morning {
+			hourAngle = -hourAngle
+		}
+
+		minutes = 720 - 4*(coordinates.Longitude+hourAngle) - equationOfTime
+	}
+
+	at = midnight.Add(time.Duration(minutes * float64(time.Minute))).Round(time.Second)
+	return at.In(day.Location()), true
+}
+
+// sunPosition return the declination of the sun, in degrees,
+// and the equation of time, in minutes, at the given time
+func sunPosition(t time.Time) (declination float64, equationOfTime float64) {
+
+	// Julian centuries since J2000.0
+	julianDay := float64(t.Unix())/86400 + 2440587.5
+	century := (julianDay - 2451545) / 36525
+
+	meanLongitude := math.Mod(280.46646+century*(36000.76983+century*0.0003032), 360)
+	meanAnomaly := 357.52911 + century*(35999.05029-0.0001537*century)
+	eccentricity := 0.016708634 - century*(0.000042037+0.0000001267*century)
+
+	center := math.Sin(radians(meanAnomaly))*(1.914602-century*(0.004817+0.000014*century)) +
+		math.Sin(radians(2*meanAnomaly))*(0.019993-0.000101*century) +
+		math.Sin(radians(3*meanAnomaly))*0.000289
+
+	omega := 125.04 - 1934.136*century
+	apparentLongitude := meanLongitude + center - 0.00569 - 0.00478*math.Sin(radians(omega))
+
+	meanObliquity := 23 + (26+(21.448-century*(46.815+century*(0.00059-century*0.001813)))/60)/60
+	obliquity := meanObliquity + 0.00256*math.Cos(radians(omega))
+
+	declination = degrees(math.Asin(math.Sin(radians(obliquity)) * math.Sin(radians(apparentLongitude))))
+
+	y := math.Pow(math.Tan(radians(obliquity/2)), 2)
+	equationOfTime = 4 * degrees(y*math.Sin(2*radians(meanLongitude))-
+		2*eccentricity*math.Sin(radians(meanAnomaly))+
+		4*eccentricity*y*math.Sin(radians(meanAnomaly))*math.Cos(2*radians(meanLongitude))-
+		0.5*y*y*math.Sin(4*radians(meanLongitude))-
+		1.25*eccentricity*eccentricity*math.Sin(2*radians(meanAnomaly)))
+
+	return declination, equationOfTime
+}
+
+// sunHourAngle return the hour angle of the sun, in degrees, when it is at the given zenith.
+// Found is false when the sun never reaches it that day
+func sunHourAngle(zenith float64, latitude float64, declination float64) (hourAngle float64, found bool) {
+
+	cosine := math.Cos(radians(zenith))/(math.Cos(radians(latitude))*math.Cos(radians(declination))) -
+		math.Tan(radians(latitude))*math.Tan(radians(declination))
+
+	if cosine < -1 || cosine > 1 || math.IsNaN(cosine) {
+		return hourAngle, false
+	}
+
+	return degrees(math.Acos(cosine)), true
+}
+
+func radians(degrees float64) float64 {
+	return degrees * math.Pi / 180
+}
+
+func degrees(radians float64) float64 {
+	return radians * 180 / math.Pi
+}
@@ -0,0 +1,172 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+)
+
+var (
+	madrid      = Coordinates{Latitude: 40.4168, Longitude: -3.7038}
+	sydney      = Coordinates{Latitude: -33.8688, Longitude: 151.2093}
+	losAngeles  = Coordinates{Latitude: 34.0522, Longitude: -118.2437}
+	tromso      = Coordinates{Latitude: 69.6492, Longitude: 18.9553}
+	solarMargin = 2 * time.Minute // solarMargin is the difference accepted against the NOAA tables
+)
+
+// loadLocation return the location with the given name, failing the test when it is not available
+func loadLocation(t *testing.T, name string) *time.Location {
+	t.Helper()
+
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatalf("error loading the timezone: %s", err)
+	}
+	return location
+}
+
+func TestSolarTime(t *testing.T) {
+
+	tests := []struct {
+		name        string
+		coordinates Coordinates
+		timezone    string
+		day         string // day in the timezone, as '2006-01-02'
+		event       SolarEvent
+		want        string // want is the local time of the event, as '15:04', from the NOAA tables
+	}{
+		{name: "sunrise in Madrid in June", coordinates: madrid, timezone: "Europe/Madrid", day: "2024-06-21", event: Sunrise, want: "06:44"},
+		{name: "sunset in Madrid in June", coordinates: madrid, timezone: "Europe/Madrid", day: "2024-06-21", event: Sunset, want: "21:48"},
+		{name: "sunrise in Madrid in December", coordinates: madrid, timezone: "Europe/Madrid", day: "2024-12-21", event: Sunrise, want: "08:33"},
+		{name: "sunset in Madrid in December", coordinates: madrid, timezone: "Europe/Madrid", day: "2024-12-21", event: Sunset, want: "17:51"},
+		{name: "civil dawn in Madrid in June", coordinates: madrid, timezone: "Europe/Madrid", day: "2024-06-21", event: CivilDawn, want: "06:12"},
+		{name: "civil dusk in Madrid in June", coordinates: madrid, timezone: "Europe/Madrid", day: "2024-06-21", event: CivilDusk, want: "22:21"},
+
+		{name: "sunrise in Sydney in December", coordinates: sydney, timezone: "Australia/Sydney", day: "2024-12-21", event: Sunrise, want: "05:41"},
+		{name: "sunset in Sydney in December", coordinates: sydney, timezone: "Australia/Sydney", day: "2024-12-21", event: Sunset, want: "20:05"},
+		{name: "sunrise in Sydney in June", coordinates: sydney, timezone: "Australia/Sydney", day: "2024-06-21", event: Sunrise, want: "07:00"},
+		{name: "sunset in Sydney in June", coordinates: sydney, timezone: "Australia/Sydney", day: "2024-06-21", event: Sunset, want: "16:54"},
+		{name: "civil dawn in Sydney in June", coordinates: sydney, timezone: "Australia/Sydney", day: "2024-06-21", event: CivilDawn, want: "06:33"},
+		{name: "civil dusk in Sydney in June", coordinates: sydney, timezone: "Australia/Sydney", day: "2024-06-21", event: CivilDusk, want: "17:21"},
+
+		{name: "sunrise in Los Angeles in June", coordinates: losAngeles, timezone: "America/Los_Angeles", day: "2024-06-21", event: Sunrise, want: "05:42"},
+		{name: "sunset in Los Angeles in June", coordinates: losAngeles, timezone: "America/Los_Angeles", day: "2024-06-21", event: Sunset, want: "20:08"},
+		{name: "sunrise in Los Angeles in December", coordinates: losAngeles, timezone: "America/Los_Angeles", day: "2024-12-21", event: Sunrise, want: "06:55"},
+		{name: "sunset in Los Angeles in December", coordinates: losAngeles, timezone: "America/Los_Angeles", day: "2024-12-21", event: Sunset, want: "16:48"},
+		{name: "civil dawn in Los Angeles in December", coordinates: losAngeles, timezone: "America/Los_Angeles", day: "2024-12-21", event: CivilDawn, want: "06:28"},
+		{name: "civil dusk in Los Angeles in December", coordinates: losAngeles, timezone: "America/Los_Angeles", day: "2024-12-21", event: CivilDusk, want: "17:15"},
+
+		{name: "civil dawn in polar night", coordinates: tromso, timezone: "Europe/Oslo", day: "2024-12-21", event: CivilDawn, want: "09:31"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			location := loadLocation(t, test.timezone)
+
+			day, err := time.ParseInLocation("2006-01-02", test.day, location)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			want, err := time.ParseInLocation("2006-01-02 15:04", test.day+" "+test.want, location)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			at, found := SolarTime(test.event, day.Add(12*time.Hour), test.coordinates)
+			if !found {
+				t.Fatalf("expected the event to happen")
+			}
+
+			if difference := at.Sub(want); difference > solarMargin || difference < -solarMargin {
+				t.Errorf("expected %s around %s, got %s", test.event, want, at)
+			}
+		})
+	}
+}
+
+func TestSolarTimeNotFound(t *testing.T) {
+
+	tests := []struct {
+		name  string
+		day   time.Time
+		event SolarEvent
+	}{
+		{name: "sunrise in midnight sun", day: time.Date(2024, time.June, 21, 12, 0, 0, 0, time.UTC), event: Sunrise},
+		{name: "sunset in midnight sun", day: time.Date(2024, time.June, 21, 12, 0, 0, 0, time.UTC), event: Sunset},
+		{name: "civil dusk in midnight sun", day: time.Date(2024, time.June, 21, 12, 0, 0, 0, time.UTC), event: CivilDusk},
+		{name: "sunrise in polar night", day: time.Date(2024, time.December, 21, 12, 0, 0, 0, time.UTC), event: Sunrise},
+		{name: "sunset in polar night", day: time.Date(2024, time.December, 21, 12, 0, 0, 0, time.UTC), event: Sunset},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if at, found := SolarTime(test.event, test.day, tromso); found {
+				t.Errorf("expected no %s, got %s", test.event, at)
+			}
+		})
+	}
+}
+
+func TestSolarScheduleNext(t *testing.T) {
+
+	tests := []struct {
+		name        string
+		coordinates Coordinates
+		timezone    string
+		event       SolarEvent
+		offset      time.Duration
+		after       string // after is the local time the next run is asked after, as '2006-01-02 15:04'
+		wantAfter   string // wantAfter and wantBefore bound the local time expected for the next run
+		wantBefore  string
+	}{
+		{
+			name: "sunset of the same day", coordinates: madrid, timezone: "Europe/Madrid", event: Sunset,
+			after: "2024-06-21 12:00", wantAfter: "2024-06-21 21:46", wantBefore: "2024-06-21 21:50",
+		},
+		{
+			name: "sunset of the next day", coordinates: madrid, timezone: "Europe/Madrid", event: Sunset,
+			after: "2024-06-21 22:00", wantAfter: "2024-06-22 21:46", wantBefore: "2024-06-22 21:50",
+		},
+		{
+			name: "negative offset", coordinates: madrid, timezone: "Europe/Madrid", event: Sunset, offset: -15 * time.Minute,
+			after: "2024-06-21 12:00", wantAfter: "2024-06-21 21:31", wantBefore: "2024-06-21 21:35",
+		},
+		{
+			name: "positive offset crossing midnight", coordinates: sydney, timezone: "Australia/Sydney", event: Sunset, offset: 4 * time.Hour,
+			after: "2024-12-21 21:00", wantAfter: "2024-12-22 00:03", wantBefore: "2024-12-22 00:07",
+		},
+		{
+			name: "positive offset crossing midnight already passed", coordinates: sydney, timezone: "Australia/Sydney", event: Sunset, offset: 4 * time.Hour,
+			after: "2024-12-22 01:00", wantAfter: "2024-12-23 00:03", wantBefore: "2024-12-23 00:08",
+		},
+		{
+			name: "negative offset crossing midnight", coordinates: losAngeles, timezone: "America/Los_Angeles", event: Sunrise, offset: -6 * time.Hour,
+			after: "2024-06-20 22:00", wantAfter: "2024-06-20 23:40", wantBefore: "2024-06-20 23:44",
+		},
+		{
+			name: "days without the event skipped", coordinates: tromso, timezone: "Europe/Oslo", event: Sunset,
+			after: "2024-06-01 12:00", wantAfter: "2024-07-15 00:00", wantBefore: "2024-08-01 00:00",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			location := loadLocation(t, test.timezone)
+
+			times := map[string]time.Time{}
+			for _, text := range []string{test.after, test.wantAfter, test.wantBefore} {
+				parsed, err := time.ParseInLocation("2006-01-02 15:04", text, location)
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+				times[text] = parsed
+			}
+
+			schedule := SolarSchedule{Event: test.event, Offset: test.offset, Coordinates: test.coordinates, Location: location}
+			next := schedule.Next(times[test.after])
+
+			if next.Before(times[test.wantAfter]) || next.After(times[test.wantBefore]) {
+				t.Errorf("expected the next run between %s and %s, got %s", test.wantAfter, test.wantBefore, next.In(location))
+			}
+		})
+	}
+}
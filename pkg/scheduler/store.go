@@ -0,0 +1,127 @@
+package scheduler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	// Error messages
+	StoreLoadErrorMessage = "error loading jobs: %s"
+	StoreSaveErrorMessage = "error saving jobs: %s"
+)
+
+// Store represents where jobs are kept between restarts
+type Store interface {
+	Load() (jobs []Job, err error)
+	Save(jobs []Job) (err error)
+}
+
+// storeFile represents the content of the file kept by a FileStore
+type storeFile struct {
+	Jobs []Job `json:"jobs"`
+}
+
+// FileStore keeps the jobs in a JSON file, which can be edited by hand while the scheduler is stopped
+type FileStore struct {
+	path  string
+	mutex sync.Mutex
+}
+
+// CreateFileStore creates a store kept in the file at the given path. The file is created on first save
+func CreateFileStore(path string) *FileStore {
+	return &FileStore{path: path}
+}
+
+// Load return the jobs in the file, or none when it does not exist yet
+func (f *FileStore) Load() (jobs []Job, err error) {
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	content, err := os.ReadFile(f.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return jobs, nil
+	}
+	if err != nil {
+		return jobs, errors.New(fmt.Sprintf(StoreLoadErrorMessage, err))
+	}
+
+	var file storeFile
+	if err = json.Unmarshal(content, &file); err != nil {
+		return jobs, errors.New(fmt.Sprintf(StoreLoadErrorMessage, err))
+	}
+
+	return file.Jobs, nil
+}
+
+// Save replaces the jobs in the file. The file is written aside and then renamed,
+// so a crash while saving does not leave it half written
+func (f *FileStore) Save(jobs []Job) (err error) {
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if jobs == nil {
+		jobs = []Job{}
+	}
+
+	content, err := json.MarshalIndent(storeFile{Jobs: jobs}, "", "  ")
+	if err != nil {
+		return errors.New(fmt.Sprintf(StoreSaveErrorMessage, err))
+	}
+
+	temporary, err := os.CreateTemp(filepath.Dir(f.path), filepath.Base(f.path)+".*")
+	if err != nil {
+		return errors.New(fmt.Sprintf(StoreSaveErrorMessage, err))
+	}
+	defer os.Remove(temporary.Name())
+
+	_, err = temporary.Write(append(content, '\n'))
+	if closeErr := temporary.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return errors.New(fmt.Sprintf(StoreSaveErrorMessage, err))
+	}
+
+	if err = os.Rename(temporary.Name(), f.path); err != nil {
+		return errors.New(fmt.Sprintf(StoreSaveErrorMessage, err))
+	}
+
+	return nil
+}
+
+// MemoryStore keeps the jobs in memory, so they are lost on restart
+type MemoryStore struct {
+	jobs  []Job
+	mutex sync.Mutex
+}
+
+// CreateMemoryStore creates an empty store kept in memory
+func CreateMemoryStore() *MemoryStore {
+	return &MemoryStore{}
+}
+
+// Load return the jobs saved last
+func (m *MemoryStore) Load() (jobs []Job, err error) {
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return append([]Job{}, m.jobs...), nil
+}
+
+// Save replaces the jobs in memory
+func (m *MemoryStore) Save(jobs []Job) (err error) {
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.jobs = append([]Job{}, jobs...)
+	return nil
+}
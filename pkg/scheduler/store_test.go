@@ -0,0 +1,90 @@
+package scheduler
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	wizgotypes "github.com/achetronic/wizgo/api/types"
+)
+
+func TestFileStore(t *testing.T) {
+
+	dimming := 40
+	created := time.Date(2024, time.June, 19, 8, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		jobs []Job
+	}{
+		{name: "no jobs"},
+		{
+			name: "every kind of job",
+			jobs: []Job{
+				{
+					Id: "night", Name: "Night light", Cron: "30 23 * * *", Timezone: "Europe/Madrid",
+					Action:    SetPilot(Targets{Rooms: []string{"bedroom"}}, wizgotypes.PilotState{Dimming: &dimming}),
+					CreatedAt: created, LastScheduled: created.Add(time.Hour), LastRun: created.Add(time.Hour),
+					LastError: "device 'lamp' not found",
+				},
+				{
+					Id: "dusk", Solar: &SolarTrigger{Event: CivilDusk, Offset: Duration(-15 * time.Minute), Coordinates: &Coordinates{Latitude: 40.4168, Longitude: -3.7038}},
+					Action:          Transition(Targets{Devices: []string{"a8:bb:50:00:00:01"}}, wizgotypes.PilotState{Dimming: &dimming}, 90*time.Second, "inOut"),
+					MissedRunPolicy: MissedRunOnce, CreatedAt: created,
+				},
+				{
+					Id: "once", At: "2024-12-24 20:00", Action: TurnOff(Targets{Rooms: []string{"1"}}),
+					Disabled: true, CreatedAt: created,
+				},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "jobs.json")
+			store := CreateFileStore(path)
+
+			jobs, err := store.Load()
+			if err != nil || len(jobs) > 0 {
+				t.Fatalf("expected no jobs before the file exists, got %v and error: %v", jobs, err)
+			}
+
+			if err = store.Save(test.jobs); err != nil {
+				t.Fatalf("unexpected error saving: %s", err)
+			}
+
+			// A new store reads the same file, as the scheduler does after a restart
+			jobs, err = CreateFileStore(path).Load()
+			if err != nil {
+				t.Fatalf("unexpected error loading: %s", err)
+			}
+			if len(jobs) != len(test.jobs) || (len(jobs) > 0 && !reflect.DeepEqual(jobs, test.jobs)) {
+				t.Errorf("expected %+v, got %+v", test.jobs, jobs)
+			}
+
+			// Nothing is left aside of the file
+			entries, err := os.ReadDir(filepath.Dir(path))
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if len(entries) != 1 {
+				t.Errorf("expected only the store file, got %d files", len(entries))
+			}
+		})
+	}
+}
+
+func TestFileStoreInvalidFile(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "jobs.json")
+	if err := os.WriteFile(path, []byte(`{"jobs": [`), 0o600); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if _, err := CreateFileStore(path).Load(); err == nil {
+		t.Errorf("expected an error loading a broken file")
+	}
+}
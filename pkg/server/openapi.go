@@ -0,0 +1,192 @@
+package server
+
+import (
+	"reflect"
+	"strings"
+	"time"
+)
+
+const (
+	// OpenApiVersion is the version of the OpenAPI specification followed by the generated spec
+	OpenApiVersion = "3.0.3"
+)
+
+// OpenApi return the OpenAPI spec of the API, generated from its routes and the types they exchange,
+// so it never gets out of sync with the handlers
+func (s *Server) OpenApi() map[string]interface{} {
+
+	schemas := map[string]interface{}{}
+	paths := map[string]interface{}{}
+
+	errorSchema := schemaOf(reflect.TypeOf(ErrorResponse{}), schemas)
+
+	for _, route := range s.routes {
+		operation := map[string]interface{}{
+			"operationId": route.operationId,
+			"summary":     route.summary,
+		}
+
+		if strings.Contains(route.pattern, "{mac}") {
+			operation["parameters"] = []interface{}{
+				map[string]interface{}{
+					"name":        "mac",
+					"in":          "path",
+					"required":    true,
+					"description": "MAC of the device, with or without separators. Names and IPs are accepted too",
+					"schema":      map[string]interface{}{"type": "string"},
+				},
+			}
+		}
+
+		if route.request != nil {
+			operation["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content":  jsonContent(schemaOf(reflect.TypeOf(route.request), schemas)),
+			}
+		}
+
+		responses := map[string]interface{}{
+			"default": map[string]interface{}{
+				"description": "Error",
+				"content":     jsonContent(errorSchema),
+			},
+		}
+
+		if route.response != nil {
+			responses["200"] = map[string]interface{}{
+				"description": "Success",
+				"content":     jsonContent(schemaOf(reflect.TypeOf(route.response), schemas)),
+			}
+		} else {
+			responses["204"] = map[string]interface{}{
+				"description": "Success",
+			}
+		}
+		operation["responses"] = responses
+
+		pathItem, found := paths[route.pattern].(map[string]interface{})
+		if !found {
+			pathItem = map[string]interface{}{}
+			paths[route.pattern] = pathItem
+		}
+		pathItem[strings.ToLower(route.method)] = operation
+	}
+
+	return map[string]interface{}{
+		"openapi": OpenApiVersion,
+		"info": map[string]interface{}{
+			"title":   s.options.Title,
+			"version": s.options.Version,
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": schemas,
+		},
+	}
+}
+
+// jsonContent return the content of a body encoded as JSON with the given schema
+func jsonContent(schema map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"application/json": map[string]interface{}{
+			"schema": schema,
+		},
+	}
+}
+
+// schemaOf return the JSON schema of the given type. Named structs are added to the schemas
+// and referenced, so they are described once
+func schemaOf(t reflect.Type, schemas map[string]interface{}) map[string]interface{} {
+
+	if t == reflect.TypeOf(time.Time{}) {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return schemaOf(t.Elem(), schemas)
+
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": schemaOf(t.Elem(), schemas)}
+
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": schemaOf(t.Elem(), schemas)}
+
+	case reflect.Struct:
+		if t.Name() == "" {
+			return structSchema(t, schemas)
+		}
+
+		// The placeholder stops the recursion on types referencing themselves
+		if _, found := schemas[t.Name()]; !found {
+			schemas[t.Name()] = map[string]interface{}{}
+			schemas[t.Name()] = structSchema(t, schemas)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + t.Name()}
+	}
+
+	return map[string]interface{}{}
+}
+
+// structSchema return the JSON schema of a struct, following the same rules as encoding/json
+func structSchema(t reflect.Type, schemas map[string]interface{}) map[string]interface{} {
+
+	properties := map[string]interface{}{}
+	var required []string
+
+	addFields(t, schemas, properties, &required)
+
+	schema := map[string]interface{}{
+		"type":       "object",
+		"properties": properties,
+	}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// addFields adds the fields of the struct to the properties, flattening the embedded structs
+func addFields(t reflect.Type, schemas map[string]interface{}, properties map[string]interface{}, required *[]string) {
+
+	for index := 0; index < t.NumField(); index++ {
+		field := t.Field(index)
+
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+
+		name, options, _ := strings.Cut(tag, ",")
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			addFields(field.Type, schemas, properties, required)
+			continue
+		}
+
+		if !field.IsExported() {
+			continue
+		}
+
+		if name == "" {
+			name = field.Name
+		}
+
+		properties[name] = schemaOf(field.Type, schemas)
+		if !strings.Contains(options, "omitempty") && field.Type.Kind() != reflect.Pointer {
+			*required = append(*required, name)
+		}
+	}
+}
@@ -0,0 +1,390 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	wizgotypes "github.com/achetronic/wizgo/api/types"
+	"github.com/achetronic/wizgo/pkg/wizgo"
+)
+
+const (
+	// DefaultRequestTimeout is how long a request waits for the devices when no other timeout is given
+	DefaultRequestTimeout = 5 * time.Second
+
+	// Error messages
+	InvalidBodyErrorMessage      = "invalid request body: %s"
+	RouteNotFoundErrorMessage    = "no route for %s"
+	MethodNotAllowedErrorMessage = "method %s not allowed on %s"
+)
+
+// Options represents the settings used when creating a Server
+type Options struct {
+	// RequestTimeout bounds the time spent talking to the devices on each request. Default: DefaultRequestTimeout
+	RequestTimeout time.Duration
+
+	// Title and Version are shown in the OpenAPI spec
+	Title   string
+	Version string
+}
+
+// Scene represents a scene as listed by the API
+type Scene struct {
+	Id   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+// StateRequest represents the changes accepted when setting the state of a device. Fields only reported
+// by the devices, as mac or rssi, are left out, so requests carrying them are refused instead of ignored
+type StateRequest struct {
+	State   *bool `json:"state,omitempty"`   // State turns the device on or off. Turning off takes no other field
+	SceneId *int  `json:"sceneId,omitempty"` // SceneId plays the scene with the given id
+	Speed   *int  `json:"speed,omitempty"`   // Speed of the scene (10-200)
+	Ratio   *int  `json:"ratio,omitempty"`   // Ratio between the up and down light (1-100)
+
+	R    *int `json:"r,omitempty"`    // (0-255)
+	G    *int `json:"g,omitempty"`    // (0-255)
+	B    *int `json:"b,omitempty"`    // (0-255)
+	C    *int `json:"c,omitempty"`    // C is the value of the cold white led (0-255)
+	W    *int `json:"w,omitempty"`    // W is the value of the warm white led (0-255)
+	Temp *int `json:"temp,omitempty"` // Temp is the color temperature in kelvin
+
+	Dimming *int `json:"dimming,omitempty"` // Dimming is the brightness (10-100)
+}
+
+// PilotState return the state holding the changes of the request
+func (s StateRequest) PilotState() wizgotypes.PilotState {
+	return wizgotypes.PilotState{
+		State: s.State, SceneId: s.SceneId, Speed: s.Speed, Ratio: s.Ratio,
+		R: s.R, G: s.G, B: s.B, C: s.C, W: s.W, Temp: s.Temp,
+		Dimming: s.Dimming,
+	}
+}
+
+// ErrorResponse represents the body sent when a request fails
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
+
+// Server exposes the devices of an inventory over a REST API
+type Server struct {
+	inventory *wizgo.Inventory
+	options   Options
+	routes    []route
+}
+
+// route represents an operation of the API. The request and response types are used to build the OpenAPI spec
+type route struct {
+	method      string
+	pattern     string // pattern of the path, where '{mac}' matches any segment
+	operationId string
+	summary     string
+	request     interface{} // request is a value of the type expected in the body, or nil
+	response    interface{} // response is a value of the type sent on success, or nil for no content
+	handle      func(w http.ResponseWriter, r *http.Request, mac string) error
+}
+
+// CreateServer creates a server exposing the devices of the given inventory. The inventory is not refreshed
+// by the server, so devices must be discovered or added by the caller
+func CreateServer(inventory *wizgo.Inventory, options Options) *Server {
+
+	if options.RequestTimeout <= 0 {
+		options.RequestTimeout = DefaultRequestTimeout
+	}
+
+	if options.Title == "" {
+		options.Title = "wizgo"
+	}
+
+	if options.Version == "" {
+		options.Version = "1.0.0"
+	}
+
+	s := &Server{
+		inventory: inventory,
+		options:   options,
+	}
+
+	s.routes = []route{
+		{
+			method: http.MethodGet, pattern: "/devices", operationId: "listDevices",
+			summary:  "List the devices in the inventory",
+			response: []wizgo.InventoryDevice{},
+			handle:   s.listDevices,
+		},
+		{
+			method: http.MethodGet, pattern: "/devices/{mac}", operationId: "getDevice",
+			summary:  "Get a device of the inventory",
+			response: wizgo.InventoryDevice{},
+			handle:   s.getDevice,
+		},
+		{
+			method: http.MethodGet, pattern: "/devices/{mac}/state", operationId: "getDeviceState",
+			summary:  "Read the current state of a device",
+			response: wizgotypes.PilotState{},
+			handle:   s.getDeviceState,
+		},
+		{
+			method: http.MethodPatch, pattern: "/devices/{mac}/state", operationId: "setDeviceState",
+			summary: "Change the state of a device. All the given fields are applied at once",
+			request: StateRequest{},
+			handle:  s.setDeviceState,
+		},
+		{
+			method: http.MethodPost, pattern: "/devices/{mac}/pulse", operationId: "pulseDevice",
+			summary: "Send a pulse of light to a device",
+			handle:  s.pulseDevice,
+		},
+		{
+			method: http.MethodGet, pattern: "/scenes", operationId: "listScenes",
+			summary:  "List the scenes known by the devices",
+			response: []Scene{},
+			handle:   s.listScenes,
+		},
+		{
+			method: http.MethodGet, pattern: "/openapi.json", operationId: "getOpenApi",
+			summary:  "Get the OpenAPI spec of this API",
+			response: map[string]interface{}{},
+			handle:   s.getOpenApi,
+		},
+	}
+
+	return s
+}
+
+// Handler return the HTTP handler serving the API
+func (s *Server) Handler() http.Handler {
+	return s
+}
+
+// ServeHTTP routes the request to its operation
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	var allowedMethods []string
+	for _, route := range s.routes {
+		mac, matched := matchPattern(route.pattern, r.URL.Path)
+		if !matched {
+			continue
+		}
+
+		if route.method != r.Method {
+			allowedMethods = append(allowedMethods, route.method)
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), s.options.RequestTimeout)
+		defer cancel()
+
+		if err := route.handle(w, r.WithContext(ctx), mac); err != nil {
+			writeError(w, err)
+		}
+		return
+	}
+
+	if len(allowedMethods) > 0 {
+		w.Header().Set("Allow", strings.Join(allowedMethods, ", "))
+		writeJson(w, http.StatusMethodNotAllowed, ErrorResponse{Error: fmt.Sprintf(MethodNotAllowedErrorMessage, r.Method, r.URL.Path)})
+		return
+	}
+	writeJson(w, http.StatusNotFound, ErrorResponse{Error: fmt.Sprintf(RouteNotFoundErrorMessage, r.URL.Path)})
+}
+
+// listDevices writes all the devices of the inventory
+func (s *Server) listDevices(w http.ResponseWriter, r *http.Request, mac string) error {
+
+	devices := s.inventory.Devices()
+	if devices == nil {
+		devices = []wizgo.InventoryDevice{}
+	}
+
+	writeJson(w, http.StatusOK, devices)
+	return nil
+}
+
+// getDevice writes the device with the MAC given in the path
+func (s *Server) getDevice(w http.ResponseWriter, r *http.Request, mac string) error {
+
+	device, err := s.inventory.Lookup(mac)
+	if err != nil {
+		return err
+	}
+
+	writeJson(w, http.StatusOK, device)
+	return nil
+}
+
+// getDeviceState writes the state read from the device
+func (s *Server) getDeviceState(w http.ResponseWriter, r *http.Request, mac string) error {
+
+	wizClient, err := s.client(mac)
+	if err != nil {
+		return err
+	}
+
+	state, err := wizClient.ReadPilotStateContext(r.Context())
+	if err != nil {
+		return err
+	}
+
+	writeJson(w, http.StatusOK, state)
+	return nil
+}
+
+// setDeviceState applies the state given in the body in a single 'setPilot' message
+func (s *Server) setDeviceState(w http.ResponseWriter, r *http.Request, mac string) error {
+
+	var state StateRequest
+
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&state); err != nil {
+		return &requestError{err: errors.New(fmt.Sprintf(InvalidBodyErrorMessage, err))}
+	}
+
+	builder := wizgo.CreatePilotBuilderFromState(state.PilotState())
+	if _, err := builder.Params(); err != nil {
+		return &requestError{err: err}
+	}
+
+	wizClient, err := s.client(mac)
+	if err != nil {
+		return err
+	}
+
+	_, err = wizClient.SetPilotContext(r.Context(), builder)
+	if err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// pulseDevice sends a pulse of light to the device
+func (s *Server) pulseDevice(w http.ResponseWriter, r *http.Request, mac string) error {
+
+	wizClient, err := s.client(mac)
+	if err != nil {
+		return err
+	}
+
+	_, err = wizClient.PulseContext(r.Context())
+	if err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// listScenes writes the scenes in WizScenes, sorted by id
+func (s *Server) listScenes(w http.ResponseWriter, r *http.Request, mac string) error {
+
+	scenes := make([]Scene, 0, len(wizgo.WizScenes))
+	for id, name := range wizgo.WizScenes {
+		scenes = append(scenes, Scene{Id: id, Name: name})
+	}
+
+	sort.Slice(scenes, func(i, j int) bool {
+		return scenes[i].Id < scenes[j].Id
+	})
+
+	writeJson(w, http.StatusOK, scenes)
+	return nil
+}
+
+// getOpenApi writes the OpenAPI spec of the API
+func (s *Server) getOpenApi(w http.ResponseWriter, r *http.Request, mac string) error {
+	writeJson(w, http.StatusOK, s.OpenApi())
+	return nil
+}
+
+// client return the client of the device with the given MAC
+func (s *Server) client(mac string) (*wizgo.WizClient, error) {
+
+	device, err := s.inventory.Lookup(mac)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.inventory.Client(device.Mac)
+}
+
+// requestError is returned by the handlers when the request itself is wrong
+type requestError struct {
+	err error
+}
+
+func (e *requestError) Error() string {
+	return e.err.Error()
+}
+
+func (e *requestError) Unwrap() error {
+	return e.err
+}
+
+// statusOf return the HTTP status code matching the given error
+func statusOf(err error) int {
+
+	var requestErr *requestError
+	var rangeErr *wizgo.RangeError
+	var timeoutErr *wizgo.TimeoutError
+	var deviceErr *wizgo.DeviceError
+
+	switch {
+	case errors.As(err, &requestErr), errors.As(err, &rangeErr):
+		return http.StatusBadRequest
+	case errors.Is(err, wizgo.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, wizgo.ErrNotSupported):
+		return http.StatusUnprocessableEntity
+	case errors.As(err, &timeoutErr), errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	case errors.As(err, &deviceErr):
+		return http.StatusBadGateway
+	}
+
+	return http.StatusInternalServerError
+}
+
+// writeError writes the error with its matching status code
+func writeError(w http.ResponseWriter, err error) {
+	writeJson(w, statusOf(err), ErrorResponse{Error: err.Error()})
+}
+
+// writeJson writes the value as the JSON body of the response.
+// Encoding errors are not reported, as the status code is already sent
+func writeJson(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(value)
+}
+
+// matchPattern return whether the path matches the pattern, and the value of the '{mac}' segment when present
+func matchPattern(pattern string, path string) (mac string, matched bool) {
+
+	patternSegments := strings.Split(strings.Trim(pattern, "/"), "/")
+	pathSegments := strings.Split(strings.Trim(path, "/"), "/")
+
+	if len(patternSegments) != len(pathSegments) {
+		return mac, false
+	}
+
+	for index, segment := range patternSegments {
+		if segment == "{mac}" {
+			mac = pathSegments[index]
+			continue
+		}
+		if segment != pathSegments[index] {
+			return mac, false
+		}
+	}
+
+	return mac, true
+}
@@ -0,0 +1,323 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	wizgotypes "github.com/achetronic/wizgo/api/types"
+	"github.com/achetronic/wizgo/pkg/emulator"
+	"github.com/achetronic/wizgo/pkg/wizgo"
+)
+
+// startEmulator starts an emulated device that is closed when the test ends
+func startEmulator(t *testing.T, options emulator.Options) *emulator.Emulator {
+	t.Helper()
+
+	device, err := emulator.Start(options)
+	if err != nil {
+		t.Fatalf("error starting the emulator: %s", err)
+	}
+	t.Cleanup(func() { _ = device.Close() })
+
+	return device
+}
+
+// startServer serves the API over an inventory holding the given emulated devices
+func startServer(t *testing.T, options Options, devices ...*emulator.Emulator) *httptest.Server {
+	t.Helper()
+
+	inventory := wizgo.CreateInventory(wizgo.WizClientOptions{RetryPolicy: wizgo.NoRetryPolicy})
+	t.Cleanup(func() { _ = inventory.Close() })
+
+	for _, device := range devices {
+		inventory.Update(wizgo.DiscoveredDevice{Ip: device.Host(), Port: device.Port(), Mac: device.Mac()})
+	}
+
+	server := httptest.NewServer(CreateServer(inventory, options).Handler())
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func TestServer(t *testing.T) {
+
+	rgb := startEmulator(t, emulator.Options{Model: emulator.ModelRgb})
+	dw := startEmulator(t, emulator.Options{Model: emulator.ModelDw})
+	broken := startEmulator(t, emulator.Options{})
+	broken.InjectError("getPilot", emulator.MethodNotFoundCode)
+	slow := startEmulator(t, emulator.Options{Latency: time.Second})
+
+	server := startServer(t, Options{RequestTimeout: 200 * time.Millisecond}, rgb, dw, broken, slow)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+
+		wantStatus int
+		check      func(t *testing.T, body []byte)
+	}{
+		{
+			name: "list devices", method: http.MethodGet, path: "/devices",
+			wantStatus: http.StatusOK,
+			check: func(t *testing.T, body []byte) {
+				var devices []wizgo.InventoryDevice
+				if err := json.Unmarshal(body, &devices); err != nil || len(devices) != 4 {
+					t.Errorf("expected the 4 devices, got: %s", body)
+				}
+			},
+		},
+		{
+			name: "get device", method: http.MethodGet, path: "/devices/" + rgb.Mac(),
+			wantStatus: http.StatusOK,
+			check: func(t *testing.T, body []byte) {
+				var device wizgo.InventoryDevice
+				if err := json.Unmarshal(body, &device); err != nil || device.Address() != rgb.Address() {
+					t.Errorf("expected the device at %s, got: %s", rgb.Address(), body)
+				}
+			},
+		},
+		{
+			name: "get unknown device", method: http.MethodGet, path: "/devices/a8bb50ffffff",
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name: "get device state", method: http.MethodGet, path: "/devices/" + rgb.Mac() + "/state",
+			wantStatus: http.StatusOK,
+			check: func(t *testing.T, body []byte) {
+				var state wizgotypes.PilotState
+				if err := json.Unmarshal(body, &state); err != nil || state.Dimming == nil {
+					t.Errorf("expected the state of the device, got: %s", body)
+				}
+			},
+		},
+		{
+			name: "patch device state", method: http.MethodPatch, path: "/devices/" + rgb.Mac() + "/state",
+			body:       `{"r": 255, "g": 0, "b": 0, "dimming": 40}`,
+			wantStatus: http.StatusNoContent,
+			check: func(t *testing.T, body []byte) {
+				state := rgb.PilotState()
+				if state.R == nil || *state.R != 255 || state.Dimming == nil || *state.Dimming != 40 {
+					t.Errorf("expected the device changed, got: %+v", state)
+				}
+			},
+		},
+		{
+			name: "patch unknown device", method: http.MethodPatch, path: "/devices/a8bb50ffffff/state",
+			body:       `{"dimming": 40}`,
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name: "malformed body", method: http.MethodPatch, path: "/devices/" + rgb.Mac() + "/state",
+			body:       `{"dimming": `,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "unknown field", method: http.MethodPatch, path: "/devices/" + rgb.Mac() + "/state",
+			body:       `{"brightness": 40}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "read-only mac", method: http.MethodPatch, path: "/devices/" + rgb.Mac() + "/state",
+			body:       `{"mac": "a8bb50000001", "dimming": 40}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "read-only src", method: http.MethodPatch, path: "/devices/" + rgb.Mac() + "/state",
+			body:       `{"src": "udp"}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "read-only rssi", method: http.MethodPatch, path: "/devices/" + rgb.Mac() + "/state",
+			body:       `{"rssi": -50}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "read-only rhythm", method: http.MethodPatch, path: "/devices/" + rgb.Mac() + "/state",
+			body:       `{"schdPsetId": 2}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "incompatible fields", method: http.MethodPatch, path: "/devices/" + rgb.Mac() + "/state",
+			body:       `{"state": false, "dimming": 40}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "value out of range", method: http.MethodPatch, path: "/devices/" + rgb.Mac() + "/state",
+			body:       `{"dimming": 5}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "temperature out of the range of the device", method: http.MethodPatch, path: "/devices/" + rgb.Mac() + "/state",
+			body:       `{"temp": 8000}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "not supported by the device", method: http.MethodPatch, path: "/devices/" + dw.Mac() + "/state",
+			body:       `{"temp": 3000}`,
+			wantStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name: "device error", method: http.MethodGet, path: "/devices/" + broken.Mac() + "/state",
+			wantStatus: http.StatusBadGateway,
+		},
+		{
+			name: "device not answering", method: http.MethodGet, path: "/devices/" + slow.Mac() + "/state",
+			wantStatus: http.StatusGatewayTimeout,
+		},
+		{
+			name: "method not allowed", method: http.MethodPatch, path: "/devices/" + rgb.Mac(),
+			body:       `{}`,
+			wantStatus: http.StatusMethodNotAllowed,
+		},
+		{
+			name: "unknown route", method: http.MethodGet, path: "/lights",
+			wantStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request, err := http.NewRequest(test.method, server.URL+test.path, strings.NewReader(test.body))
+			if err != nil {
+				t.Fatalf("error creating the request: %s", err)
+			}
+
+			response, err := http.DefaultClient.Do(request)
+			if err != nil {
+				t.Fatalf("error sending the request: %s", err)
+			}
+			defer response.Body.Close()
+
+			body, err := io.ReadAll(response.Body)
+			if err != nil {
+				t.Fatalf("error reading the response: %s", err)
+			}
+
+			if response.StatusCode != test.wantStatus {
+				t.Fatalf("expected status %d, got %d: %s", test.wantStatus, response.StatusCode, body)
+			}
+
+			// Failed requests always explain the reason
+			if response.StatusCode >= http.StatusBadRequest {
+				var errorResponse ErrorResponse
+				if err = json.Unmarshal(body, &errorResponse); err != nil || errorResponse.Error == "" {
+					t.Errorf("expected an ErrorResponse, got: %s", body)
+				}
+			}
+
+			if test.check != nil {
+				test.check(t, body)
+			}
+		})
+	}
+}
+
+func TestOpenApi(t *testing.T) {
+
+	server := startServer(t, Options{Title: "lights", Version: "2.0.0"})
+
+	response, err := http.Get(server.URL + "/openapi.json")
+	if err != nil {
+		t.Fatalf("error sending the request: %s", err)
+	}
+	defer response.Body.Close()
+
+	var spec struct {
+		OpenApi string `json:"openapi"`
+		Info    struct {
+			Title   string `json:"title"`
+			Version string `json:"version"`
+		} `json:"info"`
+		Paths      map[string]map[string]map[string]interface{} `json:"paths"`
+		Components struct {
+			Schemas map[string]map[string]interface{} `json:"schemas"`
+		} `json:"components"`
+	}
+	if err = json.NewDecoder(response.Body).Decode(&spec); err != nil {
+		t.Fatalf("error decoding the spec: %s", err)
+	}
+
+	if spec.OpenApi != OpenApiVersion || spec.Info.Title != "lights" || spec.Info.Version != "2.0.0" {
+		t.Errorf("unexpected header of the spec: %s %s %s", spec.OpenApi, spec.Info.Title, spec.Info.Version)
+	}
+
+	tests := []struct {
+		path        string
+		method      string
+		operationId string
+		wantBody    bool
+		wantStatus  string
+	}{
+		{path: "/devices", method: "get", operationId: "listDevices", wantStatus: "200"},
+		{path: "/devices/{mac}", method: "get", operationId: "getDevice", wantStatus: "200"},
+		{path: "/devices/{mac}/state", method: "get", operationId: "getDeviceState", wantStatus: "200"},
+		{path: "/devices/{mac}/state", method: "patch", operationId: "setDeviceState", wantBody: true, wantStatus: "204"},
+		{path: "/devices/{mac}/pulse", method: "post", operationId: "pulseDevice", wantStatus: "204"},
+		{path: "/scenes", method: "get", operationId: "listScenes", wantStatus: "200"},
+		{path: "/openapi.json", method: "get", operationId: "getOpenApi", wantStatus: "200"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.operationId, func(t *testing.T) {
+			operation, found := spec.Paths[test.path][test.method]
+			if !found {
+				t.Fatalf("operation %s %s not found in the spec", test.method, test.path)
+			}
+
+			if operation["operationId"] != test.operationId {
+				t.Errorf("expected operation '%s', got '%v'", test.operationId, operation["operationId"])
+			}
+
+			if _, found = operation["requestBody"]; found != test.wantBody {
+				t.Errorf("expected request body to be %t", test.wantBody)
+			}
+
+			responses, _ := operation["responses"].(map[string]interface{})
+			if _, found = responses[test.wantStatus]; !found {
+				t.Errorf("expected a '%s' response, got: %v", test.wantStatus, responses)
+			}
+			if _, found = responses["default"]; !found {
+				t.Errorf("expected the error response")
+			}
+
+			_, hasParameters := operation["parameters"]
+			if wantParameters := strings.Contains(test.path, "{mac}"); hasParameters != wantParameters {
+				t.Errorf("expected the mac parameter to be %t", wantParameters)
+			}
+		})
+	}
+
+	// Types exchanged by the routes are described once and referenced
+	for _, schema := range []string{"ErrorResponse", "InventoryDevice", "PilotState", "Scene", "StateRequest"} {
+		if _, found := spec.Components.Schemas[schema]; !found {
+			t.Errorf("expected the schema of '%s'", schema)
+		}
+	}
+
+	properties, _ := spec.Components.Schemas["InventoryDevice"]["properties"].(map[string]interface{})
+	for _, property := range []string{"mac", "ip", "name", "lastSeen"} {
+		if _, found := properties[property]; !found {
+			t.Errorf("expected the property '%s' in the schema of the devices", property)
+		}
+	}
+
+	// Only the fields that can be changed are offered when setting the state
+	properties, _ = spec.Components.Schemas["StateRequest"]["properties"].(map[string]interface{})
+	for _, property := range []string{"state", "sceneId", "r", "temp", "dimming"} {
+		if _, found := properties[property]; !found {
+			t.Errorf("expected the property '%s' in the schema of the state requests", property)
+		}
+	}
+	for _, property := range []string{"mac", "src", "rssi", "schdPsetId"} {
+		if _, found := properties[property]; found {
+			t.Errorf("expected no read-only property '%s' in the schema of the state requests", property)
+		}
+	}
+}
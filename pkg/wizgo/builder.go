@@ -0,0 +1,226 @@
+package wizgo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	wizgotypes "github.com/achetronic/wizgo/api/types"
+)
+
+var (
+	// incompatiblePilotFields lists the pairs of fields the devices can not apply together in one 'setPilot'
+	incompatiblePilotFields = [][2]string{
+		{"temp", "r"}, {"temp", "g"}, {"temp", "b"}, {"temp", "c"}, {"temp", "w"},
+		{"sceneId", "r"}, {"sceneId", "g"}, {"sceneId", "b"}, {"sceneId", "c"}, {"sceneId", "w"},
+		{"sceneId", "temp"},
+	}
+)
+
+// PilotBuilder composes several changes of the light into a single 'setPilot' message,
+// so they are applied at once, without flickering through intermediate states.
+// Each field is validated with the same rules used by its single setter (SetRgb, SetBrightness, etc.)
+type PilotBuilder struct {
+	wizClient *WizClient
+	params    wizgotypes.WizMessageParams
+	errs      []error
+}
+
+// CreatePilotBuilder creates an empty builder not bound to any client. It can be sent with WizClient.SetPilot
+func CreatePilotBuilder() *PilotBuilder {
+	return &PilotBuilder{
+		params: wizgotypes.WizMessageParams{},
+	}
+}
+
+// CreatePilotBuilderFromState creates a builder not bound to any client with the fields set in the given state.
+// Fields only reported by the devices (mac, src, rssi, schdPsetId) are ignored, and so is a zero sceneId
+func CreatePilotBuilderFromState(state wizgotypes.PilotState) *PilotBuilder {
+
+	builder := CreatePilotBuilder()
+
+	if state.State != nil {
+		builder.State(*state.State)
+	}
+
+	switch {
+	case state.R != nil && state.G != nil && state.B != nil:
+		builder.Rgb(*state.R, *state.G, *state.B)
+	case state.R != nil || state.G != nil || state.B != nil:
+		builder.errs = append(builder.errs, errors.New(IncompleteRgbErrorMessage))
+	}
+
+	if state.C != nil {
+		builder.ColdWhite(*state.C)
+	}
+	if state.W != nil {
+		builder.WarmWhite(*state.W)
+	}
+	if state.Temp != nil {
+		builder.Temperature(*state.Temp)
+	}
+	if state.Dimming != nil {
+		builder.Brightness(*state.Dimming)
+	}
+	if state.SceneId != nil && *state.SceneId != 0 {
+		builder.Scene(*state.SceneId)
+	}
+	if state.Speed != nil {
+		builder.Speed(*state.Speed)
+	}
+	if state.Ratio != nil {
+		builder.Ratio(*state.Ratio)
+	}
+
+	return builder
+}
+
+// Pilot creates an empty builder bound to this client, so it can be sent with Send
+func (w *WizClient) Pilot() *PilotBuilder {
+	builder := CreatePilotBuilder()
+	builder.wizClient = w
+	return builder
+}
+
+// set stores the value of a field, or the error when it is not valid
+func (b *PilotBuilder) set(err error, fields map[string]interface{}) *PilotBuilder {
+	if err != nil {
+		b.errs = append(b.errs, err)
+		return b
+	}
+
+	for field, value := range fields {
+		b.params[field] = value
+	}
+	return b
+}
+
+// State turns the device on or off
+func (b *PilotBuilder) State(state bool) *PilotBuilder {
+	return b.set(nil, map[string]interface{}{"state": state})
+}
+
+// Rgb sets the color (3 x 0-255)
+func (b *PilotBuilder) Rgb(r, g, bl int) *PilotBuilder {
+	return b.set(validateRgb(r, g, bl), map[string]interface{}{"r": r, "g": g, "b": bl})
+}
+
+// ColdWhite sets the level of light given by cold white LEDs (0-255)
+func (b *PilotBuilder) ColdWhite(coldWhite int) *PilotBuilder {
+	return b.set(validateLed("c", coldWhite), map[string]interface{}{"c": coldWhite})
+}
+
+// WarmWhite sets the level of light given by warm white LEDs (0-255)
+func (b *PilotBuilder) WarmWhite(warmWhite int) *PilotBuilder {
+	return b.set(validateLed("w", warmWhite), map[string]interface{}{"w": warmWhite})
+}
+
+// Temperature sets the color temperature in kelvin. The range advertised by the device is checked when sending
+func (b *PilotBuilder) Temperature(temperature int) *PilotBuilder {
+	return b.set(validateTemperature(temperature), map[string]interface{}{"temp": temperature})
+}
+
+// Brightness sets the brightness (10-100)
+func (b *PilotBuilder) Brightness(brightness int) *PilotBuilder {
+	return b.set(validateBrightness(brightness), map[string]interface{}{"dimming": brightness})
+}
+
+// Scene sets a scene by its ID. Its availability is checked against the device when sending
+func (b *PilotBuilder) Scene(sceneId int) *PilotBuilder {
+	return b.set(nil, map[string]interface{}{"sceneId": sceneId})
+}
+
+// Speed sets the changing speed between the colors in a scene (10-200)
+func (b *PilotBuilder) Speed(speed int) *PilotBuilder {
+	return b.set(validateSpeed(speed), map[string]interface{}{"speed": speed})
+}
+
+// Ratio sets the ratio between the up and down light on dual-head devices (1-100)
+func (b *PilotBuilder) Ratio(ratio int) *PilotBuilder {
+	return b.set(validateRatio(ratio), map[string]interface{}{"ratio": ratio})
+}
+
+// Params return the params of the 'setPilot' message, or the errors found while building it
+func (b *PilotBuilder) Params() (params wizgotypes.WizMessageParams, err error) {
+
+	if len(b.errs) > 0 {
+		return params, errors.Join(b.errs...)
+	}
+
+	if len(b.params) == 0 {
+		return params, errors.New(EmptyPilotErrorMessage)
+	}
+
+	for _, pair := range incompatiblePilotFields {
+		_, firstFound := b.params[pair[0]]
+		_, secondFound := b.params[pair[1]]
+		if firstFound && secondFound {
+			return params, errors.New(fmt.Sprintf(IncompatiblePilotErrorMessage, pair[0], pair[1]))
+		}
+	}
+
+	// Turning off while changing the light makes no sense for the devices
+	if state, found := b.params["state"]; found && state == false && len(b.params) > 1 {
+		for field := range b.params {
+			if field != "state" {
+				return params, errors.New(fmt.Sprintf(IncompatiblePilotErrorMessage, "state", field))
+			}
+		}
+	}
+
+	params = wizgotypes.WizMessageParams{}
+	for field, value := range b.params {
+		params[field] = value
+	}
+
+	return params, nil
+}
+
+// Send sends the composed changes to the client the builder is bound to
+func (b *PilotBuilder) Send() (response wizgotypes.WizMessageResponse, err error) {
+	return b.SendContext(context.Background())
+}
+
+// SendContext is like Send but honours the deadline and cancellation of the given context
+func (b *PilotBuilder) SendContext(ctx context.Context) (response wizgotypes.WizMessageResponse, err error) {
+	if b.wizClient == nil {
+		return response, errors.New(PilotWithoutClientErrorMessage)
+	}
+	return b.wizClient.SetPilotContext(ctx, b)
+}
+
+// SetPilot sends all the changes composed in the builder in a single 'setPilot' message
+func (w *WizClient) SetPilot(builder *PilotBuilder) (response wizgotypes.WizMessageResponse, err error) {
+	return w.SetPilotContext(context.Background(), builder)
+}
+
+// SetPilotContext is like SetPilot but honours the deadline and cancellation of the given context
+func (w *WizClient) SetPilotContext(ctx context.Context, builder *PilotBuilder) (response wizgotypes.WizMessageResponse, err error) {
+
+	params, err := builder.Params()
+	if err != nil {
+		return response, err
+	}
+
+	if temperature, found := params["temp"]; found {
+		err = w.validateDeviceTemperature(ctx, temperature.(int))
+		if err != nil {
+			return response, err
+		}
+	}
+
+	if sceneId, found := params["sceneId"]; found {
+		err = w.checkSceneAvailable(ctx, sceneId.(int))
+		if err != nil {
+			return response, err
+		}
+	}
+
+	wizMessage := wizgotypes.WizMessage{
+		Method: "setPilot",
+		Params: params,
+	}
+
+	response, err = w.sendMessage(ctx, wizMessage)
+	return response, err
+}
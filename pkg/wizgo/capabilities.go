@@ -0,0 +1,248 @@
+package wizgo
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+
+	"golang.org/x/exp/maps"
+
+	wizgotypes "github.com/achetronic/wizgo/api/types"
+)
+
+// BulbClass represents the family of a device, which defines the light modes it supports
+type BulbClass string
+
+const (
+	BulbClassRgb     BulbClass = "RGB"     // BulbClassRgb devices have RGB, cool white and warm white LEDs
+	BulbClassTw      BulbClass = "TW"      // BulbClassTw devices have cool white and warm white LEDs
+	BulbClassDw      BulbClass = "DW"      // BulbClassDw devices have only dimmable white LEDs
+	BulbClassSocket  BulbClass = "SOCKET"  // BulbClassSocket devices can only be turned on and off
+	BulbClassFan     BulbClass = "FANDIM"  // BulbClassFan devices are fans with a dimmable light
+	BulbClassUnknown BulbClass = "UNKNOWN" // BulbClassUnknown is set when the module name can not be parsed
+)
+
+// Capabilities represents what a device supports. It is parsed from the 'moduleName' reported on 'getSystemConfig'
+// (I.E: ESP01_SHRGB1C_31), then completed with the ranges reported on 'getModelConfig'
+type Capabilities struct {
+	ModuleName string    `json:"moduleName"`
+	FwVersion  string    `json:"fwVersion,omitempty"`
+	Class      BulbClass `json:"class"`
+
+	Color            bool `json:"color"`            // Color is true when RGB colors can be set
+	ColorTemperature bool `json:"colorTemperature"` // ColorTemperature is true when white temperature can be set
+	Brightness       bool `json:"brightness"`       // Brightness is true when the light is dimmable
+	Effects          bool `json:"effects"`          // Effects is true when scenes can be set
+	Fan              bool `json:"fan"`              // Fan is true when the device has a fan
+	PowerMetering    bool `json:"powerMetering"`    // PowerMetering is true when the device measures its consumption
+	DualHead         bool `json:"dualHead"`         // DualHead is true when the ratio between up and down light can be set
+
+	KelvinMin int `json:"kelvinMin,omitempty"` // KelvinMin is the lowest white temperature advertised by the device
+	KelvinMax int `json:"kelvinMax,omitempty"` // KelvinMax is the highest white temperature advertised by the device
+}
+
+// ParseModuleName return the capabilities that can be derived from the module name of a device.
+// Devices whose module name can not be parsed are given every light feature, so nothing is refused to them
+// before the device itself is asked. Thanks to project PyWizLights for the knowledge about module names
+func ParseModuleName(moduleName string) (capabilities Capabilities) {
+
+	capabilities.ModuleName = moduleName
+	capabilities.Class = BulbClassUnknown
+
+	// Module names look like ESP01_SHRGB1C_31, where the middle part identifies the device
+	parts := strings.Split(strings.ToUpper(moduleName), "_")
+	if len(parts) < 2 {
+		capabilities.Color, capabilities.ColorTemperature, capabilities.Brightness = true, true, true
+		capabilities.Effects, capabilities.DualHead = true, true
+		return capabilities
+	}
+	identifier := parts[1]
+
+	switch {
+	case strings.Contains(identifier, "RGB"):
+		capabilities.Class = BulbClassRgb
+	case strings.Contains(identifier, "TW"):
+		capabilities.Class = BulbClassTw
+	case strings.Contains(identifier, "SOCKET"):
+		capabilities.Class = BulbClassSocket
+	case strings.Contains(identifier, "FANDIMS"):
+		capabilities.Class = BulbClassFan
+	default:
+		capabilities.Class = BulbClassDw
+	}
+
+	capabilities.Color = capabilities.Class == BulbClassRgb
+	capabilities.ColorTemperature = capabilities.Class == BulbClassRgb || capabilities.Class == BulbClassTw
+	capabilities.Brightness = capabilities.Class != BulbClassSocket
+	capabilities.Fan = capabilities.Class == BulbClassFan
+
+	// Single-head (SH) and dual-head (DH) lights are the ones playing scenes
+	capabilities.DualHead = strings.Contains(identifier, "DH")
+	capabilities.Effects = capabilities.DualHead || strings.Contains(identifier, "SH")
+
+	// Sockets built on ESP25 modules come with a power meter
+	capabilities.PowerMetering = capabilities.Class == BulbClassSocket && parts[0] == "ESP25"
+
+	return capabilities
+}
+
+// mergeConfig completes the capabilities with the information reported by the device on its configs
+func (c *Capabilities) mergeConfig(systemConfig wizgotypes.SystemConfig, modelConfig wizgotypes.ModelConfig) {
+
+	c.FwVersion = systemConfig.FwVersion
+
+	if !c.ColorTemperature {
+		return
+	}
+
+	// Newer firmwares advertise the range on 'getModelConfig', older ones on 'getSystemConfig'
+	for _, advertisedRange := range [][]int{modelConfig.CctRange, modelConfig.ExtRange, systemConfig.WhiteRange} {
+		if min, max, ok := boundsOf(advertisedRange); ok {
+			c.KelvinMin, c.KelvinMax = min, max
+			return
+		}
+	}
+}
+
+// Scenes return the ids of the scenes the device can play
+func (c Capabilities) Scenes() (scenes []int) {
+
+	if !c.Effects {
+		return scenes
+	}
+
+	switch c.Class {
+	case BulbClassRgb, BulbClassUnknown:
+		scenes = maps.Keys(WizScenes)
+		slices.Sort(scenes)
+	case BulbClassTw:
+		scenes = append(scenes, WizTwScenes...)
+	case BulbClassDw:
+		scenes = append(scenes, WizDwScenes...)
+	}
+
+	return scenes
+}
+
+// SupportsScene return true when the device can play the scene
+func (c Capabilities) SupportsScene(sceneId int) bool {
+	return slices.Contains(c.Scenes(), sceneId)
+}
+
+// Capabilities return what the device supports. They are fetched once and cached on the client
+func (w *WizClient) Capabilities() (capabilities Capabilities, err error) {
+	return w.CapabilitiesContext(context.Background())
+}
+
+// CapabilitiesContext is like Capabilities but honours the deadline and cancellation of the given context
+func (w *WizClient) CapabilitiesContext(ctx context.Context) (capabilities Capabilities, err error) {
+
+	w.capabilitiesMutex.Lock()
+	if w.capabilities != nil {
+		capabilities = *w.capabilities
+		w.capabilitiesMutex.Unlock()
+		return capabilities, nil
+	}
+	fetch := w.startCapabilitiesFetch()
+	w.capabilitiesMutex.Unlock()
+
+	return fetch.wait(ctx)
+}
+
+// RefreshCapabilities fetches again what the device supports, replacing the cached capabilities
+func (w *WizClient) RefreshCapabilities() (capabilities Capabilities, err error) {
+	return w.RefreshCapabilitiesContext(context.Background())
+}
+
+// RefreshCapabilitiesContext is like RefreshCapabilities but honours the deadline and cancellation of the given context
+func (w *WizClient) RefreshCapabilitiesContext(ctx context.Context) (capabilities Capabilities, err error) {
+
+	w.capabilitiesMutex.Lock()
+	fetch := w.startCapabilitiesFetch()
+	w.capabilitiesMutex.Unlock()
+
+	return fetch.wait(ctx)
+}
+
+// capabilitiesFetch represents a fetch of the capabilities in flight, shared by all the callers waiting for it
+type capabilitiesFetch struct {
+	done         chan struct{}
+	capabilities Capabilities
+	err          error
+}
+
+// wait return the outcome of the fetch, or the error of the context when it is done earlier
+func (c *capabilitiesFetch) wait(ctx context.Context) (capabilities Capabilities, err error) {
+	select {
+	case <-c.done:
+		return c.capabilities, c.err
+	case <-ctx.Done():
+		return capabilities, ctx.Err()
+	}
+}
+
+// startCapabilitiesFetch return the fetch in flight, or starts a new one. The fetch does not belong to any caller,
+// so it is bounded by the timeout of the client instead of their contexts, and a caller with a short deadline
+// does not make it fail for the others. It must be called holding capabilitiesMutex
+func (w *WizClient) startCapabilitiesFetch() *capabilitiesFetch {
+
+	if w.capabilitiesFetch != nil {
+		return w.capabilitiesFetch
+	}
+
+	fetch := &capabilitiesFetch{done: make(chan struct{})}
+	w.capabilitiesFetch = fetch
+
+	go func() {
+		fetch.capabilities, fetch.err = w.fetchCapabilities(context.Background())
+
+		w.capabilitiesMutex.Lock()
+		if fetch.err == nil {
+			w.capabilities = &fetch.capabilities
+		}
+		w.capabilitiesFetch = nil
+		w.capabilitiesMutex.Unlock()
+
+		close(fetch.done)
+	}()
+
+	return fetch
+}
+
+// fetchCapabilities asks the device for its configs and return the capabilities derived from them
+func (w *WizClient) fetchCapabilities(ctx context.Context) (capabilities Capabilities, err error) {
+
+	systemConfig, err := w.ReadSystemConfigContext(ctx)
+	if err != nil {
+		return capabilities, err
+	}
+
+	// Old firmwares do not know about 'getModelConfig'
+	modelConfig, err := w.ReadModelConfigContext(ctx)
+	if err != nil && !errors.Is(err, ErrMethodNotFound) {
+		return capabilities, err
+	}
+
+	capabilities = ParseModuleName(systemConfig.ModuleName)
+	capabilities.mergeConfig(systemConfig, modelConfig)
+
+	return capabilities, nil
+}
+
+// boundsOf return the lowest and highest positive values in the list
+func boundsOf(values []int) (min, max int, ok bool) {
+	for _, value := range values {
+		if value <= 0 {
+			continue
+		}
+		if !ok || value < min {
+			min = value
+		}
+		if !ok || value > max {
+			max = value
+		}
+		ok = true
+	}
+	return min, max, ok && min < max
+}
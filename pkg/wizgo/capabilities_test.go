@@ -0,0 +1,288 @@
+package wizgo
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/achetronic/wizgo/pkg/emulator"
+)
+
+func TestCapabilities(t *testing.T) {
+
+	tests := []struct {
+		name          string
+		model         emulator.Model
+		noModelConfig bool // noModelConfig emulates old firmwares, which do not know about 'getModelConfig'
+		want          Capabilities
+	}{
+		{
+			name:  "RGB",
+			model: emulator.ModelRgb,
+			want: Capabilities{
+				ModuleName: "ESP01_SHRGB1C_31", FwVersion: emulator.DefaultFwVersion, Class: BulbClassRgb,
+				Color: true, ColorTemperature: true, Brightness: true, Effects: true, KelvinMin: 2200, KelvinMax: 6500,
+			},
+		},
+		{
+			name:  "TW",
+			model: emulator.ModelTw,
+			want: Capabilities{
+				ModuleName: "ESP56_SHTW3_01", FwVersion: emulator.DefaultFwVersion, Class: BulbClassTw,
+				ColorTemperature: true, Brightness: true, Effects: true, KelvinMin: 2700, KelvinMax: 6500,
+			},
+		},
+		{
+			name:  "DW",
+			model: emulator.ModelDw,
+			want: Capabilities{
+				ModuleName: "ESP06_SHDW9_01", FwVersion: emulator.DefaultFwVersion, Class: BulbClassDw,
+				Brightness: true, Effects: true,
+			},
+		},
+		{
+			name:  "socket",
+			model: emulator.ModelSocket,
+			want: Capabilities{
+				ModuleName: "ESP10_SOCKET_06", FwVersion: emulator.DefaultFwVersion, Class: BulbClassSocket,
+			},
+		},
+		{
+			name:          "old firmware",
+			model:         emulator.ModelRgb,
+			noModelConfig: true,
+			want: Capabilities{
+				ModuleName: "ESP01_SHRGB1C_31", FwVersion: emulator.DefaultFwVersion, Class: BulbClassRgb,
+				Color: true, ColorTemperature: true, Brightness: true, Effects: true,
+			},
+		},
+		{
+			name:  "unknown module",
+			model: unknownModel,
+			want: Capabilities{
+				ModuleName: "WIZBULB", FwVersion: emulator.DefaultFwVersion, Class: BulbClassUnknown,
+				Color: true, ColorTemperature: true, Brightness: true, Effects: true, DualHead: true,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			device := startEmulator(t, emulator.Options{Model: test.model})
+			if test.noModelConfig {
+				device.InjectError("getModelConfig", emulator.MethodNotFoundCode)
+			}
+			wizClient := createClient(t, device, WizClientOptions{})
+
+			capabilities, err := wizClient.CapabilitiesContext(context.Background())
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if capabilities != test.want {
+				t.Errorf("expected %+v, got %+v", test.want, capabilities)
+			}
+		})
+	}
+}
+
+func TestParseModuleName(t *testing.T) {
+
+	tests := []struct {
+		moduleName string
+		want       Capabilities
+	}{
+		{
+			moduleName: "ESP01_SHRGB1C_31",
+			want:       Capabilities{Class: BulbClassRgb, Color: true, ColorTemperature: true, Brightness: true, Effects: true},
+		},
+		{
+			moduleName: "ESP01_DHRGB1C_31",
+			want:       Capabilities{Class: BulbClassRgb, Color: true, ColorTemperature: true, Brightness: true, Effects: true, DualHead: true},
+		},
+		{
+			moduleName: "ESP56_SHTW3_01",
+			want:       Capabilities{Class: BulbClassTw, ColorTemperature: true, Brightness: true, Effects: true},
+		},
+		{
+			moduleName: "ESP06_SHDW9_01",
+			want:       Capabilities{Class: BulbClassDw, Brightness: true, Effects: true},
+		},
+		{
+			moduleName: "ESP25_SOCKET_01",
+			want:       Capabilities{Class: BulbClassSocket, PowerMetering: true},
+		},
+		{
+			moduleName: "ESP03_FANDIMS_31",
+			want:       Capabilities{Class: BulbClassFan, Brightness: true, Fan: true},
+		},
+		{
+			moduleName: "WIZBULB",
+			want:       Capabilities{Class: BulbClassUnknown, Color: true, ColorTemperature: true, Brightness: true, Effects: true, DualHead: true},
+		},
+		{
+			moduleName: "",
+			want:       Capabilities{Class: BulbClassUnknown, Color: true, ColorTemperature: true, Brightness: true, Effects: true, DualHead: true},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.moduleName, func(t *testing.T) {
+			test.want.ModuleName = test.moduleName
+			if capabilities := ParseModuleName(test.moduleName); capabilities != test.want {
+				t.Errorf("expected %+v, got %+v", test.want, capabilities)
+			}
+		})
+	}
+}
+
+func TestUnknownDevicesAreNotRefused(t *testing.T) {
+
+	capabilities := ParseModuleName("WIZBULB")
+
+	if !capabilities.SupportsScene(1) || len(capabilities.Scenes()) != len(WizScenes) {
+		t.Errorf("expected every scene available on unknown devices")
+	}
+
+	params, err := CreatePilotBuilderFromColor(HSV{H: 120, S: 1, V: 0.5}, capabilities).Params()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, found := params["g"]; !found {
+		t.Errorf("expected the color sent to unknown devices, got %v", params)
+	}
+
+	params, err = CreatePilotBuilderFromColor(Kelvin(3000), capabilities).Params()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if params["temp"] != 3000 {
+		t.Errorf("expected the temperature sent to unknown devices, got %v", params)
+	}
+}
+
+func TestCapabilitiesFetch(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		latency time.Duration
+		callers int
+		timeout time.Duration // timeout of the callers' contexts, zero for none
+
+		wantErr error
+	}{
+		{name: "single caller", callers: 1},
+		{name: "concurrent callers share the fetch", latency: 50 * time.Millisecond, callers: 20},
+		{name: "callers giving up do not cancel the fetch", latency: 200 * time.Millisecond, callers: 5, timeout: 20 * time.Millisecond, wantErr: context.DeadlineExceeded},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			device := startEmulator(t, emulator.Options{Latency: test.latency})
+			wizClient := createClient(t, device, WizClientOptions{})
+
+			errs := make([]error, test.callers)
+
+			var wg sync.WaitGroup
+			for index := range errs {
+				wg.Add(1)
+				go func(index int) {
+					defer wg.Done()
+
+					ctx := context.Background()
+					if test.timeout > 0 {
+						var cancel context.CancelFunc
+						ctx, cancel = context.WithTimeout(ctx, test.timeout)
+						defer cancel()
+					}
+
+					_, errs[index] = wizClient.CapabilitiesContext(ctx)
+				}(index)
+			}
+			wg.Wait()
+
+			for _, err := range errs {
+				if !errors.Is(err, test.wantErr) {
+					t.Errorf("expected error %v, got: %v", test.wantErr, err)
+				}
+			}
+
+			// The fetch keeps going for the next callers, so the capabilities are cached without asking again
+			if _, err := wizClient.CapabilitiesContext(context.Background()); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if _, err := wizClient.CapabilitiesContext(context.Background()); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if fetches := len(receivedMessages(device, "getSystemConfig")); fetches != 1 {
+				t.Errorf("expected the capabilities fetched once, got %d fetches", fetches)
+			}
+		})
+	}
+}
+
+func TestCapabilitiesOfSilentDevice(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		callers int
+	}{
+		{name: "single caller", callers: 1},
+		{name: "concurrent callers", callers: 10},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			device := startEmulator(t, emulator.Options{LossRate: 1})
+			wizClient := createClient(t, device, WizClientOptions{Timeout: 10 * time.Second})
+
+			errs := make([]error, test.callers)
+			elapsed := make([]time.Duration, test.callers)
+
+			var wg sync.WaitGroup
+			for index := range errs {
+				wg.Add(1)
+				go func(index int) {
+					defer wg.Done()
+
+					ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+					defer cancel()
+
+					start := time.Now()
+					_, errs[index] = wizClient.CapabilitiesContext(ctx)
+					elapsed[index] = time.Since(start)
+				}(index)
+			}
+			wg.Wait()
+
+			// Callers give up on their own deadline, long before the fetch times out
+			for index, err := range errs {
+				if !errors.Is(err, context.DeadlineExceeded) {
+					t.Errorf("expected error %v, got: %v", context.DeadlineExceeded, err)
+				}
+				if elapsed[index] > time.Second {
+					t.Errorf("expected the caller back on its deadline, it took %s", elapsed[index])
+				}
+			}
+		})
+	}
+}
+
+func TestRefreshCapabilities(t *testing.T) {
+
+	device := startEmulator(t, emulator.Options{})
+	wizClient := createClient(t, device, WizClientOptions{})
+
+	for i := 0; i < 2; i++ {
+		if _, err := wizClient.RefreshCapabilitiesContext(context.Background()); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+
+	if fetches := len(receivedMessages(device, "getSystemConfig")); fetches != 2 {
+		t.Errorf("expected the capabilities fetched on every refresh, got %d fetches", fetches)
+	}
+}
@@ -0,0 +1,416 @@
+package wizgo
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image/color"
+	"math"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/colornames"
+
+	wizgotypes "github.com/achetronic/wizgo/api/types"
+)
+
+var (
+	// cssLevel4Colors holds the CSS color names missing in the SVG 1.1 list of colornames
+	cssLevel4Colors = map[string]color.RGBA{
+		"rebeccapurple": {R: 0x66, G: 0x33, B: 0x99, A: 0xff},
+	}
+)
+
+const (
+	// minApproximatedKelvin and maxApproximatedKelvin bound the temperatures whose RGB appearance is approximated
+	minApproximatedKelvin = 1000
+	maxApproximatedKelvin = 40000
+
+	// Error messages
+	InvalidColorErrorMessage = "invalid color '%s': use a hex code, a CSS color name or a temperature like '2700K'"
+)
+
+// HSV represents a color by its hue (0-360), saturation (0-1) and value (0-1). It implements color.Color
+type HSV struct {
+	H, S, V float64
+}
+
+// RGBA implements color.Color
+func (c HSV) RGBA() (r, g, b, a uint32) {
+	red, green, blue := hsvToRgb(c.H, c.S, c.V)
+	return color.RGBA{R: uint8(red), G: uint8(green), B: uint8(blue), A: 0xff}.RGBA()
+}
+
+// HSL represents a color by its hue (0-360), saturation (0-1) and lightness (0-1). It implements color.Color
+type HSL struct {
+	H, S, L float64
+}
+
+// RGBA implements color.Color
+func (c HSL) RGBA() (r, g, b, a uint32) {
+
+	// HSL is converted into HSV, which shares the hue
+	value := c.L + c.S*math.Min(c.L, 1-c.L)
+	saturation := 0.0
+	if value > 0 {
+		saturation = 2 * (1 - c.L/value)
+	}
+
+	return HSV{H: c.H, S: saturation, V: value}.RGBA()
+}
+
+// XY represents a color by its CIE 1931 chromaticity coordinates (0-1) and its brightness (0-1),
+// as used by Zigbee and Home Assistant. It implements color.Color
+type XY struct {
+	X, Y       float64
+	Brightness float64
+}
+
+// RGBA implements color.Color
+func (c XY) RGBA() (r, g, b, a uint32) {
+
+	if c.Y <= 0 {
+		return color.Black.RGBA()
+	}
+
+	// The brightest color with that chromaticity is scaled down by the brightness
+	x, y, z := c.X/c.Y, 1.0, (1-c.X-c.Y)/c.Y
+
+	lr := 3.2406*x - 1.5372*y - 0.4986*z
+	lg := -0.9689*x + 1.8758*y + 0.0415*z
+	lb := 0.0557*x - 0.2040*y + 1.0570*z
+
+	lr, lg, lb = math.Max(lr, 0), math.Max(lg, 0), math.Max(lb, 0)
+	if max := math.Max(lr, math.Max(lg, lb)); max > 0 {
+		lr, lg, lb = lr/max, lg/max, lb/max
+	}
+
+	brightness := math.Max(0, math.Min(1, c.Brightness))
+	return color.RGBA{
+		R: uint8(float64(linearToSrgb(lr)) * brightness),
+		G: uint8(float64(linearToSrgb(lg)) * brightness),
+		B: uint8(float64(linearToSrgb(lb)) * brightness),
+		A: 0xff,
+	}.RGBA()
+}
+
+// Kelvin represents a white by its color temperature. It implements color.Color with an approximation of its RGB
+// appearance, while devices able to produce white temperatures get the temperature itself
+type Kelvin int
+
+// RGBA implements color.Color. Thanks to Tanner Helland for the approximation,
+// which holds between 1000K and 40000K, so temperatures out of that range are clamped to it
+func (k Kelvin) RGBA() (r, g, b, a uint32) {
+
+	temperature := math.Max(minApproximatedKelvin, math.Min(maxApproximatedKelvin, float64(k))) / 100
+
+	red, green, blue := 255.0, 255.0, 255.0
+	if temperature <= 66 {
+		green = 99.4708025861*math.Log(temperature) - 161.1195681661
+		blue = 0
+		if temperature > 19 {
+			blue = 138.5177312231*math.Log(temperature-10) - 305.0447927307
+		}
+	} else {
+		red = 329.698727446 * math.Pow(temperature-60, -0.1332047592)
+		green = 288.1221695283 * math.Pow(temperature-60, -0.0755148492)
+	}
+
+	return color.RGBA{R: uint8(clampChannel(red)), G: uint8(clampChannel(green)), B: uint8(clampChannel(blue)), A: 0xff}.RGBA()
+}
+
+// ParseHexColor return the color given as a hex code: '#rgb' or '#rrggbb', with or without '#'
+func ParseHexColor(code string) (rgba color.RGBA, err error) {
+
+	digits := strings.TrimPrefix(strings.TrimSpace(code), "#")
+	if len(digits) == 3 {
+		digits = string([]byte{digits[0], digits[0], digits[1], digits[1], digits[2], digits[2]})
+	}
+
+	channels, err := hex.DecodeString(digits)
+	if err != nil || len(channels) != 3 {
+		return rgba, errors.New(fmt.Sprintf(InvalidColorErrorMessage, code))
+	}
+
+	return color.RGBA{R: channels[0], G: channels[1], B: channels[2], A: 0xff}, nil
+}
+
+// CssColor return the color with the given CSS name, ignoring the case. I.E: 'RebeccaPurple'
+func CssColor(name string) (rgba color.RGBA, found bool) {
+
+	name = strings.ToLower(strings.ReplaceAll(name, " ", ""))
+
+	if rgba, found = colornames.Map[name]; found {
+		return rgba, found
+	}
+
+	rgba, found = cssLevel4Colors[name]
+	return rgba, found
+}
+
+// ParseColor return the color given as a hex code ('#ff8800'), a CSS name ('orange')
+// or a white temperature ('2700K')
+func ParseColor(text string) (c color.Color, err error) {
+
+	text = strings.TrimSpace(text)
+
+	if digits, found := strings.CutSuffix(strings.ToUpper(text), "K"); found {
+		if temperature, err := strconv.Atoi(digits); err == nil {
+			if temperature <= 0 {
+				return c, errors.New(fmt.Sprintf(InvalidColorErrorMessage, text))
+			}
+			return Kelvin(temperature), nil
+		}
+	}
+
+	if rgba, found := CssColor(text); found {
+		return rgba, nil
+	}
+
+	return ParseHexColor(text)
+}
+
+// ToHSV converts any color into HSV. Transparency is ignored
+func ToHSV(c color.Color) HSV {
+
+	rgba := color.NRGBAModel.Convert(c).(color.NRGBA)
+	r, g, b := float64(rgba.R)/255, float64(rgba.G)/255, float64(rgba.B)/255
+
+	max := math.Max(r, math.Max(g, b))
+	min := math.Min(r, math.Min(g, b))
+	delta := max - min
+
+	hsv := HSV{V: max}
+	if max > 0 {
+		hsv.S = delta / max
+	}
+
+	switch {
+	case delta == 0:
+		hsv.H = 0
+	case max == r:
+		hsv.H = 60 * math.Mod((g-b)/delta, 6)
+	case max == g:
+		hsv.H = 60 * ((b-r)/delta + 2)
+	default:
+		hsv.H = 60 * ((r-g)/delta + 4)
+	}
+
+	if hsv.H < 0 {
+		hsv.H += 360
+	}
+	return hsv
+}
+
+// ToXY converts any color into its CIE 1931 chromaticity coordinates, with its brightness as the HSV value.
+// Transparency is ignored
+func ToXY(c color.Color) XY {
+
+	rgba := color.NRGBAModel.Convert(c).(color.NRGBA)
+	lr, lg, lb := srgbToLinear(int(rgba.R)), srgbToLinear(int(rgba.G)), srgbToLinear(int(rgba.B))
+
+	x := 0.4124*lr + 0.3576*lg + 0.1805*lb
+	y := 0.2126*lr + 0.7152*lg + 0.0722*lb
+	z := 0.0193*lr + 0.1192*lg + 0.9505*lb
+
+	// Black has no chromaticity, so the white point (D65) is given
+	if x+y+z == 0 {
+		return XY{X: 0.3127, Y: 0.3290}
+	}
+
+	return XY{X: x / (x + y + z), Y: y / (x + y + z), Brightness: ToHSV(c).V}
+}
+
+// CorrelatedTemperature return the white temperature (kelvin) closest to the given color.
+// Thanks to McCamy for the approximation
+func CorrelatedTemperature(c color.Color) int {
+
+	if kelvin, ok := c.(Kelvin); ok {
+		return int(kelvin)
+	}
+
+	xy := ToXY(c)
+	n := (xy.X - 0.3320) / (0.1858 - xy.Y)
+	return int(math.Round(449*n*n*n + 3525*n*n + 6823.3*n + 5520.33))
+}
+
+// CreatePilotBuilderFromColor creates a builder not bound to any client with the best mix of channels
+// to produce the given color on a device with the given capabilities:
+//   - RGB devices get the hue at full saturation on the RGB LEDs, scaled by the saturation, while the pale
+//     part of the color goes to the cold white LEDs, as the firmware does not mix them on its own.
+//     A Kelvin sets the white temperature instead
+//   - TW devices get the white temperature closest to the color, in their range
+//   - DW devices only get the brightness
+//
+// The brightness comes from the HSV value of the color, and black turns the device off
+func CreatePilotBuilderFromColor(c color.Color, capabilities Capabilities) *PilotBuilder {
+
+	builder := CreatePilotBuilder()
+
+	if kelvin, ok := c.(Kelvin); ok && capabilities.ColorTemperature {
+		return builder.Temperature(clampTemperature(int(kelvin), capabilities))
+	}
+
+	hsv := ToHSV(c)
+	if hsv.V == 0 {
+		return builder.State(false)
+	}
+
+	brightness := int(math.Round(math.Max(MinBrightness, hsv.V*MaxBrightness)))
+
+	switch {
+	case capabilities.Color:
+		r, g, b := hsvToRgb(hsv.H, 1, 1)
+		builder.Rgb(clampChannel(float64(r)*hsv.S), clampChannel(float64(g)*hsv.S), clampChannel(float64(b)*hsv.S))
+		builder.ColdWhite(clampChannel((1 - hsv.S) * MaxLed))
+		builder.Brightness(brightness)
+
+	case capabilities.ColorTemperature:
+		builder.Temperature(clampTemperature(CorrelatedTemperature(c), capabilities))
+		builder.Brightness(brightness)
+
+	case capabilities.Brightness:
+		builder.Brightness(brightness)
+
+	default:
+		builder.State(true)
+	}
+
+	return builder
+}
+
+// SetColor sets the given color with the best mix of channels for the capabilities of the device.
+// See CreatePilotBuilderFromColor
+func (w *WizClient) SetColor(c color.Color) (response wizgotypes.WizMessageResponse, err error) {
+	return w.SetColorContext(context.Background(), c)
+}
+
+// SetColorContext is like SetColor but honours the deadline and cancellation of the given context
+func (w *WizClient) SetColorContext(ctx context.Context, c color.Color) (response wizgotypes.WizMessageResponse, err error) {
+
+	capabilities, err := w.CapabilitiesContext(ctx)
+	if err != nil {
+		return response, err
+	}
+
+	return w.SetPilotContext(ctx, CreatePilotBuilderFromColor(c, capabilities))
+}
+
+// clampTemperature keeps the temperature inside the range advertised by the device, or the default one
+func clampTemperature(temperature int, capabilities Capabilities) int {
+
+	min, max := MinTemperature, MaxTemperature
+	if capabilities.KelvinMax != 0 {
+		min, max = capabilities.KelvinMin, capabilities.KelvinMax
+	}
+
+	if temperature < min {
+		return min
+	}
+	if temperature > max {
+		return max
+	}
+	return temperature
+}
+
+// hsvToRgb converts a color from HSV into sRGB (3 x 0-255)
+func hsvToRgb(hue, saturation, value float64) (r, g, b int) {
+
+	hue = math.Mod(hue, 360)
+	if hue < 0 {
+		hue += 360
+	}
+
+	chroma := value * saturation
+	x := chroma * (1 - math.Abs(math.Mod(hue/60, 2)-1))
+	m := value - chroma
+
+	var red, green, blue float64
+	switch {
+	case hue < 60:
+		red, green, blue = chroma, x, 0
+	case hue < 120:
+		red, green, blue = x, chroma, 0
+	case hue < 180:
+		red, green, blue = 0, chroma, x
+	case hue < 240:
+		red, green, blue = 0, x, chroma
+	case hue < 300:
+		red, green, blue = x, 0, chroma
+	default:
+		red, green, blue = chroma, 0, x
+	}
+
+	return clampChannel((red + m) * 255), clampChannel((green + m) * 255), clampChannel((blue + m) * 255)
+}
+
+// oklab represents a color in the OKLab space, where distances match the perceived differences.
+// Ref: https://bottosson.github.io/posts/oklab/
+type oklab struct {
+	l, a, b float64
+}
+
+// rgbToOklab converts a sRGB color (3 x 0-255) into the OKLab space
+func rgbToOklab(r, g, b int) oklab {
+
+	lr, lg, lb := srgbToLinear(r), srgbToLinear(g), srgbToLinear(b)
+
+	l := math.Cbrt(0.4122214708*lr + 0.5363325363*lg + 0.0514459929*lb)
+	m := math.Cbrt(0.2119034982*lr + 0.6806995451*lg + 0.1073969566*lb)
+	s := math.Cbrt(0.0883024619*lr + 0.2817188376*lg + 0.6299787005*lb)
+
+	return oklab{
+		l: 0.2104542553*l + 0.7936177850*m - 0.0040720468*s,
+		a: 1.9779984951*l - 2.4285922050*m + 0.4505937099*s,
+		b: 0.0259040371*l + 0.7827717662*m - 0.8086757660*s,
+	}
+}
+
+// rgb converts the color back into sRGB (3 x 0-255), clamping the channels out of range
+func (c oklab) rgb() (r, g, b int) {
+
+	l := c.l + 0.3963377774*c.a + 0.2158037573*c.b
+	m := c.l - 0.1055613458*c.a - 0.0638541728*c.b
+	s := c.l - 0.0894841775*c.a - 1.2914855480*c.b
+
+	l, m, s = l*l*l, m*m*m, s*s*s
+
+	r = linearToSrgb(4.0767416621*l - 3.3077115913*m + 0.2309699292*s)
+	g = linearToSrgb(-1.2684380046*l + 2.6097574011*m - 0.3413193965*s)
+	b = linearToSrgb(-0.0041960863*l - 0.7034186147*m + 1.7076147010*s)
+	return r, g, b
+}
+
+// mix return the color at the given progress (0-1) between this color and the other
+func (c oklab) mix(other oklab, progress float64) oklab {
+	return oklab{
+		l: c.l + (other.l-c.l)*progress,
+		a: c.a + (other.a-c.a)*progress,
+		b: c.b + (other.b-c.b)*progress,
+	}
+}
+
+// srgbToLinear removes the gamma of a sRGB channel (0-255), returning its linear intensity (0-1)
+func srgbToLinear(channel int) float64 {
+	value := float64(channel) / 255
+	if value <= 0.04045 {
+		return value / 12.92
+	}
+	return math.Pow((value+0.055)/1.055, 2.4)
+}
+
+// linearToSrgb applies the gamma of sRGB to a linear intensity (0-1), returning the channel (0-255)
+func linearToSrgb(value float64) int {
+	if value <= 0.0031308 {
+		value = value * 12.92
+	} else {
+		value = 1.055*math.Pow(value, 1/2.4) - 0.055
+	}
+	return clampChannel(value * 255)
+}
+
+// clampChannel rounds the value and keeps it inside the range of a LED channel (0-255)
+func clampChannel(value float64) int {
+	return int(math.Round(math.Max(MinLed, math.Min(MaxLed, value))))
+}
@@ -0,0 +1,205 @@
+package wizgo
+
+import (
+	"image/color"
+	"math"
+	"reflect"
+	"testing"
+
+	wizgotypes "github.com/achetronic/wizgo/api/types"
+	"github.com/achetronic/wizgo/pkg/emulator"
+)
+
+func TestToHSV(t *testing.T) {
+
+	tests := []struct {
+		name  string
+		color color.Color
+		want  HSV
+	}{
+		{name: "red", color: color.RGBA{R: 255, A: 255}, want: HSV{H: 0, S: 1, V: 1}},
+		{name: "green", color: color.RGBA{G: 255, A: 255}, want: HSV{H: 120, S: 1, V: 1}},
+		{name: "blue", color: color.RGBA{B: 255, A: 255}, want: HSV{H: 240, S: 1, V: 1}},
+		{name: "magenta", color: color.RGBA{R: 255, B: 255, A: 255}, want: HSV{H: 300, S: 1, V: 1}},
+		{name: "gray", color: color.RGBA{R: 128, G: 128, B: 128, A: 255}, want: HSV{H: 0, S: 0, V: 128.0 / 255}},
+		{name: "black", color: color.Black, want: HSV{}},
+		{name: "HSV back", color: HSV{H: 200, S: 0.6, V: 0.8}, want: HSV{H: 200, S: 0.6, V: 0.8}},
+		{name: "HSL", color: HSL{H: 200, S: 1, L: 0.5}, want: HSV{H: 200, S: 1, V: 1}},
+		{name: "XY of the white point", color: XY{X: 0.3127, Y: 0.3290, Brightness: 1}, want: HSV{H: 0, S: 0, V: 1}},
+		{name: "XY of the red primary", color: XY{X: 0.64, Y: 0.33, Brightness: 0.5}, want: HSV{H: 0, S: 1, V: 0.5}},
+	}
+
+	// Colors go through 8-bit channels, so they are compared at that precision
+	const tolerance = 1.5 / 255
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			hsv := ToHSV(test.color)
+			if math.Abs(hsv.H-test.want.H) > 360*tolerance || math.Abs(hsv.S-test.want.S) > tolerance || math.Abs(hsv.V-test.want.V) > tolerance {
+				t.Errorf("expected %+v, got %+v", test.want, hsv)
+			}
+		})
+	}
+}
+
+func TestToXY(t *testing.T) {
+
+	tests := []struct {
+		name  string
+		color color.Color
+		want  XY
+	}{
+		{name: "white", color: color.White, want: XY{X: 0.3127, Y: 0.3290, Brightness: 1}},
+		{name: "red", color: color.RGBA{R: 255, A: 255}, want: XY{X: 0.64, Y: 0.33, Brightness: 1}},
+		{name: "green", color: color.RGBA{G: 255, A: 255}, want: XY{X: 0.30, Y: 0.60, Brightness: 1}},
+		{name: "blue", color: color.RGBA{B: 255, A: 255}, want: XY{X: 0.15, Y: 0.06, Brightness: 1}},
+		{name: "black", color: color.Black, want: XY{X: 0.3127, Y: 0.3290}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			xy := ToXY(test.color)
+			if math.Abs(xy.X-test.want.X) > 0.001 || math.Abs(xy.Y-test.want.Y) > 0.001 || math.Abs(xy.Brightness-test.want.Brightness) > 0.01 {
+				t.Errorf("expected %+v, got %+v", test.want, xy)
+			}
+		})
+	}
+}
+
+func TestCorrelatedTemperature(t *testing.T) {
+
+	tests := []struct {
+		name  string
+		color color.Color
+		want  int
+	}{
+		{name: "kelvin given as is", color: Kelvin(2700), want: 2700},
+		{name: "warm white", color: color.RGBAModel.Convert(Kelvin(2700)), want: 2700},
+		{name: "neutral white", color: color.RGBAModel.Convert(Kelvin(4000)), want: 4000},
+		{name: "daylight", color: color.RGBAModel.Convert(Kelvin(6500)), want: 6500},
+		{name: "white", color: color.White, want: 6500},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			// The RGB appearance of a temperature is an approximation, so the way back is close but not exact
+			temperature := CorrelatedTemperature(test.color)
+			if math.Abs(float64(temperature-test.want)) > 0.05*float64(test.want) {
+				t.Errorf("expected around %dK, got %dK", test.want, temperature)
+			}
+		})
+	}
+}
+
+func TestParseColor(t *testing.T) {
+
+	tests := []struct {
+		text    string
+		want    color.Color
+		wantErr bool
+	}{
+		{text: "#ff8800", want: color.RGBA{R: 0xff, G: 0x88, A: 0xff}},
+		{text: "f80", want: color.RGBA{R: 0xff, G: 0x88, A: 0xff}},
+		{text: "Orange", want: color.RGBA{R: 0xff, G: 0xa5, A: 0xff}},
+		{text: "rebecca purple", want: color.RGBA{R: 0x66, G: 0x33, B: 0x99, A: 0xff}},
+		{text: "2700K", want: Kelvin(2700)},
+		{text: " 6500k ", want: Kelvin(6500)},
+		{text: "0K", wantErr: true},
+		{text: "#12345", wantErr: true},
+		{text: "lightish", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.text, func(t *testing.T) {
+			parsed, err := ParseColor(test.text)
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("expected an error, got %v", parsed)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if parsed != test.want {
+				t.Errorf("expected %v, got %v", test.want, parsed)
+			}
+		})
+	}
+}
+
+func TestSetColor(t *testing.T) {
+
+	tests := []struct {
+		name  string
+		model emulator.Model
+		color color.Color
+
+		want wizgotypes.WizMessageParams // want holds the params of the 'setPilot' received by the device
+	}{
+		{
+			name: "saturated color on a RGB device", model: emulator.ModelRgb, color: HSV{H: 120, S: 1, V: 0.5},
+			want: wizgotypes.WizMessageParams{"r": 0.0, "g": 255.0, "b": 0.0, "c": 0.0, "dimming": 50.0},
+		},
+		{
+			name: "pale color on a RGB device", model: emulator.ModelRgb, color: HSV{H: 0, S: 0.2, V: 1},
+			want: wizgotypes.WizMessageParams{"r": 51.0, "g": 0.0, "b": 0.0, "c": 204.0, "dimming": 100.0},
+		},
+		{
+			name: "temperature on a RGB device", model: emulator.ModelRgb, color: Kelvin(2700),
+			want: wizgotypes.WizMessageParams{"temp": 2700.0},
+		},
+		{
+			name: "temperature out of the range of a RGB device", model: emulator.ModelRgb, color: Kelvin(9000),
+			want: wizgotypes.WizMessageParams{"temp": 6500.0},
+		},
+		{
+			name: "black on a RGB device", model: emulator.ModelRgb, color: color.Black,
+			want: wizgotypes.WizMessageParams{"state": false},
+		},
+		{
+			name: "color on a TW device", model: emulator.ModelTw, color: color.RGBA{R: 255, G: 165, A: 255},
+			want: wizgotypes.WizMessageParams{"temp": 2700.0, "dimming": 100.0},
+		},
+		{
+			name: "temperature on a TW device", model: emulator.ModelTw, color: Kelvin(4000),
+			want: wizgotypes.WizMessageParams{"temp": 4000.0},
+		},
+		{
+			name: "color on a DW device", model: emulator.ModelDw, color: HSV{H: 0, S: 1, V: 0.4},
+			want: wizgotypes.WizMessageParams{"dimming": 40.0},
+		},
+		{
+			name: "temperature on a DW device", model: emulator.ModelDw, color: Kelvin(2700),
+			want: wizgotypes.WizMessageParams{"dimming": 100.0},
+		},
+		{
+			name: "color on a socket", model: emulator.ModelSocket, color: color.RGBA{R: 255, A: 255},
+			want: wizgotypes.WizMessageParams{"state": true},
+		},
+		{
+			name: "temperature out of the default range on an unknown device", model: unknownModel, color: Kelvin(12000),
+			want: wizgotypes.WizMessageParams{"temp": float64(MaxTemperature)},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			device := startEmulator(t, emulator.Options{Model: test.model})
+			wizClient := createClient(t, device, WizClientOptions{})
+
+			if _, err := wizClient.SetColor(test.color); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			messages := receivedMessages(device, "setPilot")
+			if len(messages) != 1 {
+				t.Fatalf("expected a single 'setPilot', got %d", len(messages))
+			}
+			if !reflect.DeepEqual(messages[0].Params, test.want) {
+				t.Errorf("expected %v, got %v", test.want, messages[0].Params)
+			}
+		})
+	}
+}
@@ -0,0 +1,360 @@
+package wizgo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	wizgotypes "github.com/achetronic/wizgo/api/types"
+)
+
+const (
+	// DefaultBroadcastAddress is the address used to discover devices when no other is given
+	DefaultBroadcastAddress = "255.255.255.255"
+
+	// DefaultDiscoveryWindow is how long the answers to the discovery messages are collected
+	DefaultDiscoveryWindow = 2 * time.Second
+
+	// DefaultDiscoveryInterval is how often the discovery messages are broadcast during the window
+	DefaultDiscoveryInterval = 500 * time.Millisecond
+
+	// Error messages
+	DiscoveryErrorMessage          = "error discovering devices: %s"
+	InterfaceBroadcastErrorMessage = "error getting broadcast address of interface '%s': %s"
+)
+
+// DiscoverOptions represents the settings used to look for devices on the network
+type DiscoverOptions struct {
+	BroadcastAddresses []string      // BroadcastAddresses receive the discovery messages. Default: DefaultBroadcastAddress
+	Interfaces         []string      // Interfaces whose IPv4 broadcast addresses are added to BroadcastAddresses
+	Port               int           // Port where devices listen for commands. Default: DefaultPort
+	Window             time.Duration // Window is how long the answers are collected. Default: DefaultDiscoveryWindow
+	Interval           time.Duration // Interval between broadcasts during the window. Default: DefaultDiscoveryInterval
+}
+
+// DiscoveredDevice represents a device that answered to the discovery messages
+type DiscoveredDevice struct {
+	Ip         string `json:"ip"`
+	Port       int    `json:"port"`
+	Mac        string `json:"mac"`
+	ModuleName string `json:"moduleName,omitempty"`
+	FwVersion  string `json:"fwVersion,omitempty"`
+	HomeId     int    `json:"homeId,omitempty"`  // HomeId as configured in the WiZ app
+	RoomId     int    `json:"roomId,omitempty"`  // RoomId as configured in the WiZ app
+	GroupId    int    `json:"groupId,omitempty"` // GroupId as configured in the WiZ app
+}
+
+// Address return the address of the device in host:port form
+func (d DiscoveredDevice) Address() string {
+	return net.JoinHostPort(d.Ip, strconv.Itoa(d.Port))
+}
+
+// Capabilities return what the device supports, as far as its module name tells
+func (d DiscoveredDevice) Capabilities() Capabilities {
+	return ParseModuleName(d.ModuleName)
+}
+
+// Client creates a client to interact with the discovered device using default options
+func (d DiscoveredDevice) Client() (*WizClient, error) {
+	return d.ClientWithOptions(WizClientOptions{})
+}
+
+// ClientWithOptions creates a client to interact with the discovered device
+func (d DiscoveredDevice) ClientWithOptions(options WizClientOptions) (*WizClient, error) {
+
+	wizClient, err := CreateWizClientWithOptions(d.Ip, d.Port, options)
+	if err != nil {
+		return nil, err
+	}
+	return &wizClient, nil
+}
+
+// Discover broadcasts 'registration' and 'getSystemConfig' messages, collects the answers during the window
+// and return the devices found, de-duplicated by MAC. The window ends earlier when the context is done
+func Discover(ctx context.Context, options DiscoverOptions) (devices []DiscoveredDevice, err error) {
+
+	if options.Port <= 0 {
+		options.Port = DefaultPort
+	}
+
+	if options.Window <= 0 {
+		options.Window = DefaultDiscoveryWindow
+	}
+
+	if options.Interval <= 0 {
+		options.Interval = DefaultDiscoveryInterval
+	}
+
+	targets, err := discoveryTargets(options)
+	if err != nil {
+		return devices, errors.New(fmt.Sprintf(DiscoveryErrorMessage, err))
+	}
+
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		return devices, errors.New(fmt.Sprintf(DiscoveryErrorMessage, err))
+	}
+	defer conn.Close()
+
+	windowCtx, cancel := context.WithTimeout(ctx, options.Window)
+	defer cancel()
+
+	// Unblock the pending read when the window ends
+	go func() {
+		<-windowCtx.Done()
+		_ = conn.SetReadDeadline(time.Now())
+	}()
+
+	// Broadcast periodically, as any of the datagrams may be lost
+	sendErr := make(chan error, 1)
+	go func() {
+		ticker := time.NewTicker(options.Interval)
+		defer ticker.Stop()
+
+		for first := true; ; first = false {
+			err := broadcastDiscovery(conn, targets)
+			if first {
+				sendErr <- err
+			}
+
+			select {
+			case <-ticker.C:
+			case <-windowCtx.Done():
+				return
+			}
+		}
+	}()
+
+	if err = <-sendErr; err != nil {
+		return devices, errors.New(fmt.Sprintf(DiscoveryErrorMessage, err))
+	}
+
+	found := map[string]*DiscoveredDevice{}
+	buffer := make([]byte, 4096)
+
+	for {
+		n, remote, err := conn.ReadFromUDP(buffer)
+		if err != nil {
+			if windowCtx.Err() != nil {
+				break
+			}
+			continue
+		}
+
+		var response wizgotypes.WizMessageResponse
+		if json.Unmarshal(buffer[:n], &response) != nil || response.Result.Mac == "" {
+			continue
+		}
+
+		mac := NormalizeMac(response.Result.Mac)
+		device, ok := found[mac]
+		if !ok {
+			device = &DiscoveredDevice{Mac: mac, Port: options.Port}
+			found[mac] = device
+		}
+
+		device.Ip = remote.IP.String()
+		if response.Method == "getSystemConfig" {
+			device.fillSystemConfig(response.Result)
+		}
+	}
+
+	// A cancellation is not the end of the window, so the caller must know
+	if ctx.Err() != nil && !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return devices, ctx.Err()
+	}
+
+	completeCtx, cancelComplete := completionContext(ctx)
+	defer cancelComplete()
+
+	completeDiscoveredDevices(completeCtx, found)
+
+	for _, device := range found {
+		devices = append(devices, *device)
+	}
+
+	sort.Slice(devices, func(i, j int) bool {
+		return devices[i].Mac < devices[j].Mac
+	})
+
+	return devices, nil
+}
+
+// fillSystemConfig copies the fields answered on 'getSystemConfig' into the device
+func (d *DiscoveredDevice) fillSystemConfig(result wizgotypes.WizMessageResult) {
+	d.ModuleName = result.ModuleName
+	d.FwVersion = result.FwVersion
+	d.HomeId = result.HomeId
+	d.RoomId = result.RoomId
+	d.GroupId = result.GroupId
+}
+
+// NormalizeMac return the given MAC address in lower case without separators, as devices report it
+func NormalizeMac(mac string) string {
+	return strings.ToLower(strings.NewReplacer(":", "", "-", "", ".", "").Replace(mac))
+}
+
+// discoveryTargets return the addresses that will receive the discovery messages
+func discoveryTargets(options DiscoverOptions) (targets []*net.UDPAddr, err error) {
+
+	hosts := append([]string{}, options.BroadcastAddresses...)
+
+	for _, interfaceName := range options.Interfaces {
+		broadcastAddresses, err := interfaceBroadcastAddresses(interfaceName)
+		if err != nil {
+			return targets, errors.New(fmt.Sprintf(InterfaceBroadcastErrorMessage, interfaceName, err))
+		}
+		hosts = append(hosts, broadcastAddresses...)
+	}
+
+	if len(hosts) == 0 {
+		hosts = append(hosts, DefaultBroadcastAddress)
+	}
+
+	for _, host := range hosts {
+		address, err := net.ResolveUDPAddr("udp4", net.JoinHostPort(host, strconv.Itoa(options.Port)))
+		if err != nil {
+			return targets, err
+		}
+		targets = append(targets, address)
+	}
+
+	return targets, nil
+}
+
+// interfaceBroadcastAddresses return the IPv4 broadcast addresses of the networks attached to the interface
+func interfaceBroadcastAddresses(interfaceName string) (broadcastAddresses []string, err error) {
+
+	netInterface, err := net.InterfaceByName(interfaceName)
+	if err != nil {
+		return broadcastAddresses, err
+	}
+
+	addresses, err := netInterface.Addrs()
+	if err != nil {
+		return broadcastAddresses, err
+	}
+
+	for _, address := range addresses {
+		ipNet, ok := address.(*net.IPNet)
+		if !ok || ipNet.IP.To4() == nil {
+			continue
+		}
+
+		ip := ipNet.IP.To4()
+		mask := net.IP(ipNet.Mask).To4()
+		if mask == nil {
+			continue
+		}
+
+		broadcast := make(net.IP, net.IPv4len)
+		for i := range ip {
+			broadcast[i] = ip[i] | ^mask[i]
+		}
+		broadcastAddresses = append(broadcastAddresses, broadcast.String())
+	}
+
+	if len(broadcastAddresses) == 0 {
+		return broadcastAddresses, errors.New("no IPv4 address found")
+	}
+
+	return broadcastAddresses, nil
+}
+
+// broadcastDiscovery sends the discovery messages to all the targets.
+// It only fails when none of the targets could be reached
+func broadcastDiscovery(conn *net.UDPConn, targets []*net.UDPAddr) (err error) {
+
+	messages := []wizgotypes.WizMessage{
+		{
+			Method: "registration",
+			Params: wizgotypes.WizMessageParams{
+				"phoneIp":  "1.2.3.4",
+				"phoneMac": "AAAAAAAAAAAA",
+				"register": false,
+			},
+		},
+		{
+			Method: "getSystemConfig",
+		},
+	}
+
+	reached := 0
+	for _, message := range messages {
+		content, err := json.Marshal(message)
+		if err != nil {
+			return err
+		}
+
+		for _, target := range targets {
+			if _, err = conn.WriteToUDP(content, target); err != nil {
+				continue
+			}
+			reached++
+		}
+	}
+
+	if reached == 0 {
+		return errors.New(fmt.Sprintf(ErrorSendingDataErrorMessage, "no broadcast address reachable"))
+	}
+
+	return nil
+}
+
+// completionContext return the context used to complete the devices once the window is over. Windows are often
+// ended by the deadline of the caller, so it gets its own timeout and only follows the caller's cancellation
+func completionContext(ctx context.Context) (completeCtx context.Context, cancel context.CancelFunc) {
+
+	completeCtx, cancel = context.WithTimeout(context.Background(), DefaultTimeout)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				cancel()
+			}
+		case <-completeCtx.Done():
+		}
+	}()
+
+	return completeCtx, cancel
+}
+
+// completeDiscoveredDevices asks for the system config to the devices that only answered the registration
+func completeDiscoveredDevices(ctx context.Context, found map[string]*DiscoveredDevice) {
+
+	var wg sync.WaitGroup
+
+	for _, device := range found {
+		if device.ModuleName != "" {
+			continue
+		}
+
+		wg.Add(1)
+		go func(device *DiscoveredDevice) {
+			defer wg.Done()
+
+			wizClient, err := device.Client()
+			if err != nil {
+				return
+			}
+			defer wizClient.Close()
+
+			configResp, err := wizClient.GetSystemConfigContext(ctx)
+			if err != nil {
+				return
+			}
+
+			device.fillSystemConfig(configResp.Result)
+		}(device)
+	}
+
+	wg.Wait()
+}
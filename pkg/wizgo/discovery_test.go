@@ -0,0 +1,112 @@
+package wizgo
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/achetronic/wizgo/pkg/emulator"
+)
+
+func TestDiscover(t *testing.T) {
+
+	// The discovery is broadcast once, at the start, and the window is ended after this
+	const windowEnd = 300 * time.Millisecond
+
+	tests := []struct {
+		name         string
+		window       time.Duration // window of the discovery, the deadline of the caller ends it when longer
+		cancel       bool          // cancel is set when the caller cancels the discovery instead of letting it end
+		onlyRegister bool          // onlyRegister is set when the device answers nothing but the registration during the window
+
+		wantErr           error
+		wantSystemConfigs int // wantSystemConfigs is how many 'getSystemConfig' the device receives
+	}{
+		{
+			name:              "answered during the window",
+			window:            windowEnd,
+			wantSystemConfigs: 1,
+		},
+		{
+			name:              "completed after the window",
+			window:            windowEnd,
+			onlyRegister:      true,
+			wantSystemConfigs: 2,
+		},
+		{
+			name:              "completed after the deadline of the caller",
+			window:            time.Minute,
+			onlyRegister:      true,
+			wantSystemConfigs: 2,
+		},
+		{
+			name:         "cancelled",
+			window:       time.Minute,
+			cancel:       true,
+			onlyRegister: true,
+			wantErr:      context.Canceled,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			device := startEmulator(t, emulator.Options{Model: emulator.ModelTw, HomeId: 7, RoomId: 3})
+
+			// The device starts answering the system config once the discovery is broadcast, but before the window ends
+			if test.onlyRegister {
+				device.InjectError("getSystemConfig", emulator.MethodNotFoundCode)
+				clearErrors := time.AfterFunc(windowEnd-100*time.Millisecond, device.ClearErrors)
+				defer clearErrors.Stop()
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if test.window > windowEnd {
+				ctx, cancel = context.WithTimeout(ctx, windowEnd)
+				defer cancel()
+			}
+			if test.cancel {
+				cancelDiscovery := time.AfterFunc(windowEnd-50*time.Millisecond, cancel)
+				defer cancelDiscovery.Stop()
+			}
+
+			started := time.Now()
+			devices, err := Discover(ctx, DiscoverOptions{
+				BroadcastAddresses: []string{device.Host()},
+				Port:               device.Port(),
+				Window:             test.window,
+				Interval:           time.Hour,
+			})
+
+			if elapsed := time.Since(started); elapsed > windowEnd+time.Second {
+				t.Errorf("expected the discovery to end with its window, it took %s", elapsed)
+			}
+
+			if test.wantErr != nil {
+				if !errors.Is(err, test.wantErr) {
+					t.Errorf("expected an error matching %v, got: %v", test.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if len(devices) != 1 {
+				t.Fatalf("expected the device found, got %+v", devices)
+			}
+
+			if devices[0].ModuleName != emulator.ModelTw.ModuleName || devices[0].HomeId != 7 || devices[0].RoomId != 3 {
+				t.Errorf("expected the device completed with its system config, got %+v", devices[0])
+			}
+			if devices[0].Mac != NormalizeMac(device.Mac()) || devices[0].Address() != device.Address() {
+				t.Errorf("expected the device %s at %s, got %+v", device.Mac(), device.Address(), devices[0])
+			}
+
+			if messages := receivedMessages(device, "getSystemConfig"); len(messages) != test.wantSystemConfigs {
+				t.Errorf("expected %d 'getSystemConfig', got %d", test.wantSystemConfigs, len(messages))
+			}
+		})
+	}
+}
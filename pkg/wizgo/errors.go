@@ -0,0 +1,117 @@
+package wizgo
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrClientClosed is returned by the requests made through a closed WizClient
+	ErrClientClosed = errors.New(ClientClosedErrorMessage)
+
+	// ErrNotSupported is matched by the errors returned when asking a device for something it can not do
+	ErrNotSupported = errors.New("not supported")
+
+	// ErrNotFound is matched by the errors returned when a device or a room is not in the inventory
+	ErrNotFound = errors.New("not found")
+
+	// Sentinel errors for the common JSON-RPC error codes answered by the devices.
+	// A DeviceError matches them with errors.Is
+	ErrParseError     = errors.New("parse error")      // -32700
+	ErrInvalidRequest = errors.New("invalid request")  // -32600
+	ErrMethodNotFound = errors.New("method not found") // -32601
+	ErrInvalidParams  = errors.New("invalid params")   // -32602
+	ErrInternalError  = errors.New("internal error")   // -32603
+
+	deviceErrorCodes = map[int]error{
+		-32700: ErrParseError,
+		-32600: ErrInvalidRequest,
+		-32601: ErrMethodNotFound,
+		-32602: ErrInvalidParams,
+		-32603: ErrInternalError,
+	}
+)
+
+// DeviceError is returned when the device answers a message with an error
+type DeviceError struct {
+	Code    int    // Code of the error, following JSON-RPC codes
+	Message string // Message given by the device
+	Method  string // Method of the message that failed
+	Address string // Address of the device
+}
+
+func (e *DeviceError) Error() string {
+	return fmt.Sprintf(DeviceErrorMessage, e.Address, e.Method, e.Code, e.Message)
+}
+
+// Is allows matching the error against the sentinel errors of its code, such as ErrMethodNotFound
+func (e *DeviceError) Is(target error) bool {
+	sentinel, found := deviceErrorCodes[e.Code]
+	return found && sentinel == target
+}
+
+// RangeError is returned when a parameter is out of the range accepted by the device
+type RangeError struct {
+	Field string // Field is the name of the parameter as sent to the device: dimming, r, temp, etc.
+	Min   int    // Min is the lowest accepted value
+	Max   int    // Max is the highest accepted value
+	Got   int    // Got is the rejected value
+}
+
+func (e *RangeError) Error() string {
+	return fmt.Sprintf(RangeErrorMessage, e.Field, e.Min, e.Max, e.Got)
+}
+
+// notSupportedError return an error matching ErrNotSupported for the given feature
+func (w *WizClient) notSupportedError(feature string) error {
+	return &wrappedError{message: fmt.Sprintf(NotSupportedErrorMessage, feature, w.Address()), cause: ErrNotSupported}
+}
+
+// notFoundError return the error for a device or a room missing in the inventory
+func notFoundError(format string, selector string) error {
+	return &wrappedError{message: fmt.Sprintf(format, selector), cause: ErrNotFound}
+}
+
+// wrappedError keeps the message built from one of the error messages while exposing its cause to errors.Is/As
+type wrappedError struct {
+	message string
+	cause   error
+}
+
+// wrapError builds an error from a message with a single '%s' verb, keeping the cause inspectable
+func wrapError(format string, cause error) error {
+	return &wrappedError{message: fmt.Sprintf(format, cause), cause: cause}
+}
+
+func (e *wrappedError) Error() string {
+	return e.message
+}
+
+func (e *wrappedError) Unwrap() error {
+	return e.cause
+}
+
+// TimeoutError is returned when the device does not answer before the deadline of the request
+type TimeoutError struct {
+	Address string // Address of the device that did not answer
+	Method  string // Method of the message that was not answered
+	Err     error  // Err is the underlying cause: a network timeout or context.DeadlineExceeded
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf(RequestTimeoutErrorMessage, e.Method, e.Address)
+}
+
+// Timeout return always true. It allows treating TimeoutError as a net.Error
+func (e *TimeoutError) Timeout() bool {
+	return true
+}
+
+// Temporary return always true as the device may answer next time
+func (e *TimeoutError) Temporary() bool {
+	return true
+}
+
+func (e *TimeoutError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,103 @@
+package wizgo
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/achetronic/wizgo/pkg/emulator"
+)
+
+func TestDeviceErrors(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		model    emulator.Model
+		method   string
+		code     int // code injected on the method, zero to let the emulator answer on its own
+		request  func(ctx context.Context, wizClient *WizClient) error
+		sentinel error
+		wantCode int
+	}{
+		{
+			name:   "method not found",
+			method: "getPilot",
+			code:   emulator.MethodNotFoundCode,
+			request: func(ctx context.Context, wizClient *WizClient) error {
+				_, err := wizClient.GetPilotContext(ctx)
+				return err
+			},
+			sentinel: ErrMethodNotFound,
+			wantCode: -32601,
+		},
+		{
+			name:   "typed results",
+			method: "getPilot",
+			code:   emulator.MethodNotFoundCode,
+			request: func(ctx context.Context, wizClient *WizClient) error {
+				_, err := wizClient.ReadPilotStateContext(ctx)
+				return err
+			},
+			sentinel: ErrMethodNotFound,
+			wantCode: -32601,
+		},
+		{
+			name:   "invalid params",
+			method: "setPilot",
+			code:   emulator.InvalidParamsCode,
+			request: func(ctx context.Context, wizClient *WizClient) error {
+				_, err := wizClient.SetBrightnessContext(ctx, 50)
+				return err
+			},
+			sentinel: ErrInvalidParams,
+			wantCode: -32602,
+		},
+		{
+			name:   "internal error",
+			method: "getSystemConfig",
+			code:   -32603,
+			request: func(ctx context.Context, wizClient *WizClient) error {
+				_, err := wizClient.ReadSystemConfigContext(ctx)
+				return err
+			},
+			sentinel: ErrInternalError,
+			wantCode: -32603,
+		},
+		{
+			name:   "rejected by the device",
+			model:  emulator.ModelSocket,
+			method: "setPilot",
+			request: func(ctx context.Context, wizClient *WizClient) error {
+				_, err := wizClient.SetBrightnessContext(ctx, 50)
+				return err
+			},
+			sentinel: ErrInvalidParams,
+			wantCode: -32602,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			device := startEmulator(t, emulator.Options{Model: test.model})
+			if test.code != 0 {
+				device.InjectError(test.method, test.code)
+			}
+			wizClient := createClient(t, device, WizClientOptions{})
+
+			err := test.request(context.Background(), wizClient)
+
+			if !errors.Is(err, test.sentinel) {
+				t.Errorf("expected an error matching '%s', got: %v", test.sentinel, err)
+			}
+
+			var deviceErr *DeviceError
+			if !errors.As(err, &deviceErr) {
+				t.Fatalf("expected a DeviceError, got: %v", err)
+			}
+
+			if deviceErr.Code != test.wantCode || deviceErr.Method != test.method || deviceErr.Address != device.Address() {
+				t.Errorf("unexpected DeviceError: %+v", deviceErr)
+			}
+		})
+	}
+}
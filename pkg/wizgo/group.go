@@ -0,0 +1,247 @@
+package wizgo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	wizgotypes "github.com/achetronic/wizgo/api/types"
+)
+
+var (
+	// ErrGroupAborted is set on the members not reached, or interrupted, because another member failed
+	// under GroupAllOrNothing
+	ErrGroupAborted = errors.New(GroupAbortedErrorMessage)
+)
+
+const (
+	// Error messages
+	GroupAbortedErrorMessage         = "aborted as another member of the group failed"
+	GroupAbortedInFlightErrorMessage = "aborted as another member of the group failed: %s"
+	GroupErrorMessage                = "%d of %d devices failed: %s"
+)
+
+// GroupPolicy represents how a group behaves when some of its members fail
+type GroupPolicy int
+
+const (
+	// GroupBestEffort sends the message to every member, reporting the failures per device
+	GroupBestEffort GroupPolicy = iota
+
+	// GroupAllOrNothing stops sending the message as soon as one member fails, so the whole operation fails.
+	// Members already changed keep their change
+	GroupAllOrNothing
+)
+
+// GroupOptions represents the settings used when creating a Group
+type GroupOptions struct {
+	// Parallelism is the maximum number of members addressed at the same time. Zero means all of them
+	Parallelism int
+
+	// Policy defines how the group behaves when some of its members fail. Default: GroupBestEffort
+	Policy GroupPolicy
+}
+
+// Group represents several devices controlled together. Messages are sent to all of them concurrently,
+// so the lights change at once instead of one by one
+type Group struct {
+	wizClients []*WizClient
+	options    GroupOptions
+}
+
+// GroupResult represents the outcome of a message sent to one member of a group
+type GroupResult struct {
+	Address  string                        // Address of the member
+	Response wizgotypes.WizMessageResponse // Response of the member, when it answered
+	Err      error                         // Err is not nil when the member failed
+}
+
+// GroupResults represents the outcome of a message sent to a group, in the same order as its members
+type GroupResults []GroupResult
+
+// Failed return the results of the members that failed
+func (r GroupResults) Failed() (failed GroupResults) {
+	for _, result := range r {
+		if result.Err != nil {
+			failed = append(failed, result)
+		}
+	}
+	return failed
+}
+
+// GroupError is returned when some members of a group failed
+type GroupError struct {
+	Results GroupResults // Results of all the members, including the successful ones
+}
+
+func (e *GroupError) Error() string {
+	failed := e.Results.Failed()
+
+	messages := make([]string, 0, len(failed))
+	for _, result := range failed {
+		messages = append(messages, fmt.Sprintf("%s: %s", result.Address, result.Err))
+	}
+
+	return fmt.Sprintf(GroupErrorMessage, len(failed), len(e.Results), strings.Join(messages, "; "))
+}
+
+// Unwrap exposes the errors of the failed members to errors.Is and errors.As
+func (e *GroupError) Unwrap() []error {
+	failed := e.Results.Failed()
+
+	errs := make([]error, 0, len(failed))
+	for _, result := range failed {
+		errs = append(errs, result.Err)
+	}
+	return errs
+}
+
+// CreateGroup creates a group with the given clients as members
+func CreateGroup(wizClients []*WizClient, options GroupOptions) *Group {
+	return &Group{
+		wizClients: append([]*WizClient{}, wizClients...),
+		options:    options,
+	}
+}
+
+// Clients return the members of the group
+func (g *Group) Clients() []*WizClient {
+	return append([]*WizClient{}, g.wizClients...)
+}
+
+// Do runs the given function for every member concurrently, following the parallelism and policy of the group.
+// It return the results of all the members, and a GroupError when any of them failed
+func (g *Group) Do(ctx context.Context, action func(ctx context.Context, wizClient *WizClient) (wizgotypes.WizMessageResponse, error)) (results GroupResults, err error) {
+
+	// The group is aborted with its own cause, so it is not mistaken for the cancellation of the caller
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	parallelism := g.options.Parallelism
+	if parallelism <= 0 || parallelism > len(g.wizClients) {
+		parallelism = len(g.wizClients)
+	}
+
+	results = make(GroupResults, len(g.wizClients))
+	slots := make(chan struct{}, parallelism)
+
+	var wg sync.WaitGroup
+	for index, wizClient := range g.wizClients {
+		results[index].Address = wizClient.Address()
+
+		// Members waiting for a slot are not reached once the group is aborted
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			results[index].Err = g.abortedError(ctx)
+			continue
+		}
+
+		wg.Add(1)
+		go func(index int, wizClient *WizClient) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			if ctx.Err() != nil {
+				results[index].Err = g.abortedError(ctx)
+				return
+			}
+
+			results[index].Response, results[index].Err = action(ctx, wizClient)
+			if results[index].Err == nil {
+				return
+			}
+
+			// Members already running when the group is aborted are interrupted, not failed on their own
+			if errors.Is(results[index].Err, context.Canceled) && errors.Is(context.Cause(ctx), ErrGroupAborted) {
+				results[index].Err = &wrappedError{message: fmt.Sprintf(GroupAbortedInFlightErrorMessage, results[index].Err), cause: ErrGroupAborted}
+				return
+			}
+
+			if g.options.Policy == GroupAllOrNothing {
+				cancel(ErrGroupAborted)
+			}
+		}(index, wizClient)
+	}
+
+	wg.Wait()
+
+	if len(results.Failed()) > 0 {
+		return results, &GroupError{Results: results}
+	}
+	return results, nil
+}
+
+// abortedError return the error set on the members not reached
+func (g *Group) abortedError(ctx context.Context) error {
+	if errors.Is(context.Cause(ctx), ErrGroupAborted) {
+		return ErrGroupAborted
+	}
+	return ctx.Err()
+}
+
+// TurnOn turns on all the devices of the group
+func (g *Group) TurnOn() (results GroupResults, err error) {
+	return g.TurnOnContext(context.Background())
+}
+
+// TurnOnContext is like TurnOn but honours the deadline and cancellation of the given context
+func (g *Group) TurnOnContext(ctx context.Context) (results GroupResults, err error) {
+	return g.Do(ctx, func(ctx context.Context, wizClient *WizClient) (wizgotypes.WizMessageResponse, error) {
+		return wizClient.TurnOnContext(ctx)
+	})
+}
+
+// TurnOff turns off all the devices of the group
+func (g *Group) TurnOff() (results GroupResults, err error) {
+	return g.TurnOffContext(context.Background())
+}
+
+// TurnOffContext is like TurnOff but honours the deadline and cancellation of the given context
+func (g *Group) TurnOffContext(ctx context.Context) (results GroupResults, err error) {
+	return g.Do(ctx, func(ctx context.Context, wizClient *WizClient) (wizgotypes.WizMessageResponse, error) {
+		return wizClient.TurnOffContext(ctx)
+	})
+}
+
+// SetPilot sends all the changes composed in the builder to all the devices of the group
+func (g *Group) SetPilot(builder *PilotBuilder) (results GroupResults, err error) {
+	return g.SetPilotContext(context.Background(), builder)
+}
+
+// SetPilotContext is like SetPilot but honours the deadline and cancellation of the given context
+func (g *Group) SetPilotContext(ctx context.Context, builder *PilotBuilder) (results GroupResults, err error) {
+
+	// Invalid changes are rejected once for the whole group
+	if _, err = builder.Params(); err != nil {
+		return results, err
+	}
+
+	return g.Do(ctx, func(ctx context.Context, wizClient *WizClient) (wizgotypes.WizMessageResponse, error) {
+		return wizClient.SetPilotContext(ctx, builder)
+	})
+}
+
+// Pulse generate a pulse of light on all the devices of the group
+func (g *Group) Pulse() (results GroupResults, err error) {
+	return g.PulseContext(context.Background())
+}
+
+// PulseContext is like Pulse but honours the deadline and cancellation of the given context
+func (g *Group) PulseContext(ctx context.Context) (results GroupResults, err error) {
+	return g.Do(ctx, func(ctx context.Context, wizClient *WizClient) (wizgotypes.WizMessageResponse, error) {
+		return wizClient.PulseContext(ctx)
+	})
+}
+
+// Transition changes the light of all the devices of the group from one state to another over the given duration,
+// as WizClient.Transition does. An empty 'from' state means the current state of each device.
+// Members run their transitions concurrently, so the parallelism of the group should allow all of them
+func (g *Group) Transition(ctx context.Context, from, to wizgotypes.PilotState, duration time.Duration, easing Easing) (results GroupResults, err error) {
+	return g.Do(ctx, func(ctx context.Context, wizClient *WizClient) (response wizgotypes.WizMessageResponse, err error) {
+		return response, wizClient.Transition(ctx, from, to, duration, easing)
+	})
+}
@@ -0,0 +1,120 @@
+package wizgo
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/achetronic/wizgo/pkg/emulator"
+)
+
+func TestGroupPolicies(t *testing.T) {
+
+	// member represents how each emulated device of the group behaves
+	type member struct {
+		latency time.Duration
+		fail    bool
+	}
+
+	tests := []struct {
+		name        string
+		members     []member
+		options     GroupOptions
+		cancelAfter time.Duration // cancelAfter cancels the caller's context, zero to let the group finish
+
+		want        []error // want is the error expected for each member
+		wantReached []bool  // wantReached tells which members receive the message, nil when it depends on timing
+	}{
+		{
+			name:        "best effort reports the failures per member",
+			members:     []member{{}, {fail: true}, {}},
+			options:     GroupOptions{Policy: GroupBestEffort},
+			want:        []error{nil, ErrInvalidParams, nil},
+			wantReached: []bool{true, true, true},
+		},
+		{
+			name:        "best effort does not interrupt the others",
+			members:     []member{{fail: true}, {latency: 200 * time.Millisecond}},
+			options:     GroupOptions{Policy: GroupBestEffort},
+			want:        []error{ErrInvalidParams, nil},
+			wantReached: []bool{true, true},
+		},
+		{
+			name:        "all or nothing does not reach the members after a failure",
+			members:     []member{{fail: true}, {}, {}},
+			options:     GroupOptions{Policy: GroupAllOrNothing, Parallelism: 1},
+			want:        []error{ErrInvalidParams, ErrGroupAborted, ErrGroupAborted},
+			wantReached: []bool{true, false, false},
+		},
+		{
+			name:        "all or nothing interrupts the members in flight",
+			members:     []member{{latency: 100 * time.Millisecond, fail: true}, {latency: time.Second}},
+			options:     GroupOptions{Policy: GroupAllOrNothing},
+			want:        []error{ErrInvalidParams, ErrGroupAborted},
+			wantReached: []bool{true, true},
+		},
+		{
+			name:        "cancellation of the caller is not an abort",
+			members:     []member{{latency: time.Second}, {latency: time.Second}},
+			options:     GroupOptions{Policy: GroupAllOrNothing},
+			cancelAfter: 50 * time.Millisecond,
+			want:        []error{context.Canceled, context.Canceled},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			devices := make([]*emulator.Emulator, len(test.members))
+			wizClients := make([]*WizClient, len(test.members))
+			for index, member := range test.members {
+				devices[index] = startEmulator(t, emulator.Options{Latency: member.latency})
+				if member.fail {
+					devices[index].InjectError("setPilot", emulator.InvalidParamsCode)
+				}
+				wizClients[index] = createClient(t, devices[index], WizClientOptions{Timeout: 3 * time.Second, RetryPolicy: NoRetryPolicy})
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if test.cancelAfter > 0 {
+				time.AfterFunc(test.cancelAfter, cancel)
+			}
+
+			group := CreateGroup(wizClients, test.options)
+			results, err := group.SetPilotContext(ctx, CreatePilotBuilder().Brightness(50))
+
+			var groupErr *GroupError
+			if !errors.As(err, &groupErr) {
+				t.Fatalf("expected a GroupError, got: %v", err)
+			}
+
+			for index, result := range results {
+				if result.Address != devices[index].Address() {
+					t.Errorf("member %d: expected the result of %s, got the one of %s", index, devices[index].Address(), result.Address)
+				}
+
+				switch want := test.want[index]; {
+				case want == nil && result.Err != nil:
+					t.Errorf("member %d: unexpected error: %s", index, result.Err)
+				case !errors.Is(result.Err, want):
+					t.Errorf("member %d: expected an error matching '%v', got: %v", index, want, result.Err)
+				case want == context.Canceled && errors.Is(result.Err, ErrGroupAborted):
+					t.Errorf("member %d: the cancellation of the caller was reported as an abort", index)
+				}
+
+				if want := test.want[index]; want != nil && !errors.Is(err, want) {
+					t.Errorf("expected the GroupError matching '%v'", want)
+				}
+
+				if test.wantReached == nil {
+					continue
+				}
+				if reached := len(receivedMessages(devices[index], "setPilot")) > 0; reached != test.wantReached[index] {
+					t.Errorf("member %d: expected reached to be %t", index, test.wantReached[index])
+				}
+			}
+		})
+	}
+}
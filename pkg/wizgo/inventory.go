@@ -0,0 +1,310 @@
+package wizgo
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Error messages
+	DeviceNotFoundErrorMessage = "device '%s' not found in the inventory"
+	RoomNotFoundErrorMessage   = "room '%s' not found in the inventory"
+)
+
+// InventoryDevice represents a device known by the inventory
+type InventoryDevice struct {
+	DiscoveredDevice
+	Name     string    `json:"name,omitempty"` // Name given to the device with SetDeviceName
+	LastSeen time.Time `json:"lastSeen"`       // LastSeen is the last time the device answered the discovery
+}
+
+// Home represents a home as configured in the WiZ app
+type Home struct {
+	Id    int    `json:"id"`
+	Rooms []Room `json:"rooms"`
+}
+
+// Room represents a room as configured in the WiZ app.
+// Devices only know the id of their room, so names must be given with SetRoomName
+type Room struct {
+	HomeId  int               `json:"homeId"`
+	Id      int               `json:"id"`
+	Name    string            `json:"name,omitempty"`
+	Groups  []DeviceGroup     `json:"groups,omitempty"` // Groups configured inside the room
+	Devices []InventoryDevice `json:"devices"`          // Devices of the room, grouped or not
+}
+
+// DeviceGroup represents a group of devices inside a room, as configured in the WiZ app
+type DeviceGroup struct {
+	Id      int               `json:"id"`
+	Devices []InventoryDevice `json:"devices"`
+}
+
+// Inventory keeps track of the devices on the network, indexed by MAC, so they can be addressed
+// by name or room instead of IP. A device keeps its identity when DHCP gives it another IP.
+// An Inventory is safe for concurrent use by multiple goroutines
+type Inventory struct {
+	mutex sync.RWMutex
+
+	devices     map[string]*InventoryDevice
+	deviceNames map[string]string
+	roomNames   map[int]string
+
+	// clients holds the clients already created, indexed by MAC
+	clients       map[string]*WizClient
+	clientOptions WizClientOptions
+}
+
+// CreateInventory creates an empty inventory. Clients created by the inventory use the given options
+func CreateInventory(clientOptions WizClientOptions) *Inventory {
+	return &Inventory{
+		devices:       map[string]*InventoryDevice{},
+		deviceNames:   map[string]string{},
+		roomNames:     map[int]string{},
+		clients:       map[string]*WizClient{},
+		clientOptions: clientOptions,
+	}
+}
+
+// Refresh discovers the devices on the network and updates the inventory with them
+func (i *Inventory) Refresh(ctx context.Context, options DiscoverOptions) (err error) {
+
+	devices, err := Discover(ctx, options)
+	if err != nil {
+		return err
+	}
+
+	i.Update(devices...)
+	return nil
+}
+
+// Update adds the given devices to the inventory, or updates them when they are already known by MAC
+func (i *Inventory) Update(devices ...DiscoveredDevice) {
+
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	for _, device := range devices {
+		mac := NormalizeMac(device.Mac)
+		device.Mac = mac
+
+		// Clients already handed out are held by groups, listeners and reconcilers, so they are pointed
+		// to the new address instead of replaced. Only the ones that can not be pointed are dropped
+		if known, found := i.devices[mac]; found && known.Address() != device.Address() {
+			if wizClient, found := i.clients[mac]; found && wizClient.redirect(device.Ip, device.Port) != nil {
+				_ = wizClient.Close()
+				delete(i.clients, mac)
+			}
+		}
+
+		i.devices[mac] = &InventoryDevice{
+			DiscoveredDevice: device,
+			Name:             i.deviceNames[mac],
+			LastSeen:         time.Now(),
+		}
+	}
+}
+
+// SetDeviceName gives a name to the device with the given MAC. Names can be given before the device is found
+func (i *Inventory) SetDeviceName(mac string, name string) {
+
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	mac = NormalizeMac(mac)
+	i.deviceNames[mac] = name
+
+	if device, found := i.devices[mac]; found {
+		device.Name = name
+	}
+}
+
+// SetRoomName gives a name to the room with the given id
+func (i *Inventory) SetRoomName(roomId int, name string) {
+
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	i.roomNames[roomId] = name
+}
+
+// Devices return all the devices in the inventory, sorted by MAC
+func (i *Inventory) Devices() (devices []InventoryDevice) {
+
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+
+	for _, device := range i.devices {
+		devices = append(devices, *device)
+	}
+
+	sort.Slice(devices, func(a, b int) bool {
+		return devices[a].Mac < devices[b].Mac
+	})
+
+	return devices
+}
+
+// Device return the device with the given MAC
+func (i *Inventory) Device(mac string) (device InventoryDevice, found bool) {
+
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+
+	known, found := i.devices[NormalizeMac(mac)]
+	if !found {
+		return device, false
+	}
+	return *known, true
+}
+
+// Lookup return the device matching the given selector, which can be a MAC, a name or an IP
+func (i *Inventory) Lookup(selector string) (device InventoryDevice, err error) {
+
+	if device, found := i.Device(selector); found {
+		return device, nil
+	}
+
+	for _, device := range i.Devices() {
+		if strings.EqualFold(device.Name, selector) || device.Ip == selector {
+			return device, nil
+		}
+	}
+
+	return device, notFoundError(DeviceNotFoundErrorMessage, selector)
+}
+
+// Homes return the devices organized into homes, rooms and groups, as configured in the WiZ app
+func (i *Inventory) Homes() (homes []Home) {
+
+	i.mutex.RLock()
+	roomNames := make(map[int]string, len(i.roomNames))
+	for roomId, name := range i.roomNames {
+		roomNames[roomId] = name
+	}
+	i.mutex.RUnlock()
+
+	homeIndex := map[int]int{}
+	roomIndex := map[[2]int]int{}
+
+	for _, device := range i.Devices() {
+		if _, found := homeIndex[device.HomeId]; !found {
+			homeIndex[device.HomeId] = len(homes)
+			homes = append(homes, Home{Id: device.HomeId})
+		}
+		home := &homes[homeIndex[device.HomeId]]
+
+		roomKey := [2]int{device.HomeId, device.RoomId}
+		if _, found := roomIndex[roomKey]; !found {
+			roomIndex[roomKey] = len(home.Rooms)
+			home.Rooms = append(home.Rooms, Room{HomeId: device.HomeId, Id: device.RoomId, Name: roomNames[device.RoomId]})
+		}
+		room := &home.Rooms[roomIndex[roomKey]]
+
+		room.Devices = append(room.Devices, device)
+		if device.GroupId == 0 {
+			continue
+		}
+
+		groupFound := false
+		for index := range room.Groups {
+			if room.Groups[index].Id == device.GroupId {
+				room.Groups[index].Devices = append(room.Groups[index].Devices, device)
+				groupFound = true
+			}
+		}
+		if !groupFound {
+			room.Groups = append(room.Groups, DeviceGroup{Id: device.GroupId, Devices: []InventoryDevice{device}})
+		}
+	}
+
+	return homes
+}
+
+// Room return the room matching the given selector, which can be its name or its id
+func (i *Inventory) Room(selector string) (room Room, err error) {
+
+	for _, home := range i.Homes() {
+		for _, room := range home.Rooms {
+			if strings.EqualFold(room.Name, selector) || strconv.Itoa(room.Id) == selector {
+				return room, nil
+			}
+		}
+	}
+
+	return room, notFoundError(RoomNotFoundErrorMessage, selector)
+}
+
+// Client return a client for the device with the given MAC, pointing to its last known address.
+// Clients are created once and owned by the inventory, so they must not be closed by the caller.
+// They follow the device when Update finds it at another address
+func (i *Inventory) Client(mac string) (wizClient *WizClient, err error) {
+
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	mac = NormalizeMac(mac)
+
+	if wizClient, found := i.clients[mac]; found {
+		return wizClient, nil
+	}
+
+	device, found := i.devices[mac]
+	if !found {
+		return wizClient, notFoundError(DeviceNotFoundErrorMessage, mac)
+	}
+
+	wizClient, err = device.ClientWithOptions(i.clientOptions)
+	if err != nil {
+		return wizClient, err
+	}
+
+	i.clients[mac] = wizClient
+	return wizClient, nil
+}
+
+// Group return a group with the clients of the given devices
+func (i *Inventory) Group(devices []InventoryDevice, options GroupOptions) (group *Group, err error) {
+
+	wizClients := make([]*WizClient, 0, len(devices))
+	for _, device := range devices {
+		wizClient, err := i.Client(device.Mac)
+		if err != nil {
+			return group, err
+		}
+		wizClients = append(wizClients, wizClient)
+	}
+
+	return CreateGroup(wizClients, options), nil
+}
+
+// RoomGroup return a group with the clients of all the devices in the room matching the selector
+func (i *Inventory) RoomGroup(selector string, options GroupOptions) (group *Group, err error) {
+
+	room, err := i.Room(selector)
+	if err != nil {
+		return group, err
+	}
+
+	return i.Group(room.Devices, options)
+}
+
+// Close closes all the clients created by the inventory
+func (i *Inventory) Close() (err error) {
+
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	var errs []error
+	for mac, wizClient := range i.clients {
+		errs = append(errs, wizClient.Close())
+		delete(i.clients, mac)
+	}
+
+	return errors.Join(errs...)
+}
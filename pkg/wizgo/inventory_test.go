@@ -0,0 +1,50 @@
+package wizgo
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/achetronic/wizgo/pkg/emulator"
+)
+
+func TestInventoryClientFollowsTheDevice(t *testing.T) {
+
+	const mac = "a8:bb:50:00:00:01"
+
+	before := startEmulator(t, emulator.Options{Mac: NormalizeMac(mac)})
+	after := startEmulator(t, emulator.Options{Mac: NormalizeMac(mac)})
+
+	inventory := CreateInventory(WizClientOptions{})
+	t.Cleanup(func() { _ = inventory.Close() })
+
+	inventory.Update(DiscoveredDevice{Ip: before.Host(), Port: before.Port(), Mac: mac})
+
+	wizClient, err := inventory.Client(mac)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// The device gets another address, as when DHCP gives it another IP
+	inventory.Update(DiscoveredDevice{Ip: after.Host(), Port: after.Port(), Mac: mac})
+
+	if wizClient.Address() != after.Address() {
+		t.Errorf("expected the client pointing to %s, got %s", after.Address(), wizClient.Address())
+	}
+
+	if _, err = wizClient.GetPilotContext(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if len(receivedMessages(before, "getPilot")) != 0 || len(receivedMessages(after, "getPilot")) != 1 {
+		t.Errorf("expected the message sent to the new address only")
+	}
+
+	if current, _ := inventory.Client(mac); current != wizClient {
+		t.Errorf("expected the inventory to keep the same client")
+	}
+
+	if _, err = inventory.Client("a8:bb:50:00:00:02"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected an error matching ErrNotFound, got: %v", err)
+	}
+}
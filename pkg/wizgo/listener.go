@@ -0,0 +1,281 @@
+package wizgo
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	wizgotypes "github.com/achetronic/wizgo/api/types"
+)
+
+const (
+	// DefaultListenerPort is the UDP port where registered devices send their heartbeats
+	DefaultListenerPort = 38900
+
+	// DefaultRegistrationInterval is how often devices are registered again, as they forget registrations
+	DefaultRegistrationInterval = 30 * time.Second
+
+	// DefaultPhoneMac is the MAC announced to the devices when registering
+	DefaultPhoneMac = "AAAAAAAAAAAA"
+
+	// Methods of the messages sent by the devices on their own
+	SyncPilotMethod = "syncPilot"
+	FirstBeatMethod = "firstBeat"
+)
+
+// ListenerOptions represents the settings used when creating a Listener
+type ListenerOptions struct {
+	// Address to bind to. Default: all interfaces on DefaultListenerPort
+	Address string
+
+	// PhoneIp is the IP announced to the devices. Default: the local IP used to reach each device
+	PhoneIp string
+
+	// PhoneMac is the MAC announced to the devices. Default: DefaultPhoneMac
+	PhoneMac string
+
+	// RegistrationInterval is how often devices are registered again. Default: DefaultRegistrationInterval
+	RegistrationInterval time.Duration
+
+	// Handler receives the events instead of the Events channel when it is set.
+	// It is called from the reading goroutine, so it should return quickly
+	Handler func(event ListenerEvent)
+}
+
+// ListenerEvent represents a message pushed by a registered device
+type ListenerEvent struct {
+	Method     string                      // Method is SyncPilotMethod or FirstBeatMethod
+	Ip         string                      // Ip of the device sending the message
+	Mac        string                      // Mac of the device, normalized by NormalizeMac
+	Params     wizgotypes.WizMessageResult // Params carried by the message
+	Pilot      wizgotypes.PilotState       // Pilot is the typed state carried by 'syncPilot' messages
+	ReceivedAt time.Time
+}
+
+// Listener receives the state changes pushed by the devices registered on it,
+// so there is no need to poll them with GetPilot
+type Listener struct {
+	options    ListenerOptions
+	connection *net.UDPConn
+	events     chan ListenerEvent
+
+	// clients holds the devices to keep registered, indexed by address
+	clients      map[string]*WizClient
+	clientsMutex sync.Mutex
+
+	// registerNow wakes up the registration loop when a new device is added
+	registerNow chan struct{}
+
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// CreateListener binds the address where devices will push their messages.
+// The address is held until the listener is closed, by Close or when Run returns
+func CreateListener(options ListenerOptions) (listener *Listener, err error) {
+
+	if options.Address == "" {
+		options.Address = net.JoinHostPort("", strconv.Itoa(DefaultListenerPort))
+	}
+
+	if options.PhoneMac == "" {
+		options.PhoneMac = DefaultPhoneMac
+	}
+
+	if options.RegistrationInterval <= 0 {
+		options.RegistrationInterval = DefaultRegistrationInterval
+	}
+
+	address, err := net.ResolveUDPAddr("udp", options.Address)
+	if err != nil {
+		return listener, err
+	}
+
+	connection, err := net.ListenUDP("udp", address)
+	if err != nil {
+		return listener, err
+	}
+
+	listener = &Listener{
+		options:     options,
+		connection:  connection,
+		events:      make(chan ListenerEvent, 64),
+		clients:     map[string]*WizClient{},
+		registerNow: make(chan struct{}, 1),
+		closed:      make(chan struct{}),
+	}
+
+	return listener, nil
+}
+
+// Address return the local address where the listener receives the messages
+func (l *Listener) Address() string {
+	return l.connection.LocalAddr().String()
+}
+
+// Close releases the address of the listener and makes Run return. Closing it more than once has no effect
+func (l *Listener) Close() (err error) {
+	l.closeOnce.Do(func() {
+		close(l.closed)
+		err = l.connection.Close()
+	})
+	return err
+}
+
+// Events return the channel where the events are delivered when no Handler is set.
+// It is closed when Run returns
+func (l *Listener) Events() <-chan ListenerEvent {
+	return l.events
+}
+
+// Register adds a device to the set of devices kept registered while the listener runs
+func (l *Listener) Register(wizClient *WizClient) {
+
+	l.clientsMutex.Lock()
+	l.clients[wizClient.Address()] = wizClient
+	l.clientsMutex.Unlock()
+
+	select {
+	case l.registerNow <- struct{}{}:
+	default:
+	}
+}
+
+// Unregister removes a device from the set of devices kept registered, and asks it to stop pushing messages
+func (l *Listener) Unregister(ctx context.Context, wizClient *WizClient) (err error) {
+
+	l.clientsMutex.Lock()
+	delete(l.clients, wizClient.Address())
+	l.clientsMutex.Unlock()
+
+	_, err = wizClient.RegistrationContext(ctx, l.phoneIp(wizClient), l.options.PhoneMac, false)
+	return err
+}
+
+// Run registers the devices periodically and delivers the messages they push until the context is done,
+// or the listener is closed. The listener is closed when Run returns, so it can only run once
+func (l *Listener) Run(ctx context.Context) (err error) {
+
+	defer close(l.events)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Unblock the pending read when the context is done, and stop the registrations when the listener is closed
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-l.closed:
+			cancel()
+		}
+		_ = l.Close()
+	}()
+
+	go l.registerPeriodically(ctx)
+
+	buffer := make([]byte, 4096)
+	for {
+		n, remote, err := l.connection.ReadFromUDP(buffer)
+		if err != nil {
+			if l.isClosed() {
+				return nil
+			}
+			continue
+		}
+
+		var message wizgotypes.WizPushMessage
+		if json.Unmarshal(buffer[:n], &message) != nil {
+			continue
+		}
+
+		var typedMessage struct {
+			Params wizgotypes.PilotState `json:"params"`
+		}
+		if json.Unmarshal(buffer[:n], &typedMessage) != nil {
+			continue
+		}
+
+		if message.Method != SyncPilotMethod && message.Method != FirstBeatMethod {
+			continue
+		}
+
+		event := ListenerEvent{
+			Method:     message.Method,
+			Ip:         remote.IP.String(),
+			Mac:        NormalizeMac(message.Params.Mac),
+			Params:     message.Params,
+			Pilot:      typedMessage.Params,
+			ReceivedAt: time.Now(),
+		}
+
+		if l.options.Handler != nil {
+			l.options.Handler(event)
+			continue
+		}
+
+		select {
+		case l.events <- event:
+		case <-ctx.Done():
+			return nil
+		case <-l.closed:
+			return nil
+		}
+	}
+}
+
+// isClosed return true when the listener was closed
+func (l *Listener) isClosed() bool {
+	select {
+	case <-l.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// registerPeriodically registers all the devices on each interval, and as soon as new ones are added
+func (l *Listener) registerPeriodically(ctx context.Context) {
+
+	ticker := time.NewTicker(l.options.RegistrationInterval)
+	defer ticker.Stop()
+
+	// Devices added before running are registered on first round
+	select {
+	case <-l.registerNow:
+	default:
+	}
+
+	for {
+		l.clientsMutex.Lock()
+		clients := make([]*WizClient, 0, len(l.clients))
+		for _, wizClient := range l.clients {
+			clients = append(clients, wizClient)
+		}
+		l.clientsMutex.Unlock()
+
+		// Devices not answering now will be registered on next round
+		for _, wizClient := range clients {
+			go func(wizClient *WizClient) {
+				_, _ = wizClient.RegistrationContext(ctx, l.phoneIp(wizClient), l.options.PhoneMac, true)
+			}(wizClient)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-l.registerNow:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// phoneIp return the IP announced to the given device
+func (l *Listener) phoneIp(wizClient *WizClient) string {
+	if l.options.PhoneIp != "" {
+		return l.options.PhoneIp
+	}
+	return wizClient.LocalIp()
+}
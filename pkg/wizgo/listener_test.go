@@ -0,0 +1,193 @@
+package wizgo
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	wizgotypes "github.com/achetronic/wizgo/api/types"
+	"github.com/achetronic/wizgo/pkg/emulator"
+)
+
+// createListener creates a listener on a free local port that is closed when the test ends
+func createListener(t *testing.T) *Listener {
+	t.Helper()
+
+	listener, err := CreateListener(ListenerOptions{
+		Address:              "127.0.0.1:0",
+		PhoneIp:              "127.0.0.1",
+		RegistrationInterval: 50 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("error creating the listener: %s", err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+
+	return listener
+}
+
+// listenerPort return the port where the listener receives the messages
+func listenerPort(t *testing.T, listener *Listener) int {
+	t.Helper()
+
+	address, err := net.ResolveUDPAddr("udp", listener.Address())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	return address.Port
+}
+
+func TestListenerEvents(t *testing.T) {
+
+	tests := []struct {
+		name string
+		push func(device *emulator.Emulator) error
+
+		wantMethod string
+		wantPilot  wizgotypes.PilotState // wantPilot holds the fields expected in the state carried by the event
+		wantHomeId int
+	}{
+		{
+			name: "state changed on the device",
+			push: func(device *emulator.Emulator) error {
+				return device.SetPilotState(wizgotypes.PilotState{State: boolPointer(true), Dimming: intPointer(30)})
+			},
+			wantMethod: SyncPilotMethod,
+			wantPilot:  wizgotypes.PilotState{State: boolPointer(true), Dimming: intPointer(30)},
+		},
+		{
+			name: "device booted",
+			push: func(device *emulator.Emulator) error {
+				device.FirstBeat()
+				return nil
+			},
+			wantMethod: FirstBeatMethod,
+			wantHomeId: 7,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			listener := createListener(t)
+			device := startEmulator(t, emulator.Options{HomeId: 7, PushPort: listenerPort(t, listener)})
+			listener.Register(createClient(t, device, WizClientOptions{}))
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			done := make(chan error, 1)
+			go func() { done <- listener.Run(ctx) }()
+
+			// The device pushes nothing until it gets registered, so the change is made again until it arrives
+			var event ListenerEvent
+			deadline := time.After(2 * time.Second)
+			for received := false; !received; {
+				if err := test.push(device); err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+				select {
+				case event = <-listener.Events():
+					received = true
+				case <-time.After(50 * time.Millisecond):
+				case <-deadline:
+					t.Fatalf("no event received")
+				}
+			}
+
+			if event.Method != test.wantMethod {
+				t.Errorf("expected a '%s' event, got '%s'", test.wantMethod, event.Method)
+			}
+			if event.Mac != NormalizeMac(device.Mac()) || event.Ip != "127.0.0.1" {
+				t.Errorf("expected the event from %s at 127.0.0.1, got it from %s at %s", device.Mac(), event.Mac, event.Ip)
+			}
+			if event.Params.HomeId != test.wantHomeId {
+				t.Errorf("expected home %d, got %d", test.wantHomeId, event.Params.HomeId)
+			}
+			if test.wantPilot.Dimming != nil && intText(event.Pilot.Dimming) != intText(test.wantPilot.Dimming) {
+				t.Errorf("expected dimming %s, got %s", intText(test.wantPilot.Dimming), intText(event.Pilot.Dimming))
+			}
+			if test.wantPilot.State != nil && (event.Pilot.State == nil || *event.Pilot.State != *test.wantPilot.State) {
+				t.Errorf("expected state %t, got %v", *test.wantPilot.State, event.Pilot.State)
+			}
+
+			// Closing the listener stops it, whatever the context
+			if err := listener.Close(); err != nil {
+				t.Fatalf("unexpected error closing: %s", err)
+			}
+			select {
+			case err := <-done:
+				if err != nil {
+					t.Errorf("unexpected error: %s", err)
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("Run did not return after closing the listener")
+			}
+
+			for range listener.Events() {
+			}
+		})
+	}
+}
+
+func TestListenerClose(t *testing.T) {
+
+	tests := []struct {
+		name string
+		run  bool // run is set to run the listener before closing it
+	}{
+		{name: "never run"},
+		{name: "running", run: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			listener := createListener(t)
+			address := listener.Address()
+
+			done := make(chan error, 1)
+			if test.run {
+				go func() { done <- listener.Run(context.Background()) }()
+			}
+
+			if err := listener.Close(); err != nil {
+				t.Fatalf("unexpected error closing: %s", err)
+			}
+			if err := listener.Close(); err != nil {
+				t.Errorf("unexpected error closing again: %s", err)
+			}
+
+			if test.run {
+				select {
+				case <-done:
+				case <-time.After(time.Second):
+					t.Fatalf("Run did not return after closing the listener")
+				}
+			}
+
+			// The address is released, so another listener can take it
+			another, err := CreateListener(ListenerOptions{Address: address})
+			if err != nil {
+				t.Fatalf("expected the address released, got: %s", err)
+			}
+			_ = another.Close()
+		})
+	}
+}
+
+func TestListenerRunAfterClose(t *testing.T) {
+
+	listener := createListener(t)
+	if err := listener.Close(); err != nil {
+		t.Fatalf("unexpected error closing: %s", err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- listener.Run(context.Background()) }()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("Run did not return on a closed listener")
+	}
+}
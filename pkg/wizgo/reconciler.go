@@ -0,0 +1,501 @@
+package wizgo
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	wizgotypes "github.com/achetronic/wizgo/api/types"
+)
+
+const (
+	// DefaultReconcileInterval is how often the state of the devices is read to detect drift
+	DefaultReconcileInterval = 30 * time.Second
+
+	// DefaultMaxCorrections is how many corrections a device gets during DefaultCorrectionWindow
+	DefaultMaxCorrections = 10
+
+	// DefaultCorrectionWindow is the period the corrections of a device are limited in
+	DefaultCorrectionWindow = time.Hour
+)
+
+var (
+	// DefaultReconcileBackoff spaces the corrections of a device that keeps drifting, from one second to five minutes
+	DefaultReconcileBackoff = RetryPolicy{
+		InitialBackoff: 1 * time.Second,
+		MaxBackoff:     5 * time.Minute,
+		Multiplier:     2,
+		Jitter:         0.2,
+	}
+)
+
+// ReconcileEventType represents what the reconciler did about a device
+type ReconcileEventType string
+
+const (
+	ReconcileCorrected   ReconcileEventType = "corrected"   // ReconcileCorrected is set when the desired state was applied again
+	ReconcileFailed      ReconcileEventType = "failed"      // ReconcileFailed is set when the device rejected the desired state
+	ReconcileUnreachable ReconcileEventType = "unreachable" // ReconcileUnreachable is set when the state of the device could not be read
+	ReconcileRateLimited ReconcileEventType = "rateLimited" // ReconcileRateLimited is set when the device drifted too often to be corrected now
+)
+
+// ReconcileEvent describes a correction of a device, or why it could not be corrected
+type ReconcileEvent struct {
+	Type    ReconcileEventType
+	Mac     string
+	Address string
+	Changes []PilotChange // Changes found between the observed state and the desired one
+	Attempt int           // Attempt counts the corrections since the device was last seen converged, starting at 1
+	Err     error
+	Time    time.Time
+}
+
+// ReconcilerOptions represents the settings used when creating a Reconciler
+type ReconcilerOptions struct {
+	// PollInterval is how often the state of the devices is read. A negative interval disables polling,
+	// which only makes sense when Listener is set. Default: DefaultReconcileInterval
+	PollInterval time.Duration
+
+	// Listener enables detecting drift as soon as the devices push their state with 'syncPilot', when set.
+	// Its Handler is replaced by the reconciler
+	Listener *ListenerOptions
+
+	// Backoff spaces the corrections of a device that keeps drifting or failing. Attempts is ignored,
+	// as corrections go on while the device drifts. Default: DefaultReconcileBackoff
+	Backoff *RetryPolicy
+
+	// MaxCorrections is how many corrections a device gets during CorrectionWindow.
+	// A negative value means no limit. Default: DefaultMaxCorrections
+	MaxCorrections int
+
+	// CorrectionWindow is the period MaxCorrections applies to. Default: DefaultCorrectionWindow
+	CorrectionWindow time.Duration
+
+	// Handler receives an event for every correction, and every device that could not be corrected.
+	// It is called from several goroutines, so it must be safe for concurrent use and return quickly
+	Handler func(event ReconcileEvent)
+}
+
+// Reconciler keeps the devices of an inventory converged to a desired state, applying it again
+// when they drift from it: I.E. when a wall switch power-cycles them, or someone changes them from the app.
+// A Reconciler is safe for concurrent use by multiple goroutines
+type Reconciler struct {
+	inventory *Inventory
+	options   ReconcilerOptions
+
+	mutex   sync.Mutex
+	desired map[string]wizgotypes.PilotState
+	devices map[string]*reconcileDevice
+
+	// The following fields are only set while running
+	ctx      context.Context
+	listener *Listener
+	wg       sync.WaitGroup
+}
+
+// reconcileDevice holds the corrections made on a device
+type reconcileDevice struct {
+	mutex       sync.Mutex
+	attempt     int
+	nextAttempt time.Time
+	corrections []time.Time
+
+	// retry is guarded by the mutex of the reconciler, as it is stopped without waiting for running reconciliations
+	retry *time.Timer
+
+	// Triggers are coalesced, so a device pushing its state often keeps a single goroutine reconciling it.
+	// pending is set when a reconciliation is due, with the last state observed, if any. Guarded by the mutex
+	// of the reconciler too
+	pending  bool
+	observed *wizgotypes.PilotState
+	running  bool
+}
+
+// CreateReconciler creates a reconciler for the devices of the inventory, with no desired state yet
+func CreateReconciler(inventory *Inventory, options ReconcilerOptions) *Reconciler {
+
+	if options.PollInterval == 0 {
+		options.PollInterval = DefaultReconcileInterval
+	}
+
+	if options.Backoff == nil {
+		options.Backoff = &DefaultReconcileBackoff
+	}
+
+	if options.MaxCorrections == 0 {
+		options.MaxCorrections = DefaultMaxCorrections
+	}
+
+	if options.CorrectionWindow <= 0 {
+		options.CorrectionWindow = DefaultCorrectionWindow
+	}
+
+	return &Reconciler{
+		inventory: inventory,
+		options:   options,
+		desired:   map[string]wizgotypes.PilotState{},
+		devices:   map[string]*reconcileDevice{},
+	}
+}
+
+// SetDesired sets the state the device with the given MAC must be kept in. The device does not need
+// to be in the inventory yet. When the reconciler is running, the device is reconciled right away
+func (r *Reconciler) SetDesired(mac string, state wizgotypes.PilotState) (err error) {
+
+	if _, err = desiredPilot(state).Params(); err != nil {
+		return err
+	}
+
+	r.setDesired(map[string]wizgotypes.PilotState{NormalizeMac(mac): state})
+	return nil
+}
+
+// SetDesiredScene sets the pilots of the given scene as the desired state of the devices it selects
+func (r *Reconciler) SetDesiredScene(scene SceneFile) (err error) {
+
+	if err = scene.Validate(); err != nil {
+		return err
+	}
+
+	pilots, err := r.inventory.resolveScene(scene)
+	if err != nil {
+		return err
+	}
+
+	r.setDesired(pilots)
+	return nil
+}
+
+// RemoveDesired stops keeping the device with the given MAC in any state
+func (r *Reconciler) RemoveDesired(mac string) {
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	mac = NormalizeMac(mac)
+	delete(r.desired, mac)
+
+	if device, found := r.devices[mac]; found {
+		device.stopRetry()
+		delete(r.devices, mac)
+	}
+}
+
+// Desired return the desired state of every device, indexed by MAC
+func (r *Reconciler) Desired() (desired map[string]wizgotypes.PilotState) {
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	desired = make(map[string]wizgotypes.PilotState, len(r.desired))
+	for mac, state := range r.desired {
+		desired[mac] = state
+	}
+	return desired
+}
+
+// setDesired stores the desired states, forgetting the corrections made for the previous ones
+func (r *Reconciler) setDesired(pilots map[string]wizgotypes.PilotState) {
+
+	r.mutex.Lock()
+	for mac, state := range pilots {
+		r.desired[mac] = state
+		if device, found := r.devices[mac]; found {
+			device.stopRetry()
+		}
+		r.devices[mac] = &reconcileDevice{}
+	}
+	r.mutex.Unlock()
+
+	for mac := range pilots {
+		r.register(mac)
+		r.trigger(mac, nil)
+	}
+}
+
+// Run reconciles the devices on every poll interval, and whenever they push a state change, until the context is done
+func (r *Reconciler) Run(ctx context.Context) (err error) {
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	// Running reconciliations are cancelled before waiting for them
+	defer r.stop()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var listener *Listener
+	if r.options.Listener != nil {
+		listenerOptions := *r.options.Listener
+		listenerOptions.Handler = r.onListenerEvent
+
+		listener, err = CreateListener(listenerOptions)
+		if err != nil {
+			return err
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = listener.Run(ctx)
+		}()
+	}
+
+	r.mutex.Lock()
+	r.ctx, r.listener = ctx, listener
+	r.mutex.Unlock()
+
+	var ticker <-chan time.Time
+	if r.options.PollInterval > 0 {
+		pollTicker := time.NewTicker(r.options.PollInterval)
+		defer pollTicker.Stop()
+		ticker = pollTicker.C
+	}
+
+	for {
+		// Devices may join the inventory, or change their address, at any time
+		for mac := range r.Desired() {
+			r.register(mac)
+		}
+
+		r.Reconcile(ctx)
+
+		select {
+		case <-ticker:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// Reconcile reads the state of all the devices with a desired state concurrently,
+// and corrects the ones drifting from it
+func (r *Reconciler) Reconcile(ctx context.Context) {
+
+	var wg sync.WaitGroup
+	for mac := range r.Desired() {
+		wg.Add(1)
+		go func(mac string) {
+			defer wg.Done()
+			r.reconcile(ctx, mac, nil)
+		}(mac)
+	}
+	wg.Wait()
+}
+
+// stop forgets the running context, so no more reconciliations are triggered, and waits for the running ones
+func (r *Reconciler) stop() {
+
+	r.mutex.Lock()
+	r.ctx, r.listener = nil, nil
+	for _, device := range r.devices {
+		device.stopRetry()
+	}
+	r.mutex.Unlock()
+
+	r.wg.Wait()
+}
+
+// trigger reconciles the device in the background when the reconciler is running.
+// The observed state is used instead of reading it from the device, when given.
+// Triggers arriving while the device is being reconciled are coalesced into the next reconciliation
+func (r *Reconciler) trigger(mac string, observed *wizgotypes.PilotState) {
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	device := r.devices[mac]
+	if r.ctx == nil || device == nil {
+		return
+	}
+
+	device.pending, device.observed = true, observed
+	if device.running {
+		return
+	}
+	device.running = true
+
+	ctx := r.ctx
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+
+		for {
+			r.mutex.Lock()
+			if !device.pending || r.ctx == nil {
+				device.running = false
+				r.mutex.Unlock()
+				return
+			}
+			observed := device.observed
+			device.pending, device.observed = false, nil
+			r.mutex.Unlock()
+
+			r.reconcile(ctx, mac, observed)
+		}
+	}()
+}
+
+// register keeps the device registered on the listener, when there is one
+func (r *Reconciler) register(mac string) {
+
+	r.mutex.Lock()
+	listener := r.listener
+	r.mutex.Unlock()
+
+	if listener == nil {
+		return
+	}
+
+	if wizClient, err := r.inventory.Client(mac); err == nil {
+		listener.Register(wizClient)
+	}
+}
+
+// onListenerEvent reconciles the devices pushing their state, using the pushed state
+func (r *Reconciler) onListenerEvent(event ListenerEvent) {
+
+	if event.Method != SyncPilotMethod || event.Mac == "" {
+		return
+	}
+
+	r.mutex.Lock()
+	_, found := r.desired[event.Mac]
+	r.mutex.Unlock()
+
+	if found {
+		pilot := event.Pilot
+		r.trigger(event.Mac, &pilot)
+	}
+}
+
+// reconcile compares the observed state of the device with the desired one, and applies the desired state
+// when they differ, unless the device is backing off or has been corrected too often
+func (r *Reconciler) reconcile(ctx context.Context, mac string, observed *wizgotypes.PilotState) {
+
+	r.mutex.Lock()
+	pilot, found := r.desired[mac]
+	device := r.devices[mac]
+	r.mutex.Unlock()
+
+	if !found || device == nil {
+		return
+	}
+
+	device.mutex.Lock()
+	defer device.mutex.Unlock()
+
+	if ctx.Err() != nil {
+		return
+	}
+
+	event := ReconcileEvent{Mac: mac}
+
+	wizClient, err := r.inventory.Client(mac)
+	if err != nil {
+		r.emit(event, ReconcileUnreachable, err)
+		return
+	}
+	event.Address = wizClient.Address()
+
+	if observed == nil {
+		current, err := wizClient.ReadPilotStateContext(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				r.emit(event, ReconcileUnreachable, err)
+			}
+			return
+		}
+		observed = &current
+	}
+
+	desired := desiredState(pilot)
+	event.Changes = DiffPilotState(*observed, desired)
+	if len(event.Changes) == 0 {
+		device.attempt, device.nextAttempt = 0, time.Time{}
+		return
+	}
+
+	// A retry is already scheduled for the end of the pause
+	now := time.Now()
+	if now.Before(device.nextAttempt) {
+		return
+	}
+
+	if r.options.MaxCorrections > 0 {
+		device.pruneCorrections(now.Add(-r.options.CorrectionWindow))
+		if len(device.corrections) >= r.options.MaxCorrections {
+			device.nextAttempt = device.corrections[0].Add(r.options.CorrectionWindow)
+			r.scheduleRetry(mac, device, device.nextAttempt.Sub(now))
+			event.Attempt = device.attempt
+			r.emit(event, ReconcileRateLimited, nil)
+			return
+		}
+	}
+
+	device.attempt++
+	device.corrections = append(device.corrections, now)
+	event.Attempt = device.attempt
+
+	_, err = wizClient.SetPilotContext(ctx, CreatePilotBuilderFromState(desired))
+
+	// The device is read again after the pause, to confirm the correction held
+	pause := r.options.Backoff.backoff(device.attempt)
+	device.nextAttempt = now.Add(pause)
+	r.scheduleRetry(mac, device, pause)
+
+	if err != nil {
+		r.emit(event, ReconcileFailed, err)
+		return
+	}
+	r.emit(event, ReconcileCorrected, nil)
+}
+
+// scheduleRetry reconciles the device again after the given pause
+func (r *Reconciler) scheduleRetry(mac string, device *reconcileDevice, pause time.Duration) {
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	// The desired state changed, or the reconciler stopped, while reconciling
+	if r.devices[mac] != device || r.ctx == nil {
+		return
+	}
+
+	device.stopRetry()
+
+	device.retry = time.AfterFunc(pause, func() {
+		r.trigger(mac, nil)
+	})
+}
+
+// emit delivers the event to the handler, when there is one
+func (r *Reconciler) emit(event ReconcileEvent, eventType ReconcileEventType, err error) {
+
+	if r.options.Handler == nil {
+		return
+	}
+
+	event.Type, event.Err, event.Time = eventType, err, time.Now()
+	r.options.Handler(event)
+}
+
+// pruneCorrections forgets the corrections made before the given time
+func (d *reconcileDevice) pruneCorrections(since time.Time) {
+
+	kept := d.corrections[:0]
+	for _, correction := range d.corrections {
+		if correction.After(since) {
+			kept = append(kept, correction)
+		}
+	}
+	d.corrections = kept
+}
+
+// stopRetry cancels the scheduled retry, when there is one. It must be called holding the mutex of the reconciler
+func (d *reconcileDevice) stopRetry() {
+	if d.retry != nil {
+		d.retry.Stop()
+	}
+}
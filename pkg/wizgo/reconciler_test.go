@@ -0,0 +1,187 @@
+package wizgo
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	wizgotypes "github.com/achetronic/wizgo/api/types"
+	"github.com/achetronic/wizgo/pkg/emulator"
+)
+
+// createReconciler creates a reconciler for a single device, recording the events it emits
+func createReconciler(t *testing.T, device *emulator.Emulator, options ReconcilerOptions) (reconciler *Reconciler, events func() []ReconcileEvent) {
+	t.Helper()
+
+	inventory := CreateInventory(WizClientOptions{RetryPolicy: NoRetryPolicy})
+	t.Cleanup(func() { _ = inventory.Close() })
+	inventory.Update(DiscoveredDevice{Ip: device.Host(), Port: device.Port(), Mac: device.Mac()})
+
+	var mutex sync.Mutex
+	var recorded []ReconcileEvent
+	options.Handler = func(event ReconcileEvent) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		recorded = append(recorded, event)
+	}
+
+	events = func() []ReconcileEvent {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return append([]ReconcileEvent{}, recorded...)
+	}
+	return CreateReconciler(inventory, options), events
+}
+
+func TestReconcilerRateLimit(t *testing.T) {
+
+	tests := []struct {
+		name             string
+		maxCorrections   int
+		correctionWindow time.Duration
+		drifts           int           // drifts is how many times the device is changed and reconciled
+		waitBeforeLast   time.Duration // waitBeforeLast is waited before the last drift
+
+		wantEvents []ReconcileEventType
+	}{
+		{
+			name:           "limited within the window",
+			maxCorrections: 2, correctionWindow: time.Hour, drifts: 3,
+			wantEvents: []ReconcileEventType{ReconcileCorrected, ReconcileCorrected, ReconcileRateLimited},
+		},
+		{
+			name:           "corrected again once the window passes",
+			maxCorrections: 2, correctionWindow: 100 * time.Millisecond, drifts: 4, waitBeforeLast: 150 * time.Millisecond,
+			wantEvents: []ReconcileEventType{ReconcileCorrected, ReconcileCorrected, ReconcileRateLimited, ReconcileCorrected},
+		},
+		{
+			name:           "not limited",
+			maxCorrections: -1, drifts: 4,
+			wantEvents: []ReconcileEventType{ReconcileCorrected, ReconcileCorrected, ReconcileCorrected, ReconcileCorrected},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			device := startEmulator(t, emulator.Options{})
+
+			// No pause between corrections, so only the limit holds them back
+			reconciler, events := createReconciler(t, device, ReconcilerOptions{
+				PollInterval:     -1,
+				Backoff:          &RetryPolicy{},
+				MaxCorrections:   test.maxCorrections,
+				CorrectionWindow: test.correctionWindow,
+			})
+			if err := reconciler.SetDesired(device.Mac(), wizgotypes.PilotState{Temp: intPointer(2700), Dimming: intPointer(40)}); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			for drift := 1; drift <= test.drifts; drift++ {
+				if drift == test.drifts {
+					time.Sleep(test.waitBeforeLast)
+				}
+				if err := device.SetPilotState(wizgotypes.PilotState{R: intPointer(0), G: intPointer(0), B: intPointer(255)}); err != nil {
+					t.Fatalf("error changing the device: %s", err)
+				}
+				reconciler.Reconcile(context.Background())
+			}
+
+			types := []ReconcileEventType{}
+			for _, event := range events() {
+				types = append(types, event.Type)
+				if event.Mac != NormalizeMac(device.Mac()) || len(event.Changes) == 0 {
+					t.Errorf("expected the event of %s with the changes found, got %+v", device.Mac(), event)
+				}
+			}
+			if !reflect.DeepEqual(types, test.wantEvents) {
+				t.Errorf("expected the events %v, got %v", test.wantEvents, types)
+			}
+
+			corrections := 0
+			for _, eventType := range test.wantEvents {
+				if eventType == ReconcileCorrected {
+					corrections++
+				}
+			}
+			if messages := receivedMessages(device, "setPilot"); len(messages) != corrections {
+				t.Errorf("expected %d corrections sent, got %d", corrections, len(messages))
+			}
+		})
+	}
+}
+
+func TestReconcilerCoalescesTriggers(t *testing.T) {
+
+	// Every read takes a while, so the triggers arrive while the device is being reconciled
+	device := startEmulator(t, emulator.Options{Latency: 50 * time.Millisecond})
+	reconciler, events := createReconciler(t, device, ReconcilerOptions{PollInterval: -1})
+
+	desired := wizgotypes.PilotState{Temp: intPointer(2700), Dimming: intPointer(40)}
+	if err := device.SetPilotState(desired); err != nil {
+		t.Fatalf("error setting the initial state: %s", err)
+	}
+	if err := reconciler.SetDesired(device.Mac(), desired); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- reconciler.Run(ctx) }()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	// Wait for the first pass of the reconciler to end
+	deadline := time.Now().Add(2 * time.Second)
+	for len(receivedMessages(device, "getPilot")) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("the device was never read")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(150 * time.Millisecond)
+	before := len(receivedMessages(device, "getPilot"))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			reconciler.trigger(NormalizeMac(device.Mac()), nil)
+		}()
+	}
+	wg.Wait()
+	time.Sleep(300 * time.Millisecond)
+
+	// The first trigger starts a reconciliation, and the rest are coalesced into a single one after it
+	if reads := len(receivedMessages(device, "getPilot")) - before; reads < 1 || reads > 2 {
+		t.Errorf("expected the triggers coalesced into one or two reads, got %d", reads)
+	}
+
+	// A pushed state is used as is, and only the last one of a burst is reconciled
+	converged := wizgotypes.PilotState{State: boolPointer(true), Temp: intPointer(2700), Dimming: intPointer(40)}
+	drifted := wizgotypes.PilotState{State: boolPointer(true), SceneId: intPointer(4)}
+	reconciler.trigger(NormalizeMac(device.Mac()), &converged)
+	for i := 0; i < 5; i++ {
+		reconciler.trigger(NormalizeMac(device.Mac()), &drifted)
+	}
+
+	deadline = time.Now().Add(2 * time.Second)
+	for len(receivedMessages(device, "setPilot")) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("the drift pushed was never corrected")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(150 * time.Millisecond)
+
+	if messages := receivedMessages(device, "setPilot"); len(messages) != 1 {
+		t.Errorf("expected a single correction, got %d", len(messages))
+	}
+	if recorded := events(); len(recorded) != 1 || recorded[0].Type != ReconcileCorrected {
+		t.Errorf("expected a single correction event, got %+v", recorded)
+	}
+}
@@ -0,0 +1,52 @@
+package wizgo
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+var (
+	// DefaultRetryPolicy re-sends a lost message twice, waiting a bit longer each time
+	DefaultRetryPolicy = RetryPolicy{
+		Attempts:       3,
+		AttemptTimeout: 800 * time.Millisecond,
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     1 * time.Second,
+		Multiplier:     2,
+		Jitter:         0.2,
+	}
+
+	// NoRetryPolicy sends each message exactly once
+	NoRetryPolicy = RetryPolicy{
+		Attempts: 1,
+	}
+)
+
+// RetryPolicy represents how a message is re-sent when the device does not answer on time.
+// Messages whose method is listed in WizNonIdempotentMethods are always sent once
+type RetryPolicy struct {
+	Attempts       int           // Attempts is the maximum number of sends, including the first one
+	AttemptTimeout time.Duration // AttemptTimeout bounds the wait for each answer. Zero means the whole request timeout
+	InitialBackoff time.Duration // InitialBackoff is the pause before the second attempt
+	MaxBackoff     time.Duration // MaxBackoff caps the pause between attempts. Zero means no cap
+	Multiplier     float64       // Multiplier grows the pause after each attempt (exponential backoff)
+	Jitter         float64       // Jitter randomizes each pause by up to this fraction of it (0-1)
+}
+
+// backoff return the pause to apply after the given failed attempt (starting at 1)
+func (r *RetryPolicy) backoff(attempt int) time.Duration {
+
+	multiplier := math.Max(r.Multiplier, 1)
+	pause := float64(r.InitialBackoff) * math.Pow(multiplier, float64(attempt-1))
+
+	if r.MaxBackoff > 0 && pause > float64(r.MaxBackoff) {
+		pause = float64(r.MaxBackoff)
+	}
+
+	// Spread the retries of several clients so they do not collide again
+	jitter := math.Min(math.Max(r.Jitter, 0), 1)
+	pause += pause * jitter * (2*rand.Float64() - 1)
+
+	return time.Duration(pause)
+}
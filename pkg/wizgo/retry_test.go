@@ -0,0 +1,156 @@
+package wizgo
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/achetronic/wizgo/pkg/emulator"
+)
+
+func TestRetryPolicy(t *testing.T) {
+
+	getPilot := func(ctx context.Context, wizClient *WizClient) error {
+		_, err := wizClient.GetPilotContext(ctx)
+		return err
+	}
+	pulse := func(ctx context.Context, wizClient *WizClient) error {
+		_, err := wizClient.PulseContext(ctx)
+		return err
+	}
+
+	tests := []struct {
+		name     string
+		lossRate float64
+		latency  time.Duration
+		policy   RetryPolicy
+		method   string
+		request  func(ctx context.Context, wizClient *WizClient) error
+
+		wantTimeout bool
+		minSent     int
+		maxSent     int
+	}{
+		{
+			name:    "answered on the first attempt",
+			policy:  DefaultRetryPolicy,
+			method:  "getPilot",
+			request: getPilot,
+			minSent: 1, maxSent: 1,
+		},
+		{
+			name:     "lost datagrams are re-sent",
+			lossRate: 0.5,
+			policy:   RetryPolicy{Attempts: 30, AttemptTimeout: 30 * time.Millisecond},
+			method:   "getPilot",
+			request:  getPilot,
+			minSent:  0, maxSent: 30,
+		},
+		{
+			name:    "late answers to a previous attempt are accepted",
+			latency: 150 * time.Millisecond,
+			policy:  RetryPolicy{Attempts: 5, AttemptTimeout: 50 * time.Millisecond, InitialBackoff: 10 * time.Millisecond},
+			method:  "getPilot",
+			request: getPilot,
+			minSent: 2, maxSent: 5,
+		},
+		{
+			name:        "attempts run out",
+			latency:     time.Second,
+			policy:      RetryPolicy{Attempts: 3, AttemptTimeout: 50 * time.Millisecond},
+			method:      "getPilot",
+			request:     getPilot,
+			wantTimeout: true,
+			minSent:     3, maxSent: 3,
+		},
+		{
+			name:        "every datagram lost",
+			lossRate:    1,
+			policy:      RetryPolicy{Attempts: 3, AttemptTimeout: 30 * time.Millisecond},
+			method:      "getPilot",
+			request:     getPilot,
+			wantTimeout: true,
+			minSent:     0, maxSent: 0,
+		},
+		{
+			name:        "non idempotent methods are sent once",
+			latency:     time.Second,
+			policy:      RetryPolicy{Attempts: 3, AttemptTimeout: 50 * time.Millisecond},
+			method:      "pulse",
+			request:     pulse,
+			wantTimeout: true,
+			minSent:     1, maxSent: 1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			device := startEmulator(t, emulator.Options{LossRate: test.lossRate, Latency: test.latency})
+			wizClient := createClient(t, device, WizClientOptions{Timeout: 3 * time.Second, RetryPolicy: test.policy})
+
+			err := test.request(context.Background(), wizClient)
+
+			var timeoutErr *TimeoutError
+			switch {
+			case test.wantTimeout && !errors.As(err, &timeoutErr):
+				t.Fatalf("expected a TimeoutError, got: %v", err)
+			case test.wantTimeout && timeoutErr.Method != test.method:
+				t.Errorf("expected the timeout of '%s', got the one of '%s'", test.method, timeoutErr.Method)
+			case !test.wantTimeout && err != nil:
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			// Datagrams dropped by the emulator are not received, so only the ones delivered are counted
+			sent := len(receivedMessages(device, test.method))
+			if sent < test.minSent || sent > test.maxSent {
+				t.Errorf("expected between %d and %d messages received, got %d", test.minSent, test.maxSent, sent)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		policy   RetryPolicy
+		attempt  int
+		min, max time.Duration
+	}{
+		{
+			name:    "initial backoff",
+			policy:  RetryPolicy{InitialBackoff: 100 * time.Millisecond, Multiplier: 2},
+			attempt: 1,
+			min:     100 * time.Millisecond, max: 100 * time.Millisecond,
+		},
+		{
+			name:    "exponential growth",
+			policy:  RetryPolicy{InitialBackoff: 100 * time.Millisecond, Multiplier: 2},
+			attempt: 3,
+			min:     400 * time.Millisecond, max: 400 * time.Millisecond,
+		},
+		{
+			name:    "capped",
+			policy:  RetryPolicy{InitialBackoff: 100 * time.Millisecond, Multiplier: 2, MaxBackoff: 250 * time.Millisecond},
+			attempt: 5,
+			min:     250 * time.Millisecond, max: 250 * time.Millisecond,
+		},
+		{
+			name:    "jitter",
+			policy:  RetryPolicy{InitialBackoff: 100 * time.Millisecond, Jitter: 0.2},
+			attempt: 1,
+			min:     80 * time.Millisecond, max: 120 * time.Millisecond,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for i := 0; i < 100; i++ {
+				if pause := test.policy.backoff(test.attempt); pause < test.min || pause > test.max {
+					t.Fatalf("expected a pause between %s and %s, got %s", test.min, test.max, pause)
+				}
+			}
+		})
+	}
+}
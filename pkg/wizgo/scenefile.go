@@ -0,0 +1,311 @@
+package wizgo
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	wizgotypes "github.com/achetronic/wizgo/api/types"
+)
+
+const (
+	// Error messages
+	SceneFileErrorMessage          = "error reading scene file: %s"
+	SceneSelectorCountErrorMessage = "entry %d of scene '%s' must set exactly one of 'device' or 'room'"
+	SceneEmptyErrorMessage         = "scene '%s' has no devices"
+	SceneInvalidErrorMessage       = "scene can not be applied: %s"
+	SceneEntryErrorMessage         = "entry %d of scene '%s': %s"
+	SceneDeviceErrorMessage        = "%s: %s"
+)
+
+// SceneFile represents a named lighting setup, such as 'dinner' or 'cleaning', kept in a YAML or JSON file.
+// Entries are applied in order, so a device selected by several entries gets the pilot of the last one.
+// This allows setting a whole room and then overriding some of its devices:
+//
+//	name: dinner
+//	devices:
+//	  - room: living room
+//	    pilot: {temp: 2700, dimming: 40}
+//	  - device: sofa lamp
+//	    pilot: {r: 255, g: 120, b: 0, dimming: 30}
+type SceneFile struct {
+	Name        string       `json:"name"`
+	Description string       `json:"description,omitempty"`
+	Devices     []SceneEntry `json:"devices"`
+}
+
+// SceneEntry represents the desired state for the devices matching a selector
+type SceneEntry struct {
+	Device string                `json:"device,omitempty"` // Device selects a device by MAC, name or IP, as Inventory.Lookup
+	Room   string                `json:"room,omitempty"`   // Room selects all the devices of a room by name or id, as Inventory.Room
+	Pilot  wizgotypes.PilotState `json:"pilot"`
+}
+
+// ApplySceneOptions represents the settings used when applying a scene
+type ApplySceneOptions struct {
+	// DryRun computes the changes without sending them to the devices
+	DryRun bool
+
+	// Parallelism is the maximum number of devices addressed at the same time. Zero means all of them
+	Parallelism int
+}
+
+// SceneReport represents the outcome of applying a scene
+type SceneReport struct {
+	Scene   string              `json:"scene"`
+	DryRun  bool                `json:"dryRun,omitempty"`
+	Devices []SceneDeviceReport `json:"devices"`
+}
+
+// SceneDeviceReport represents the outcome of applying a scene on one device
+type SceneDeviceReport struct {
+	Mac     string        `json:"mac"`
+	Name    string        `json:"name,omitempty"`
+	Address string        `json:"address"`
+	Changes []PilotChange `json:"changes"` // Changes found between the state of the device and the scene
+	Applied bool          `json:"applied"` // Applied is true when the changes were sent to the device
+	Err     error         `json:"-"`       // Err is not nil when the device failed
+}
+
+// LoadSceneFile reads a scene from a YAML or JSON file. The name of the file is used when the scene has no name
+func LoadSceneFile(path string) (scene SceneFile, err error) {
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return scene, wrapError(SceneFileErrorMessage, err)
+	}
+
+	scene, err = decodeSceneFile(content)
+	if err != nil {
+		return scene, err
+	}
+
+	if scene.Name == "" {
+		scene.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+
+	return scene, scene.Validate()
+}
+
+// ParseSceneFile decodes a scene from YAML or JSON. As JSON is valid YAML, both are decoded the same way,
+// then matched against the JSON names of the fields, so there is a single schema for both formats
+func ParseSceneFile(content []byte) (scene SceneFile, err error) {
+
+	scene, err = decodeSceneFile(content)
+	if err != nil {
+		return scene, err
+	}
+
+	return scene, scene.Validate()
+}
+
+// decodeSceneFile decodes a scene from YAML or JSON without validating it
+func decodeSceneFile(content []byte) (scene SceneFile, err error) {
+
+	var document interface{}
+	if err = yaml.Unmarshal(content, &document); err != nil {
+		return scene, wrapError(SceneFileErrorMessage, err)
+	}
+
+	normalized, err := json.Marshal(document)
+	if err != nil {
+		return scene, wrapError(SceneFileErrorMessage, err)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(normalized))
+	decoder.DisallowUnknownFields()
+	if err = decoder.Decode(&scene); err != nil {
+		return scene, wrapError(SceneFileErrorMessage, err)
+	}
+
+	return scene, nil
+}
+
+// Validate checks the scene is well formed: every entry has one selector and a valid pilot.
+// What each device supports is checked when the scene is applied
+func (s SceneFile) Validate() error {
+
+	if len(s.Devices) == 0 {
+		return errors.New(fmt.Sprintf(SceneEmptyErrorMessage, s.Name))
+	}
+
+	var errs []error
+	for index, entry := range s.Devices {
+		if (entry.Device == "") == (entry.Room == "") {
+			errs = append(errs, errors.New(fmt.Sprintf(SceneSelectorCountErrorMessage, index+1, s.Name)))
+			continue
+		}
+
+		if _, err := desiredPilot(entry.Pilot).Params(); err != nil {
+			errs = append(errs, &wrappedError{message: fmt.Sprintf(SceneEntryErrorMessage, index+1, s.Name, err), cause: err})
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// ApplyScene brings the devices selected by the scene to their desired state. The scene is checked
+// against the capabilities of every device first, so nothing is changed when any of them can not apply it.
+// Then the devices are changed concurrently, and only when their current state differs from the scene.
+// The report carries the changes per device, and a GroupError is returned when some devices failed
+func (i *Inventory) ApplyScene(scene SceneFile, options ApplySceneOptions) (report SceneReport, err error) {
+	return i.ApplySceneContext(context.Background(), scene, options)
+}
+
+// ApplySceneContext is like ApplyScene but honours the deadline and cancellation of the given context
+func (i *Inventory) ApplySceneContext(ctx context.Context, scene SceneFile, options ApplySceneOptions) (report SceneReport, err error) {
+
+	report = SceneReport{Scene: scene.Name, DryRun: options.DryRun}
+
+	if err = scene.Validate(); err != nil {
+		return report, err
+	}
+
+	pilots, err := i.resolveScene(scene)
+	if err != nil {
+		return report, err
+	}
+
+	devices := make([]InventoryDevice, 0, len(pilots))
+	for mac := range pilots {
+		device, _ := i.Device(mac)
+		devices = append(devices, device)
+	}
+	sort.Slice(devices, func(a, b int) bool {
+		return devices[a].Mac < devices[b].Mac
+	})
+
+	group, err := i.Group(devices, GroupOptions{Parallelism: options.Parallelism})
+	if err != nil {
+		return report, err
+	}
+
+	report.Devices = make([]SceneDeviceReport, len(devices))
+	indexes := map[*WizClient]int{}
+	for index, wizClient := range group.Clients() {
+		indexes[wizClient] = index
+		report.Devices[index] = SceneDeviceReport{
+			Mac:     devices[index].Mac,
+			Name:    devices[index].Name,
+			Address: wizClient.Address(),
+			Changes: []PilotChange{},
+		}
+	}
+
+	// Every device is checked before changing any of them
+	results, err := group.Do(ctx, func(ctx context.Context, wizClient *WizClient) (response wizgotypes.WizMessageResponse, err error) {
+		return response, wizClient.CheckPilotContext(ctx, desiredPilot(pilots[report.Devices[indexes[wizClient]].Mac]))
+	})
+	if err != nil {
+		for index, result := range results {
+			report.Devices[index].Err = result.Err
+		}
+		return report, &wrappedError{message: fmt.Sprintf(SceneInvalidErrorMessage, sceneErrors(report)), cause: err}
+	}
+
+	results, err = group.Do(ctx, func(ctx context.Context, wizClient *WizClient) (response wizgotypes.WizMessageResponse, err error) {
+
+		deviceReport := &report.Devices[indexes[wizClient]]
+		desired := desiredState(pilots[deviceReport.Mac])
+
+		current, err := wizClient.ReadPilotStateContext(ctx)
+		if err != nil {
+			return response, err
+		}
+
+		if changes := DiffPilotState(current, desired); changes != nil {
+			deviceReport.Changes = changes
+		}
+
+		if options.DryRun || len(deviceReport.Changes) == 0 {
+			return response, nil
+		}
+
+		response, err = wizClient.SetPilotContext(ctx, desiredPilot(desired))
+		deviceReport.Applied = err == nil
+		return response, err
+	})
+
+	for index, result := range results {
+		report.Devices[index].Err = result.Err
+	}
+
+	return report, err
+}
+
+// resolveScene return the desired pilot of every device selected by the scene, indexed by MAC
+func (i *Inventory) resolveScene(scene SceneFile) (pilots map[string]wizgotypes.PilotState, err error) {
+
+	pilots = map[string]wizgotypes.PilotState{}
+
+	var errs []error
+	for index, entry := range scene.Devices {
+
+		var devices []InventoryDevice
+		if entry.Room != "" {
+			room, err := i.Room(entry.Room)
+			if err != nil {
+				errs = append(errs, &wrappedError{message: fmt.Sprintf(SceneEntryErrorMessage, index+1, scene.Name, err), cause: err})
+				continue
+			}
+			devices = room.Devices
+		} else {
+			device, err := i.Lookup(entry.Device)
+			if err != nil {
+				errs = append(errs, &wrappedError{message: fmt.Sprintf(SceneEntryErrorMessage, index+1, scene.Name, err), cause: err})
+				continue
+			}
+			devices = []InventoryDevice{device}
+		}
+
+		for _, device := range devices {
+			pilots[device.Mac] = entry.Pilot
+		}
+	}
+
+	return pilots, errors.Join(errs...)
+}
+
+// desiredState return the state a device is expected to report once the given pilot is applied.
+// Pilots changing the light turn the device on, so they are expected to report it on
+func desiredState(pilot wizgotypes.PilotState) wizgotypes.PilotState {
+
+	// Fields only reported by the devices are not part of the desired state
+	state := wizgotypes.PilotState{
+		State: pilot.State, SceneId: pilot.SceneId, Speed: pilot.Speed, Ratio: pilot.Ratio,
+		R: pilot.R, G: pilot.G, B: pilot.B, C: pilot.C, W: pilot.W, Temp: pilot.Temp, Dimming: pilot.Dimming,
+	}
+
+	if state.State == nil {
+		on := true
+		state.State = &on
+	}
+
+	return state
+}
+
+// desiredPilot return the builder bringing a device to the given pilot
+func desiredPilot(pilot wizgotypes.PilotState) *PilotBuilder {
+	return CreatePilotBuilderFromState(desiredState(pilot))
+}
+
+// sceneErrors return the errors of the devices of the report, one per device
+func sceneErrors(report SceneReport) string {
+
+	var messages []string
+	for _, device := range report.Devices {
+		if device.Err != nil {
+			messages = append(messages, fmt.Sprintf(SceneDeviceErrorMessage, device.Address, device.Err))
+		}
+	}
+
+	return strings.Join(messages, "; ")
+}
@@ -0,0 +1,253 @@
+package wizgo
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	wizgotypes "github.com/achetronic/wizgo/api/types"
+	"github.com/achetronic/wizgo/pkg/emulator"
+)
+
+func TestParseSceneFile(t *testing.T) {
+
+	dinner := SceneFile{
+		Name: "dinner",
+		Devices: []SceneEntry{
+			{Room: "living room", Pilot: wizgotypes.PilotState{Temp: intPointer(2700), Dimming: intPointer(40)}},
+			{Device: "sofa lamp", Pilot: wizgotypes.PilotState{R: intPointer(255), G: intPointer(120), B: intPointer(0), Dimming: intPointer(30)}},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		content string
+
+		want          *SceneFile // want is the scene expected, nil when the content must be refused
+		wantRangeErr  bool       // wantRangeErr is set when the error must carry a RangeError
+		wantErrString string     // wantErrString is the error expected, when it is known
+	}{
+		{
+			name: "YAML",
+			content: `
+name: dinner
+devices:
+  - room: living room
+    pilot: {temp: 2700, dimming: 40}
+  - device: sofa lamp
+    pilot:
+      r: 255
+      g: 120
+      b: 0
+      dimming: 30
+`,
+			want: &dinner,
+		},
+		{
+			name: "JSON",
+			content: `{"name": "dinner", "devices": [
+				{"room": "living room", "pilot": {"temp": 2700, "dimming": 40}},
+				{"device": "sofa lamp", "pilot": {"r": 255, "g": 120, "b": 0, "dimming": 30}}
+			]}`,
+			want: &dinner,
+		},
+		{
+			name:    "unknown field in the scene",
+			content: "name: dinner\nmood: cozy\ndevices:\n  - room: kitchen\n    pilot: {dimming: 40}\n",
+		},
+		{
+			name:    "unknown field in a pilot",
+			content: "name: dinner\ndevices:\n  - room: kitchen\n    pilot: {brightness: 40}\n",
+		},
+		{
+			name:          "entry with both selectors",
+			content:       "name: dinner\ndevices:\n  - room: kitchen\n    device: fridge light\n    pilot: {dimming: 40}\n",
+			wantErrString: "entry 1 of scene 'dinner' must set exactly one of 'device' or 'room'",
+		},
+		{
+			name:          "entry without selector",
+			content:       "name: dinner\ndevices:\n  - room: kitchen\n    pilot: {dimming: 40}\n  - pilot: {dimming: 40}\n",
+			wantErrString: "entry 2 of scene 'dinner' must set exactly one of 'device' or 'room'",
+		},
+		{
+			name:          "no devices",
+			content:       "name: dinner\n",
+			wantErrString: "scene 'dinner' has no devices",
+		},
+		{
+			name:         "pilot out of range",
+			content:      "name: dinner\ndevices:\n  - room: kitchen\n    pilot: {dimming: 5}\n",
+			wantRangeErr: true,
+		},
+		{
+			name:    "malformed",
+			content: "name: [dinner\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			scene, err := ParseSceneFile([]byte(test.content))
+
+			if test.want != nil {
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+				if !reflect.DeepEqual(scene, *test.want) {
+					t.Errorf("expected %+v, got %+v", *test.want, scene)
+				}
+				return
+			}
+
+			if err == nil {
+				t.Fatalf("expected an error, got %+v", scene)
+			}
+
+			var rangeErr *RangeError
+			if test.wantRangeErr && !errors.As(err, &rangeErr) {
+				t.Errorf("expected a RangeError, got: %s", err)
+			}
+			if test.wantErrString != "" && err.Error() != test.wantErrString {
+				t.Errorf("expected error '%s', got: %s", test.wantErrString, err)
+			}
+		})
+	}
+}
+
+func TestApplyScene(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		scene   string
+		options ApplySceneOptions
+
+		wantErr     error
+		wantChanged []string // wantChanged holds the names of the devices with changes in the report
+		wantSent    []string // wantSent holds the names of the devices receiving a 'setPilot'
+	}{
+		{
+			name: "only the devices differing are changed",
+			scene: `
+name: dinner
+devices:
+  - room: living room
+    pilot: {temp: 2700, dimming: 40}
+  - device: sofa lamp
+    pilot: {r: 255, g: 120, b: 0, dimming: 30}
+`,
+			wantChanged: []string{"sofa lamp"},
+			wantSent:    []string{"sofa lamp"},
+		},
+		{
+			name: "dry run",
+			scene: `
+name: dinner
+devices:
+  - room: living room
+    pilot: {temp: 2700, dimming: 40}
+  - device: sofa lamp
+    pilot: {r: 255, g: 120, b: 0, dimming: 30}
+`,
+			options:     ApplySceneOptions{DryRun: true},
+			wantChanged: []string{"sofa lamp"},
+		},
+		{
+			name: "nothing changed when a device can not apply the scene",
+			scene: `
+name: reading
+devices:
+  - room: living room
+    pilot: {temp: 5000, dimming: 100}
+  - device: hallway
+    pilot: {temp: 5000, dimming: 100}
+`,
+			wantErr: ErrNotSupported,
+		},
+		{
+			name: "nothing changed when a device is unknown",
+			scene: `
+name: reading
+devices:
+  - room: living room
+    pilot: {temp: 5000, dimming: 100}
+  - device: garage
+    pilot: {dimming: 100}
+`,
+			wantErr: ErrNotFound,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			devices := map[string]*emulator.Emulator{
+				"ceiling":   startEmulator(t, emulator.Options{Model: emulator.ModelRgb}),
+				"sofa lamp": startEmulator(t, emulator.Options{Model: emulator.ModelRgb}),
+				"hallway":   startEmulator(t, emulator.Options{Model: emulator.ModelDw}),
+			}
+			rooms := map[string]int{"ceiling": 1, "sofa lamp": 1, "hallway": 2}
+
+			inventory := CreateInventory(WizClientOptions{})
+			t.Cleanup(func() { _ = inventory.Close() })
+			inventory.SetRoomName(1, "living room")
+
+			for name, device := range devices {
+				inventory.SetDeviceName(device.Mac(), name)
+				inventory.Update(DiscoveredDevice{Ip: device.Host(), Port: device.Port(), Mac: device.Mac(), HomeId: 1, RoomId: rooms[name]})
+			}
+
+			// The ceiling is already as the scene wants it
+			if err := devices["ceiling"].SetPilotState(wizgotypes.PilotState{Temp: intPointer(2700), Dimming: intPointer(40)}); err != nil {
+				t.Fatalf("error setting the initial state: %s", err)
+			}
+
+			scene, err := ParseSceneFile([]byte(test.scene))
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			report, err := inventory.ApplyScene(scene, test.options)
+
+			if test.wantErr != nil && !errors.Is(err, test.wantErr) {
+				t.Errorf("expected an error matching %v, got: %v", test.wantErr, err)
+			}
+			if test.wantErr == nil && err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if report.Scene != scene.Name || report.DryRun != test.options.DryRun {
+				t.Errorf("expected the report of '%s' with dry run %t, got %+v", scene.Name, test.options.DryRun, report)
+			}
+
+			changed := []string{}
+			for _, deviceReport := range report.Devices {
+				if len(deviceReport.Changes) > 0 {
+					changed = append(changed, deviceReport.Name)
+				}
+				if deviceReport.Applied && (test.options.DryRun || len(deviceReport.Changes) == 0) {
+					t.Errorf("expected '%s' not applied", deviceReport.Name)
+				}
+			}
+			if len(changed) != len(test.wantChanged) || (len(changed) > 0 && !reflect.DeepEqual(changed, test.wantChanged)) {
+				t.Errorf("expected changes on %v, got them on %v", test.wantChanged, changed)
+			}
+
+			for name, device := range devices {
+				sent := len(receivedMessages(device, "setPilot")) > 0
+				wantSent := false
+				for _, wantName := range test.wantSent {
+					wantSent = wantSent || wantName == name
+				}
+				if sent != wantSent {
+					t.Errorf("expected the 'setPilot' sent to '%s' to be %t", name, wantSent)
+				}
+			}
+
+			if len(test.wantSent) > 0 {
+				state := devices["sofa lamp"].PilotState()
+				if state.Mode() != wizgotypes.PilotModeRgb || intText(state.G) != "120" || intText(state.Dimming) != "30" {
+					t.Errorf("expected the sofa lamp with the color of the scene, got %+v", state)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,172 @@
+package wizgo
+
+import (
+	"context"
+	"time"
+
+	wizgotypes "github.com/achetronic/wizgo/api/types"
+)
+
+const (
+	// Error messages
+	SnapshotNotFoundErrorMessage = "no snapshot taken for device %s"
+)
+
+// Snapshot represents the state of a device captured with Snapshot, so it can be restored later.
+// It can be encoded as JSON, so it survives process restarts
+type Snapshot struct {
+	Address string                `json:"address"`       // Address of the device when the snapshot was taken
+	Mac     string                `json:"mac,omitempty"` // Mac of the device, so it is found when its address changes
+	Pilot   wizgotypes.PilotState `json:"pilot"`
+	TakenAt time.Time             `json:"takenAt"`
+}
+
+// GroupSnapshot represents the state of the devices of a group captured with Group.Snapshot
+type GroupSnapshot struct {
+	Snapshots []Snapshot `json:"snapshots"`
+	TakenAt   time.Time  `json:"takenAt"`
+}
+
+// Snapshot captures the current state of the device: on/off, scene and speed, color or temperature, and brightness
+func (w *WizClient) Snapshot() (snapshot Snapshot, err error) {
+	return w.SnapshotContext(context.Background())
+}
+
+// SnapshotContext is like Snapshot but honours the deadline and cancellation of the given context
+func (w *WizClient) SnapshotContext(ctx context.Context) (snapshot Snapshot, err error) {
+
+	state, err := w.ReadPilotStateContext(ctx)
+	if err != nil {
+		return snapshot, err
+	}
+
+	// Fields describing the moment of the capture are not part of the state to restore
+	state.Rssi = nil
+	state.Src = ""
+
+	snapshot = Snapshot{
+		Address: w.Address(),
+		Mac:     NormalizeMac(state.Mac),
+		Pilot:   state,
+		TakenAt: time.Now(),
+	}
+	return snapshot, nil
+}
+
+// Restore brings the device back to the state captured in the snapshot with a single 'setPilot'.
+// Devices captured while off are just turned off
+func (w *WizClient) Restore(snapshot Snapshot) (response wizgotypes.WizMessageResponse, err error) {
+	return w.RestoreContext(context.Background(), snapshot)
+}
+
+// RestoreContext is like Restore but honours the deadline and cancellation of the given context
+func (w *WizClient) RestoreContext(ctx context.Context, snapshot Snapshot) (response wizgotypes.WizMessageResponse, err error) {
+	return w.SetPilotContext(ctx, restoreBuilder(snapshot.Pilot))
+}
+
+// restoreBuilder return the changes restoring the given state. The fields sent depend on the mode,
+// as devices report fields they do not accept together (I.E: 'temp' along with 'r', 'g' and 'b')
+func restoreBuilder(state wizgotypes.PilotState) *PilotBuilder {
+
+	builder := CreatePilotBuilder()
+	restored := wizgotypes.PilotState{Dimming: state.Dimming, Ratio: state.Ratio}
+
+	switch state.Mode() {
+	case wizgotypes.PilotModeOff:
+		return builder.State(false)
+
+	case wizgotypes.PilotModeScene:
+		if state.SceneId != nil && *state.SceneId > 0 {
+			restored.SceneId, restored.Speed = state.SceneId, state.Speed
+		}
+
+	case wizgotypes.PilotModeRgb:
+		restored.R, restored.G, restored.B, restored.C, restored.W = state.R, state.G, state.B, state.C, state.W
+
+	case wizgotypes.PilotModeCct:
+		if state.Temp != nil && *state.Temp > 0 {
+			restored.Temp = state.Temp
+		} else {
+			restored.C, restored.W = state.C, state.W
+		}
+	}
+
+	builder = CreatePilotBuilderFromState(restored)
+	return builder.State(true)
+}
+
+// Snapshot captures the current state of all the devices of the group.
+// Devices not answering are missing from the snapshot, and reported in the returned GroupError
+func (g *Group) Snapshot() (snapshot GroupSnapshot, err error) {
+	return g.SnapshotContext(context.Background())
+}
+
+// SnapshotContext is like Snapshot but honours the deadline and cancellation of the given context
+func (g *Group) SnapshotContext(ctx context.Context) (snapshot GroupSnapshot, err error) {
+
+	snapshots := make([]*Snapshot, len(g.wizClients))
+	indexes := make(map[*WizClient]int, len(g.wizClients))
+	for index, wizClient := range g.wizClients {
+		indexes[wizClient] = index
+	}
+
+	_, err = g.Do(ctx, func(ctx context.Context, wizClient *WizClient) (response wizgotypes.WizMessageResponse, err error) {
+		memberSnapshot, err := wizClient.SnapshotContext(ctx)
+		if err == nil {
+			snapshots[indexes[wizClient]] = &memberSnapshot
+		}
+		return response, err
+	})
+
+	snapshot.TakenAt = time.Now()
+	for _, memberSnapshot := range snapshots {
+		if memberSnapshot != nil {
+			snapshot.Snapshots = append(snapshot.Snapshots, *memberSnapshot)
+		}
+	}
+
+	return snapshot, err
+}
+
+// Restore brings every device of the group back to the state captured in the snapshot.
+// Devices are matched by address, or by MAC when their address changed
+func (g *Group) Restore(snapshot GroupSnapshot) (results GroupResults, err error) {
+	return g.RestoreContext(context.Background(), snapshot)
+}
+
+// RestoreContext is like Restore but honours the deadline and cancellation of the given context
+func (g *Group) RestoreContext(ctx context.Context, snapshot GroupSnapshot) (results GroupResults, err error) {
+	return g.Do(ctx, func(ctx context.Context, wizClient *WizClient) (response wizgotypes.WizMessageResponse, err error) {
+
+		memberSnapshot, found := snapshot.find(ctx, wizClient)
+		if !found {
+			return response, notFoundError(SnapshotNotFoundErrorMessage, wizClient.Address())
+		}
+
+		return wizClient.RestoreContext(ctx, memberSnapshot)
+	})
+}
+
+// find return the snapshot of the device behind the given client
+func (s GroupSnapshot) find(ctx context.Context, wizClient *WizClient) (snapshot Snapshot, found bool) {
+
+	for _, snapshot := range s.Snapshots {
+		if snapshot.Address == wizClient.Address() {
+			return snapshot, true
+		}
+	}
+
+	// The address may have changed since the snapshot was taken, so the device is asked for its MAC
+	state, err := wizClient.ReadPilotStateContext(ctx)
+	if err != nil || state.Mac == "" {
+		return snapshot, false
+	}
+
+	for _, snapshot := range s.Snapshots {
+		if snapshot.Mac == NormalizeMac(state.Mac) {
+			return snapshot, true
+		}
+	}
+
+	return snapshot, false
+}
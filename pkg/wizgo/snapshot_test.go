@@ -0,0 +1,174 @@
+package wizgo
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+
+	wizgotypes "github.com/achetronic/wizgo/api/types"
+	"github.com/achetronic/wizgo/pkg/emulator"
+)
+
+func TestSnapshotRestore(t *testing.T) {
+
+	tests := []struct {
+		name      string
+		initial   wizgotypes.PilotState // initial state captured in the snapshot
+		meanwhile wizgotypes.PilotState // meanwhile changes the device after the snapshot
+
+		wantMode   wizgotypes.PilotMode
+		wantParams wizgotypes.WizMessageParams // wantParams holds the params of the 'setPilot' restoring the device
+		stateOnly  bool                        // stateOnly compares on/off alone, as devices keep the rest while off
+	}{
+		{
+			name:       "off",
+			initial:    wizgotypes.PilotState{State: boolPointer(false)},
+			meanwhile:  wizgotypes.PilotState{State: boolPointer(true), Dimming: intPointer(30)},
+			wantMode:   wizgotypes.PilotModeOff,
+			wantParams: wizgotypes.WizMessageParams{"state": false},
+			stateOnly:  true,
+		},
+		{
+			name:       "scene",
+			initial:    wizgotypes.PilotState{SceneId: intPointer(4), Speed: intPointer(150), Dimming: intPointer(60)},
+			meanwhile:  wizgotypes.PilotState{Temp: intPointer(2700)},
+			wantMode:   wizgotypes.PilotModeScene,
+			wantParams: wizgotypes.WizMessageParams{"state": true, "sceneId": 4.0, "speed": 150.0, "dimming": 60.0},
+		},
+		{
+			name:       "color",
+			initial:    wizgotypes.PilotState{R: intPointer(255), G: intPointer(80), B: intPointer(0), C: intPointer(10), W: intPointer(0), Dimming: intPointer(40)},
+			meanwhile:  wizgotypes.PilotState{SceneId: intPointer(1)},
+			wantMode:   wizgotypes.PilotModeRgb,
+			wantParams: wizgotypes.WizMessageParams{"state": true, "r": 255.0, "g": 80.0, "b": 0.0, "c": 10.0, "w": 0.0, "dimming": 40.0},
+		},
+		{
+			name:       "white temperature",
+			initial:    wizgotypes.PilotState{Temp: intPointer(3000), Dimming: intPointer(70)},
+			meanwhile:  wizgotypes.PilotState{R: intPointer(0), G: intPointer(0), B: intPointer(255)},
+			wantMode:   wizgotypes.PilotModeCct,
+			wantParams: wizgotypes.WizMessageParams{"state": true, "temp": 3000.0, "dimming": 70.0},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			device := startEmulator(t, emulator.Options{})
+			wizClient := createClient(t, device, WizClientOptions{})
+
+			if err := device.SetPilotState(test.initial); err != nil {
+				t.Fatalf("error setting the initial state: %s", err)
+			}
+			want := device.PilotState()
+
+			snapshot, err := wizClient.Snapshot()
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if mode := snapshot.Pilot.Mode(); mode != test.wantMode {
+				t.Errorf("expected the snapshot in mode '%s', got '%s'", test.wantMode, mode)
+			}
+			if snapshot.Mac != NormalizeMac(device.Mac()) || snapshot.Pilot.Rssi != nil {
+				t.Errorf("expected the MAC of the device and no signal strength, got %+v", snapshot)
+			}
+
+			if err = device.SetPilotState(test.meanwhile); err != nil {
+				t.Fatalf("error changing the device: %s", err)
+			}
+
+			// Snapshots survive restarts as JSON
+			content, err := json.Marshal(snapshot)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			var decoded Snapshot
+			if err = json.Unmarshal(content, &decoded); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if _, err = wizClient.Restore(decoded); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			messages := receivedMessages(device, "setPilot")
+			if len(messages) != 1 {
+				t.Fatalf("expected a single 'setPilot', got %d", len(messages))
+			}
+			if !reflect.DeepEqual(messages[0].Params, test.wantParams) {
+				t.Errorf("expected %v, got %v", test.wantParams, messages[0].Params)
+			}
+
+			got := device.PilotState()
+			if test.stateOnly {
+				want, got = wizgotypes.PilotState{State: want.State}, wizgotypes.PilotState{State: got.State}
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("expected the device back to %+v, got %+v", want, got)
+			}
+		})
+	}
+}
+
+func TestGroupRestore(t *testing.T) {
+
+	const mac = "a8:bb:50:00:00:01"
+
+	tests := []struct {
+		name     string
+		moved    bool   // moved is set when the device gets another address after the snapshot
+		otherMac string // otherMac is the MAC of the device restored, when it is not the one captured
+
+		wantErr error
+	}{
+		{name: "same address"},
+		{name: "address changed", moved: true},
+		{name: "device not captured", moved: true, otherMac: "a8:bb:50:00:00:02", wantErr: ErrNotFound},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			before := startEmulator(t, emulator.Options{Mac: NormalizeMac(mac)})
+			if err := before.SetPilotState(wizgotypes.PilotState{Temp: intPointer(3000), Dimming: intPointer(70)}); err != nil {
+				t.Fatalf("error setting the initial state: %s", err)
+			}
+
+			snapshot, err := CreateGroup([]*WizClient{createClient(t, before, WizClientOptions{})}, GroupOptions{}).Snapshot()
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			device := before
+			if test.moved {
+				restoredMac := mac
+				if test.otherMac != "" {
+					restoredMac = test.otherMac
+				}
+				device = startEmulator(t, emulator.Options{Mac: NormalizeMac(restoredMac)})
+			}
+			if err = device.SetPilotState(wizgotypes.PilotState{R: intPointer(0), G: intPointer(0), B: intPointer(255)}); err != nil {
+				t.Fatalf("error changing the device: %s", err)
+			}
+
+			_, err = CreateGroup([]*WizClient{createClient(t, device, WizClientOptions{})}, GroupOptions{}).Restore(snapshot)
+
+			if test.wantErr != nil {
+				if !errors.Is(err, test.wantErr) {
+					t.Errorf("expected an error matching %v, got: %v", test.wantErr, err)
+				}
+				if messages := receivedMessages(device, "setPilot"); len(messages) > 0 {
+					t.Errorf("expected the device left as it is, got %d changes", len(messages))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			state := device.PilotState()
+			if state.Mode() != wizgotypes.PilotModeCct || intText(state.Temp) != "3000" || intText(state.Dimming) != "70" {
+				t.Errorf("expected the device back to 3000K at 70%%, got %+v", state)
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package wizgo
+
+import (
+	"context"
+	"reflect"
+	"strings"
+
+	wizgotypes "github.com/achetronic/wizgo/api/types"
+)
+
+// PilotChange represents a field of the pilot whose current value differs from the desired one
+type PilotChange struct {
+	Field string      `json:"field"` // Field as sent to the device: state, dimming, r, temp, etc.
+	From  interface{} `json:"from"`  // From is the current value, or nil when the device did not report it
+	To    interface{} `json:"to"`
+}
+
+// DiffPilotState return the fields set in the desired state whose value differs from the current one.
+// Fields not set in the desired state are not compared, as they are not going to change
+func DiffPilotState(current, desired wizgotypes.PilotState) (changes []PilotChange) {
+
+	currentValue := reflect.ValueOf(current)
+	desiredValue := reflect.ValueOf(desired)
+
+	for index := 0; index < desiredValue.NumField(); index++ {
+		field := desiredValue.Type().Field(index)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+
+		// Only the fields that can be set are pointers, apart from the signal strength
+		if field.Type.Kind() != reflect.Pointer || name == "rssi" || desiredValue.Field(index).IsNil() {
+			continue
+		}
+
+		change := PilotChange{Field: name, To: desiredValue.Field(index).Elem().Interface()}
+		if !currentValue.Field(index).IsNil() {
+			change.From = currentValue.Field(index).Elem().Interface()
+		}
+
+		if change.From != change.To {
+			changes = append(changes, change)
+		}
+	}
+
+	return changes
+}
+
+// ReadPilotState return the current status for colors, temperature, scenes, etc.
+// It is the typed counterpart of GetPilot
+func (w *WizClient) ReadPilotState() (state wizgotypes.PilotState, err error) {
+	return w.ReadPilotStateContext(context.Background())
+}
+
+// ReadPilotStateContext is like ReadPilotState but honours the deadline and cancellation of the given context
+func (w *WizClient) ReadPilotStateContext(ctx context.Context) (state wizgotypes.PilotState, err error) {
+	err = w.sendTypedMessage(ctx, wizgotypes.WizMessage{Method: "getPilot"}, &state)
+	return state, err
+}
+
+// ReadSystemConfig return current configuration related to the system.
+// It is the typed counterpart of GetSystemConfig
+func (w *WizClient) ReadSystemConfig() (config wizgotypes.SystemConfig, err error) {
+	return w.ReadSystemConfigContext(context.Background())
+}
+
+// ReadSystemConfigContext is like ReadSystemConfig but honours the deadline and cancellation of the given context
+func (w *WizClient) ReadSystemConfigContext(ctx context.Context) (config wizgotypes.SystemConfig, err error) {
+	err = w.sendTypedMessage(ctx, wizgotypes.WizMessage{Method: "getSystemConfig"}, &config)
+	return config, err
+}
+
+// ReadModelConfig return current configuration related to the device model.
+// It is the typed counterpart of GetModelConfig
+func (w *WizClient) ReadModelConfig() (config wizgotypes.ModelConfig, err error) {
+	return w.ReadModelConfigContext(context.Background())
+}
+
+// ReadModelConfigContext is like ReadModelConfig but honours the deadline and cancellation of the given context
+func (w *WizClient) ReadModelConfigContext(ctx context.Context) (config wizgotypes.ModelConfig, err error) {
+	err = w.sendTypedMessage(ctx, wizgotypes.WizMessage{Method: "getModelConfig"}, &config)
+	return config, err
+}
+
+// ReadUserConfig return current configuration related to the user.
+// It is the typed counterpart of GetUserConfig
+func (w *WizClient) ReadUserConfig() (config wizgotypes.UserConfig, err error) {
+	return w.ReadUserConfigContext(context.Background())
+}
+
+// ReadUserConfigContext is like ReadUserConfig but honours the deadline and cancellation of the given context
+func (w *WizClient) ReadUserConfigContext(ctx context.Context) (config wizgotypes.UserConfig, err error) {
+	err = w.sendTypedMessage(ctx, wizgotypes.WizMessage{Method: "getUserConfig"}, &config)
+	return config, err
+}
+
+// ReadDevInfo return current configuration related to the device.
+// It is the typed counterpart of GetDevInfo
+func (w *WizClient) ReadDevInfo() (info wizgotypes.DevInfo, err error) {
+	return w.ReadDevInfoContext(context.Background())
+}
+
+// ReadDevInfoContext is like ReadDevInfo but honours the deadline and cancellation of the given context
+func (w *WizClient) ReadDevInfoContext(ctx context.Context) (info wizgotypes.DevInfo, err error) {
+	err = w.sendTypedMessage(ctx, wizgotypes.WizMessage{Method: "getDevInfo"}, &info)
+	return info, err
+}
@@ -0,0 +1,298 @@
+package wizgo
+
+import (
+	"context"
+	"errors"
+	"math"
+	"time"
+
+	wizgotypes "github.com/achetronic/wizgo/api/types"
+)
+
+const (
+	// DefaultTransitionInterval is the time between the frames of a transition when no other is given.
+	// Devices start dropping messages when they receive them much faster
+	DefaultTransitionInterval = 100 * time.Millisecond
+
+	// Error messages
+	TransitionInterruptedErrorMessage = "transition interrupted by another one"
+)
+
+var (
+	// ErrTransitionInterrupted is returned by a transition when another one starts on the same client
+	ErrTransitionInterrupted = errors.New(TransitionInterruptedErrorMessage)
+)
+
+// Easing represents how a transition progresses over time.
+// It receives the elapsed fraction of the duration (0-1) and return the fraction of the change to apply (0-1)
+type Easing func(progress float64) float64
+
+// EaseLinear changes at a constant pace
+func EaseLinear(progress float64) float64 {
+	return progress
+}
+
+// EaseIn starts slow and speeds up
+func EaseIn(progress float64) float64 {
+	return progress * progress
+}
+
+// EaseOut starts fast and slows down
+func EaseOut(progress float64) float64 {
+	return progress * (2 - progress)
+}
+
+// EaseInOut starts and ends slow
+func EaseInOut(progress float64) float64 {
+	return (1 - math.Cos(math.Pi*progress)) / 2
+}
+
+// Transition changes the light from one state to another over the given duration, sending 'setPilot' frames
+// at the transition interval of the client. Brightness is interpolated linearly, colors in the OKLab space,
+// so they look evenly spaced, and white temperatures in mireds. Fields that can not be interpolated, such as
+// scenes or a change between color and white modes, are applied on the first frame.
+//
+// An empty 'from' state means the current state of the device. Frames not answered in time are skipped,
+// while the last one, setting 'to' exactly, must succeed. Cancelling the context stops the transition on
+// the last frame sent, and starting another transition on the same client interrupts this one.
+//
+// Devices keep the brightness they are turned off at, so after fading out to off the client remembers the
+// brightness the fade started at, and the next TurnOn on it comes back there. Any other change to the light
+// forgets it
+func (w *WizClient) Transition(ctx context.Context, from, to wizgotypes.PilotState, duration time.Duration, easing Easing) (err error) {
+
+	ctx, done := w.startTransition(ctx)
+	defer done()
+
+	if easing == nil {
+		easing = EaseLinear
+	}
+
+	// The last frame is checked before sending anything, so invalid targets do not leave the light half-way
+	last := CreatePilotBuilderFromState(to)
+	if to.State != nil && !*to.State {
+		last = CreatePilotBuilder().State(false)
+	}
+	if _, err = last.Params(); err != nil {
+		return err
+	}
+
+	if isEmptyPilot(from) {
+		from, err = w.ReadPilotStateContext(ctx)
+		if err != nil {
+			return transitionError(ctx, err)
+		}
+	}
+
+	plan := planTransition(from, to)
+
+	// Frames are checked against the capabilities, so they are fetched once before the short frame deadlines
+	_, _ = w.CapabilitiesContext(ctx)
+
+	ticker := time.NewTicker(w.transitionInterval)
+	defer ticker.Stop()
+
+	start := time.Now()
+	for first := true; ; first = false {
+
+		// Progress follows the clock, so slow answers skip frames instead of stretching the transition
+		elapsed := time.Since(start)
+		if elapsed >= duration {
+			break
+		}
+
+		frame := plan.frame(easing(float64(elapsed)/float64(duration)), first)
+		if _, err := frame.Params(); err == nil {
+			frameCtx, cancel := context.WithTimeout(ctx, w.transitionInterval)
+			_, _ = w.SetPilotContext(frameCtx, frame)
+			cancel()
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return transitionError(ctx, ctx.Err())
+		}
+	}
+
+	_, err = w.SetPilotContext(ctx, last)
+	if err == nil && plan.restoreDimming {
+		w.dimmingOnTurnOn.Store(int64(plan.dimmingFrom))
+	}
+	return transitionError(ctx, err)
+}
+
+// startTransition interrupts the transition running on the client, if any, and return the context of the new one
+func (w *WizClient) startTransition(ctx context.Context) (transitionCtx context.Context, done func()) {
+
+	transitionCtx, cancel := context.WithCancelCause(ctx)
+
+	w.cancelTransitionMutex.Lock()
+	if w.cancelTransition != nil {
+		w.cancelTransition(ErrTransitionInterrupted)
+	}
+	w.transitionId++
+	transitionId := w.transitionId
+	w.cancelTransition = cancel
+	w.cancelTransitionMutex.Unlock()
+
+	done = func() {
+		w.cancelTransitionMutex.Lock()
+		if w.transitionId == transitionId {
+			w.cancelTransition = nil
+		}
+		w.cancelTransitionMutex.Unlock()
+		cancel(nil)
+	}
+
+	return transitionCtx, done
+}
+
+// transitionError return ErrTransitionInterrupted instead of the given error when another transition took over
+func transitionError(ctx context.Context, err error) error {
+	if err != nil && errors.Is(context.Cause(ctx), ErrTransitionInterrupted) {
+		return ErrTransitionInterrupted
+	}
+	return err
+}
+
+// transitionPlan holds what changes on each frame of a transition
+type transitionPlan struct {
+	turnOn bool // turnOn is set when the device starts turned off
+
+	dimmingFrom, dimmingTo int
+	interpolateDimming     bool
+
+	// restoreDimming is set when fading out, so the next TurnOn comes back at the brightness the device had
+	restoreDimming bool
+
+	rgbFrom, rgbTo  oklab
+	interpolateRgb  bool
+	coldWhite       [2]*int
+	warmWhite       [2]*int
+	temperatureFrom float64 // temperatureFrom in mireds
+	temperatureTo   float64 // temperatureTo in mireds
+	interpolateTemp bool
+
+	// initial holds the fields that can not be interpolated, applied on the first frame
+	initial wizgotypes.PilotState
+}
+
+// planTransition decides how each field goes from one state to the other
+func planTransition(from, to wizgotypes.PilotState) (plan transitionPlan) {
+
+	turningOff := to.State != nil && !*to.State
+	startingOff := from.State != nil && !*from.State
+
+	// Turned off devices start from the lowest brightness, and end there before turning off
+	plan.turnOn = startingOff && !turningOff
+	plan.dimmingFrom = intOr(from.Dimming, MaxBrightness)
+	if startingOff {
+		plan.dimmingFrom = MinBrightness
+	}
+	plan.dimmingTo = intOr(to.Dimming, intOr(from.Dimming, MaxBrightness))
+	if turningOff {
+		plan.dimmingTo = MinBrightness
+	}
+	plan.interpolateDimming = plan.dimmingFrom != plan.dimmingTo || plan.turnOn
+
+	if turningOff {
+		plan.restoreDimming = plan.interpolateDimming && !startingOff && from.Dimming != nil
+		return plan
+	}
+
+	switch to.Mode() {
+	case wizgotypes.PilotModeRgb:
+		if to.R == nil || to.G == nil || to.B == nil {
+			break
+		}
+		if from.Mode() != wizgotypes.PilotModeRgb || from.R == nil || from.G == nil || from.B == nil || startingOff {
+			plan.initial.R, plan.initial.G, plan.initial.B = to.R, to.G, to.B
+			plan.initial.C, plan.initial.W = to.C, to.W
+			break
+		}
+		plan.rgbFrom = rgbToOklab(*from.R, *from.G, *from.B)
+		plan.rgbTo = rgbToOklab(*to.R, *to.G, *to.B)
+		plan.interpolateRgb = true
+		plan.coldWhite = [2]*int{from.C, to.C}
+		plan.warmWhite = [2]*int{from.W, to.W}
+
+	case wizgotypes.PilotModeCct:
+		if to.Temp == nil || *to.Temp <= 0 {
+			plan.initial.C, plan.initial.W = to.C, to.W
+			break
+		}
+		if from.Mode() != wizgotypes.PilotModeCct || from.Temp == nil || *from.Temp <= 0 || startingOff {
+			plan.initial.Temp = to.Temp
+			break
+		}
+		plan.temperatureFrom = 1e6 / float64(*from.Temp)
+		plan.temperatureTo = 1e6 / float64(*to.Temp)
+		plan.interpolateTemp = true
+
+	case wizgotypes.PilotModeScene:
+		plan.initial.SceneId, plan.initial.Speed = to.SceneId, to.Speed
+	}
+
+	plan.initial.Ratio = to.Ratio
+	return plan
+}
+
+// frame return the changes to send at the given progress (0-1) of the transition
+func (p transitionPlan) frame(progress float64, first bool) *PilotBuilder {
+
+	builder := CreatePilotBuilder()
+
+	if first {
+		if p.turnOn {
+			builder.State(true)
+		}
+		initial := CreatePilotBuilderFromState(p.initial)
+		for field, value := range initial.params {
+			builder.params[field] = value
+		}
+	}
+
+	if p.interpolateDimming {
+		dimming := interpolate(float64(p.dimmingFrom), float64(p.dimmingTo), progress)
+		builder.Brightness(int(math.Round(math.Max(MinBrightness, math.Min(MaxBrightness, dimming)))))
+	}
+
+	if p.interpolateRgb {
+		r, g, b := p.rgbFrom.mix(p.rgbTo, progress).rgb()
+		builder.Rgb(r, g, b)
+
+		if p.coldWhite[0] != nil && p.coldWhite[1] != nil {
+			builder.ColdWhite(clampChannel(interpolate(float64(*p.coldWhite[0]), float64(*p.coldWhite[1]), progress)))
+		}
+		if p.warmWhite[0] != nil && p.warmWhite[1] != nil {
+			builder.WarmWhite(clampChannel(interpolate(float64(*p.warmWhite[0]), float64(*p.warmWhite[1]), progress)))
+		}
+	}
+
+	if p.interpolateTemp {
+		builder.Temperature(int(math.Round(1e6 / interpolate(p.temperatureFrom, p.temperatureTo, progress))))
+	}
+
+	return builder
+}
+
+// interpolate return the value at the given progress (0-1) between from and to
+func interpolate(from, to, progress float64) float64 {
+	return from + (to-from)*progress
+}
+
+// intOr return the value of an optional field, or the fallback when it was not given
+func intOr(value *int, fallback int) int {
+	if value == nil {
+		return fallback
+	}
+	return *value
+}
+
+// isEmptyPilot return true when no field of the state is set
+func isEmptyPilot(state wizgotypes.PilotState) bool {
+	return state.State == nil && state.SceneId == nil && state.Speed == nil && state.Ratio == nil &&
+		state.R == nil && state.G == nil && state.B == nil && state.C == nil && state.W == nil &&
+		state.Temp == nil && state.Dimming == nil
+}
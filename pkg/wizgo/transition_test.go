@@ -0,0 +1,255 @@
+package wizgo
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	wizgotypes "github.com/achetronic/wizgo/api/types"
+	"github.com/achetronic/wizgo/pkg/emulator"
+)
+
+func TestTransition(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		initial *wizgotypes.PilotState // initial state of the device, nil to keep the one of the emulator
+		from    wizgotypes.PilotState
+		to      wizgotypes.PilotState
+
+		monotonic string                      // monotonic is a field expected to change in a single direction
+		wantFirst wizgotypes.WizMessageParams // wantFirst holds params expected on the first frame
+		wantLast  wizgotypes.WizMessageParams // wantLast holds the params expected on the last frame
+		wantFinal wizgotypes.PilotState       // wantFinal holds the fields expected on the device at the end
+	}{
+		{
+			name:      "brightness",
+			from:      wizgotypes.PilotState{State: boolPointer(true), Dimming: intPointer(100)},
+			to:        wizgotypes.PilotState{Dimming: intPointer(20)},
+			monotonic: "dimming",
+			wantLast:  wizgotypes.WizMessageParams{"dimming": 20.0},
+			wantFinal: wizgotypes.PilotState{State: boolPointer(true), Dimming: intPointer(20)},
+		},
+		{
+			name:      "white temperature",
+			from:      wizgotypes.PilotState{State: boolPointer(true), Temp: intPointer(2700), Dimming: intPointer(100)},
+			to:        wizgotypes.PilotState{Temp: intPointer(6500)},
+			monotonic: "temp",
+			wantLast:  wizgotypes.WizMessageParams{"temp": 6500.0},
+			wantFinal: wizgotypes.PilotState{Temp: intPointer(6500)},
+		},
+		{
+			name:      "color",
+			from:      wizgotypes.PilotState{State: boolPointer(true), R: intPointer(255), G: intPointer(0), B: intPointer(0)},
+			to:        wizgotypes.PilotState{R: intPointer(0), G: intPointer(0), B: intPointer(255)},
+			monotonic: "b",
+			wantLast:  wizgotypes.WizMessageParams{"r": 0.0, "g": 0.0, "b": 255.0},
+			wantFinal: wizgotypes.PilotState{R: intPointer(0), G: intPointer(0), B: intPointer(255)},
+		},
+		{
+			name:      "turning on from the current state",
+			initial:   &wizgotypes.PilotState{State: boolPointer(false)},
+			to:        wizgotypes.PilotState{State: boolPointer(true), Dimming: intPointer(60)},
+			monotonic: "dimming",
+			wantFirst: wizgotypes.WizMessageParams{"state": true},
+			wantLast:  wizgotypes.WizMessageParams{"state": true, "dimming": 60.0},
+			wantFinal: wizgotypes.PilotState{State: boolPointer(true), Dimming: intPointer(60)},
+		},
+		{
+			name:      "fading out keeps the brightness for the next time",
+			from:      wizgotypes.PilotState{State: boolPointer(true), Dimming: intPointer(80)},
+			to:        wizgotypes.PilotState{State: boolPointer(false)},
+			monotonic: "dimming",
+			wantLast:  wizgotypes.WizMessageParams{"state": false},
+			wantFinal: wizgotypes.PilotState{State: boolPointer(false)},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			device := startEmulator(t, emulator.Options{})
+			if test.initial != nil {
+				if err := device.SetPilotState(*test.initial); err != nil {
+					t.Fatalf("error setting the initial state: %s", err)
+				}
+			}
+			wizClient := createClient(t, device, WizClientOptions{TransitionInterval: 20 * time.Millisecond})
+
+			err := wizClient.Transition(context.Background(), test.from, test.to, 300*time.Millisecond, EaseInOut)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			frames := receivedMessages(device, "setPilot")
+			if len(frames) < 3 {
+				t.Fatalf("expected several frames, got %d", len(frames))
+			}
+
+			for field, value := range test.wantFirst {
+				if frames[0].Params[field] != value {
+					t.Errorf("expected '%s' to be %v on the first frame, got %v", field, value, frames[0].Params[field])
+				}
+			}
+
+			if last := frames[len(frames)-1].Params; !reflect.DeepEqual(last, test.wantLast) {
+				t.Errorf("expected %v on the last frame, got %v", test.wantLast, last)
+			}
+
+			// Frames may skip values, but never go back
+			direction := 0.0
+			previous, found := 0.0, false
+			for _, frame := range frames[:len(frames)-1] {
+				value, ok := frame.Params[test.monotonic].(float64)
+				if !ok {
+					continue
+				}
+				if found && direction == 0 {
+					direction = value - previous
+				}
+				if found && (value-previous)*direction < 0 {
+					t.Errorf("'%s' went back from %v to %v", test.monotonic, previous, value)
+				}
+				previous, found = value, true
+			}
+
+			final := device.PilotState()
+			for field, pair := range map[string][2]*int{
+				"dimming": {test.wantFinal.Dimming, final.Dimming},
+				"temp":    {test.wantFinal.Temp, final.Temp},
+				"r":       {test.wantFinal.R, final.R},
+				"g":       {test.wantFinal.G, final.G},
+				"b":       {test.wantFinal.B, final.B},
+			} {
+				if pair[0] != nil && (pair[1] == nil || *pair[0] != *pair[1]) {
+					t.Errorf("expected '%s' to end at %d, got %s", field, *pair[0], intText(pair[1]))
+				}
+			}
+			if test.wantFinal.State != nil && (final.State == nil || *final.State != *test.wantFinal.State) {
+				t.Errorf("expected the device to end with state %t", *test.wantFinal.State)
+			}
+		})
+	}
+}
+
+func TestTurnOnAfterFadeOut(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		between func(wizClient *WizClient) error // between changes the light after the fade out, if set
+
+		wantMethod  string                      // wantMethod is the method sent by TurnOn
+		wantParams  wizgotypes.WizMessageParams // wantParams holds the params sent by TurnOn
+		wantDimming int                         // wantDimming is the brightness expected on the device at the end
+	}{
+		{
+			name:        "brightness restored",
+			wantMethod:  "setPilot",
+			wantParams:  wizgotypes.WizMessageParams{"state": true, "dimming": 80.0},
+			wantDimming: 80,
+		},
+		{
+			name: "brightness restored after turning off again",
+			between: func(wizClient *WizClient) error {
+				_, err := wizClient.TurnOff()
+				return err
+			},
+			wantMethod:  "setPilot",
+			wantParams:  wizgotypes.WizMessageParams{"state": true, "dimming": 80.0},
+			wantDimming: 80,
+		},
+		{
+			name: "brightness forgotten after another change",
+			between: func(wizClient *WizClient) error {
+				_, err := wizClient.SetBrightness(30)
+				return err
+			},
+			wantMethod:  "setState",
+			wantParams:  wizgotypes.WizMessageParams{"state": true},
+			wantDimming: 30,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			device := startEmulator(t, emulator.Options{})
+			wizClient := createClient(t, device, WizClientOptions{TransitionInterval: 20 * time.Millisecond})
+
+			from := wizgotypes.PilotState{State: boolPointer(true), Dimming: intPointer(80)}
+			to := wizgotypes.PilotState{State: boolPointer(false)}
+			if err := wizClient.Transition(context.Background(), from, to, 100*time.Millisecond, nil); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if test.between != nil {
+				if err := test.between(wizClient); err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+			}
+
+			sent := len(device.Messages())
+			if _, err := wizClient.TurnOn(); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			messages := device.Messages()[sent:]
+			if len(messages) != 1 {
+				t.Fatalf("expected a single message to turn on, got %d", len(messages))
+			}
+			if messages[0].Method != test.wantMethod || !reflect.DeepEqual(messages[0].Params, test.wantParams) {
+				t.Errorf("expected '%s' with %v, got '%s' with %v", test.wantMethod, test.wantParams, messages[0].Method, messages[0].Params)
+			}
+
+			final := device.PilotState()
+			if final.State == nil || !*final.State || final.Dimming == nil || *final.Dimming != test.wantDimming {
+				t.Errorf("expected the device on at %d, got state %v and dimming %s", test.wantDimming, final.State, intText(final.Dimming))
+			}
+		})
+	}
+}
+
+func TestTransitionInterrupted(t *testing.T) {
+
+	device := startEmulator(t, emulator.Options{})
+	wizClient := createClient(t, device, WizClientOptions{TransitionInterval: 20 * time.Millisecond})
+
+	from := wizgotypes.PilotState{State: boolPointer(true), Dimming: intPointer(100)}
+
+	interrupted := make(chan error, 1)
+	go func() {
+		interrupted <- wizClient.Transition(context.Background(), from, wizgotypes.PilotState{Dimming: intPointer(10)}, time.Second, nil)
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+
+	err := wizClient.Transition(context.Background(), from, wizgotypes.PilotState{Dimming: intPointer(50)}, 100*time.Millisecond, nil)
+	if err != nil {
+		t.Fatalf("unexpected error on the second transition: %s", err)
+	}
+
+	if err = <-interrupted; !errors.Is(err, ErrTransitionInterrupted) {
+		t.Errorf("expected the first transition interrupted, got: %v", err)
+	}
+
+	if dimming := device.PilotState().Dimming; dimming == nil || *dimming != 50 {
+		t.Errorf("expected the second transition to win, got dimming %s", intText(dimming))
+	}
+}
+
+func TestTransitionInvalidTarget(t *testing.T) {
+
+	device := startEmulator(t, emulator.Options{})
+	wizClient := createClient(t, device, WizClientOptions{TransitionInterval: 20 * time.Millisecond})
+
+	to := wizgotypes.PilotState{Dimming: intPointer(5)}
+
+	var rangeErr *RangeError
+	if err := wizClient.Transition(context.Background(), wizgotypes.PilotState{}, to, 100*time.Millisecond, nil); !errors.As(err, &rangeErr) {
+		t.Fatalf("expected a RangeError, got: %v", err)
+	}
+
+	if frames := receivedMessages(device, "setPilot"); len(frames) > 0 {
+		t.Errorf("expected no frame sent, got %d", len(frames))
+	}
+}
@@ -0,0 +1,146 @@
+package wizgo
+
+import (
+	"context"
+	"errors"
+)
+
+const (
+	// Ranges accepted by the devices for each parameter
+	MinBrightness  = 10
+	MaxBrightness  = 100
+	MinLed         = 0
+	MaxLed         = 255
+	MinTemperature = 2000 // MinTemperature is used when the device does not advertise its own range
+	MaxTemperature = 9000 // MaxTemperature is used when the device does not advertise its own range
+	MinSpeed       = 10
+	MaxSpeed       = 200
+	MinRatio       = 1
+	MaxRatio       = 100
+)
+
+// validateRange checks the value of the field is in range [min, max]
+func validateRange(field string, min, max, value int) error {
+	if value < min || value > max {
+		return &RangeError{Field: field, Min: min, Max: max, Got: value}
+	}
+	return nil
+}
+
+// validateBrightness checks the brightness is in range (10-100)
+func validateBrightness(brightness int) error {
+	return validateRange("dimming", MinBrightness, MaxBrightness, brightness)
+}
+
+// validateLed checks the level of a single LED is in range (0-255)
+func validateLed(field string, level int) error {
+	return validateRange(field, MinLed, MaxLed, level)
+}
+
+// validateRgb checks the levels of the color LEDs are in range (3 x 0-255)
+func validateRgb(r, g, b int) error {
+	return errors.Join(validateLed("r", r), validateLed("g", g), validateLed("b", b))
+}
+
+// validateTemperature checks the color temperature is in the widest range supported by devices (2000-9000 kelvin)
+func validateTemperature(temperature int) error {
+	return validateRange("temp", MinTemperature, MaxTemperature, temperature)
+}
+
+// validateSpeed checks the effect changing speed is in range (10-200)
+func validateSpeed(speed int) error {
+	return validateRange("speed", MinSpeed, MaxSpeed, speed)
+}
+
+// validateRatio checks the ratio between the up and down light is in range (1-100)
+func validateRatio(ratio int) error {
+	return validateRange("ratio", MinRatio, MaxRatio, ratio)
+}
+
+// validateDeviceTemperature checks the color temperature is in the range advertised by the device
+func (w *WizClient) validateDeviceTemperature(ctx context.Context, temperature int) error {
+
+	// Devices whose capabilities are unknown yet get the benefit of the doubt.
+	// They are fetched once, so devices not answering do not wait through two timeouts
+	capabilities, err := w.CapabilitiesContext(ctx)
+	if err == nil && !capabilities.ColorTemperature {
+		return w.notSupportedError("color temperature")
+	}
+
+	min, max := temperatureRange(capabilities, err)
+	return validateRange("temp", min, max, temperature)
+}
+
+// TemperatureRange return the white temperature range (kelvin) advertised by the device,
+// or the default range when the device does not advertise it
+func (w *WizClient) TemperatureRange() (min, max int) {
+	return w.TemperatureRangeContext(context.Background())
+}
+
+// TemperatureRangeContext is like TemperatureRange but honours the deadline and cancellation of the given context
+func (w *WizClient) TemperatureRangeContext(ctx context.Context) (min, max int) {
+
+	capabilities, err := w.CapabilitiesContext(ctx)
+	return temperatureRange(capabilities, err)
+}
+
+// temperatureRange return the white temperature range in the capabilities,
+// or the default range when they could not be fetched or do not advertise it
+func temperatureRange(capabilities Capabilities, err error) (min, max int) {
+	if err != nil || capabilities.KelvinMax == 0 {
+		return MinTemperature, MaxTemperature
+	}
+
+	return capabilities.KelvinMin, capabilities.KelvinMax
+}
+
+// CheckPilot checks the device can apply the changes composed in the builder, according to its capabilities,
+// without sending them. Devices whose module name is unknown get the benefit of the doubt
+func (w *WizClient) CheckPilot(builder *PilotBuilder) error {
+	return w.CheckPilotContext(context.Background(), builder)
+}
+
+// CheckPilotContext is like CheckPilot but honours the deadline and cancellation of the given context
+func (w *WizClient) CheckPilotContext(ctx context.Context, builder *PilotBuilder) error {
+
+	params, err := builder.Params()
+	if err != nil {
+		return err
+	}
+
+	capabilities, err := w.CapabilitiesContext(ctx)
+	if err != nil {
+		return wrapError(DeviceTypeNotFoundErrorMessage, err)
+	}
+
+	features := []struct {
+		fields    []string
+		name      string
+		supported bool
+	}{
+		{fields: []string{"r", "g", "b"}, name: "color", supported: capabilities.Color},
+		{fields: []string{"c", "w"}, name: "white LEDs", supported: capabilities.ColorTemperature},
+		{fields: []string{"dimming"}, name: "brightness", supported: capabilities.Brightness},
+		{fields: []string{"ratio"}, name: "ratio", supported: capabilities.DualHead},
+	}
+
+	var errs []error
+	for _, feature := range features {
+		for _, field := range feature.fields {
+			if _, found := params[field]; found && !feature.supported {
+				errs = append(errs, w.notSupportedError(feature.name))
+				break
+			}
+		}
+	}
+
+	if temperature, found := params["temp"]; found {
+		errs = append(errs, w.validateDeviceTemperature(ctx, temperature.(int)))
+	}
+
+	if sceneId, found := params["sceneId"]; found {
+		errs = append(errs, w.checkSceneAvailable(ctx, sceneId.(int)))
+	}
+
+	return errors.Join(errs...)
+}
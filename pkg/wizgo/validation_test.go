@@ -0,0 +1,168 @@
+package wizgo
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/achetronic/wizgo/pkg/emulator"
+)
+
+// unknownModel emulates a device whose module name can not be parsed
+var unknownModel = emulator.Model{ModuleName: "WIZBULB", Color: true, ColorTemperature: true, Brightness: true, Effects: true}
+
+func TestValidation(t *testing.T) {
+
+	tests := []struct {
+		name             string
+		model            emulator.Model
+		noModelConfig    bool // noModelConfig emulates old firmwares, which do not advertise the temperature range
+		request          func(ctx context.Context, wizClient *WizClient) error
+		wantRange        *RangeError
+		wantNotSupported bool
+		wantSent         bool // wantSent is set when the change is valid and reaches the device
+	}{
+		{
+			name: "brightness",
+			request: func(ctx context.Context, wizClient *WizClient) error {
+				_, err := wizClient.SetBrightnessContext(ctx, 5)
+				return err
+			},
+			wantRange: &RangeError{Field: "dimming", Min: MinBrightness, Max: MaxBrightness, Got: 5},
+		},
+		{
+			name: "color",
+			request: func(ctx context.Context, wizClient *WizClient) error {
+				_, err := wizClient.SetRgbContext(ctx, 0, 300, 0)
+				return err
+			},
+			wantRange: &RangeError{Field: "g", Min: MinLed, Max: MaxLed, Got: 300},
+		},
+		{
+			name: "speed",
+			request: func(ctx context.Context, wizClient *WizClient) error {
+				_, err := wizClient.SetSpeedContext(ctx, 500)
+				return err
+			},
+			wantRange: &RangeError{Field: "speed", Min: MinSpeed, Max: MaxSpeed, Got: 500},
+		},
+		{
+			name: "temperature advertised by a RGB device",
+			request: func(ctx context.Context, wizClient *WizClient) error {
+				_, err := wizClient.SetTemperatureContext(ctx, 7000)
+				return err
+			},
+			wantRange: &RangeError{Field: "temp", Min: 2200, Max: 6500, Got: 7000},
+		},
+		{
+			name:  "temperature advertised by a TW device",
+			model: emulator.ModelTw,
+			request: func(ctx context.Context, wizClient *WizClient) error {
+				_, err := wizClient.SetPilotContext(ctx, CreatePilotBuilder().Temperature(2500))
+				return err
+			},
+			wantRange: &RangeError{Field: "temp", Min: 2700, Max: 6500, Got: 2500},
+		},
+		{
+			name:          "temperature not advertised",
+			noModelConfig: true,
+			request: func(ctx context.Context, wizClient *WizClient) error {
+				_, err := wizClient.SetTemperatureContext(ctx, 9500)
+				return err
+			},
+			wantRange: &RangeError{Field: "temp", Min: MinTemperature, Max: MaxTemperature, Got: 9500},
+		},
+		{
+			name:  "temperature on a DW device",
+			model: emulator.ModelDw,
+			request: func(ctx context.Context, wizClient *WizClient) error {
+				_, err := wizClient.SetTemperatureContext(ctx, 3000)
+				return err
+			},
+			wantNotSupported: true,
+		},
+		{
+			name:  "scene on a socket",
+			model: emulator.ModelSocket,
+			request: func(ctx context.Context, wizClient *WizClient) error {
+				_, err := wizClient.SetSceneContext(ctx, 1)
+				return err
+			},
+			wantNotSupported: true,
+		},
+		{
+			name:  "color on a TW device",
+			model: emulator.ModelTw,
+			request: func(ctx context.Context, wizClient *WizClient) error {
+				return wizClient.CheckPilotContext(ctx, CreatePilotBuilder().Rgb(255, 0, 0))
+			},
+			wantNotSupported: true,
+		},
+		{
+			name:  "temperature on an unknown device",
+			model: unknownModel,
+			request: func(ctx context.Context, wizClient *WizClient) error {
+				_, err := wizClient.SetTemperatureContext(ctx, 3000)
+				return err
+			},
+			wantSent: true,
+		},
+		{
+			name:  "temperature out of the default range on an unknown device",
+			model: unknownModel,
+			request: func(ctx context.Context, wizClient *WizClient) error {
+				_, err := wizClient.SetTemperatureContext(ctx, 9500)
+				return err
+			},
+			wantRange: &RangeError{Field: "temp", Min: MinTemperature, Max: MaxTemperature, Got: 9500},
+		},
+		{
+			name:  "checked pilot on an unknown device",
+			model: unknownModel,
+			request: func(ctx context.Context, wizClient *WizClient) error {
+				builder := CreatePilotBuilder().Temperature(3000).Brightness(50)
+				if err := wizClient.CheckPilotContext(ctx, builder); err != nil {
+					return err
+				}
+				_, err := wizClient.SetPilotContext(ctx, builder)
+				return err
+			},
+			wantSent: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			device := startEmulator(t, emulator.Options{Model: test.model})
+			if test.noModelConfig {
+				device.InjectError("getModelConfig", emulator.MethodNotFoundCode)
+			}
+			wizClient := createClient(t, device, WizClientOptions{})
+
+			err := test.request(context.Background(), wizClient)
+
+			if test.wantRange != nil {
+				var rangeErr *RangeError
+				if !errors.As(err, &rangeErr) {
+					t.Fatalf("expected a RangeError, got: %v", err)
+				}
+				if *rangeErr != *test.wantRange {
+					t.Errorf("expected %+v, got %+v", *test.wantRange, *rangeErr)
+				}
+			}
+
+			if test.wantNotSupported && !errors.Is(err, ErrNotSupported) {
+				t.Errorf("expected an error matching ErrNotSupported, got: %v", err)
+			}
+
+			if test.wantSent && err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+
+			// Invalid changes never reach the device
+			if sent := len(receivedMessages(device, "setPilot")) > 0; sent != test.wantSent {
+				t.Errorf("expected the 'setPilot' message sent to be %t", test.wantSent)
+			}
+		})
+	}
+}
@@ -1,14 +1,16 @@
 package wizgo
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"golang.org/x/exp/maps"
 	"net"
 	"slices"
 	"strconv"
-	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	wizgotypes "github.com/achetronic/wizgo/api/types"
 )
@@ -58,10 +60,21 @@ var (
 
 	// DW - have only Dimmable white LEDs. Such devices support only dimming, Wake up, Bedtime and Night light modes.
 	WizDwScenes = []int{9, 10, 13, 14, 29, 30, 31, 32}
+
+	// WizNonIdempotentMethods lists the methods that are never re-sent, as repeating them changes the outcome
+	WizNonIdempotentMethods = []string{"pulse"}
 )
 
 const (
+	// DefaultPort is the UDP port where WiZ devices listen for commands
+	DefaultPort = 38899
+
+	// DefaultTimeout bounds the requests whose context carries no deadline
+	DefaultTimeout = 3 * time.Second
+
 	// Info messages
+	//
+	// Deprecated: the range messages below are not returned anymore. Range checks return a RangeError instead
 	BrithnessRangeMessage   = "brightness must be between 10 and 100"
 	LedRangeMessage         = "LED colors must be between 0 and 255"
 	TemperatureRangeMessage = "temperature value must be between 2000 and 9000 (kelvin)"
@@ -74,17 +87,112 @@ const (
 	SystemConfigNotAvailableErrorMessage = "error getting system config: %s"
 	DeviceTypeNotFoundErrorMessage       = "error figuring out device type: %s"
 	SceneNotAvailableErrorMessage        = "scene not available: %s"
+	RequestTimeoutErrorMessage           = "timeout waiting for '%s' response from %s"
+	ClientClosedErrorMessage             = "client is closed"
+	ResultNotFoundErrorMessage           = "response to '%s' carries no result"
+	IncompatiblePilotErrorMessage        = "pilot fields '%s' and '%s' can not be set together"
+	PilotWithoutClientErrorMessage       = "pilot is not bound to any client"
+	DeviceErrorMessage                   = "device %s answered '%s' with error %d: %s"
+	NotSupportedErrorMessage             = "%s is not supported by device %s"
+	RangeErrorMessage                    = "%s must be between %d and %d, got %d"
+	EmptyPilotErrorMessage               = "pilot has nothing to set"
+	IncompleteRgbErrorMessage            = "pilot fields 'r', 'g' and 'b' must be set together"
 )
 
+// WizClient represents a connection to a single WiZ device.
+// A WizClient is safe for concurrent use by multiple goroutines: each message carries its own id
+// and the answers are handed to the waiting caller by a background reader, so they are never mixed up.
+// Copies of a WizClient share the same connection
 type WizClient struct {
+	*wizConnection
+}
+
+// wizConnection holds the state shared by all the copies of a WizClient
+type wizConnection struct {
+	address          string
 	deviceConnection *net.UDPConn
+
+	// connectionMutex guards the address and the connection, which change when the device gets another IP
+	connectionMutex sync.RWMutex
+
+	// timeout bounds each request when the caller's context has no deadline
+	timeout time.Duration
+
+	// retryPolicy defines how lost datagrams are re-sent
+	retryPolicy RetryPolicy
+
+	// observer receives the outcome of every request, when set
+	observer func(observation RequestObservation)
+
+	// transitionInterval is the time between the frames of a transition
+	transitionInterval time.Duration
+
+	// cancelTransition interrupts the running transition, so only one runs at a time
+	cancelTransition      context.CancelCauseFunc
+	transitionId          int
+	cancelTransitionMutex sync.Mutex
+
+	// dimmingOnTurnOn is the brightness a fade out started at, given back on the next TurnOn. Zero for none
+	dimmingOnTurnOn atomic.Int64
+
+	// lastMessageId is the id given to the last message sent. Increased on each message
+	lastMessageId atomic.Int64
+
+	// pendingRequests holds the requests waiting for an answer, indexed by message id
+	pendingRequests      map[int]*pendingRequest
+	pendingRequestsMutex sync.Mutex
+
+	// capabilities caches what the device supports, as it does not change over time.
+	// capabilitiesFetch is the fetch in flight, so concurrent callers wait for the same one
+	capabilities      *Capabilities
+	capabilitiesFetch *capabilitiesFetch
+	capabilitiesMutex sync.Mutex
+
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// pendingRequest represents a message waiting for its answer
+type pendingRequest struct {
+	method string
+	answer chan []byte
+}
+
+// WizClientOptions represents the optional settings used when creating a WizClient
+type WizClientOptions struct {
+	// Timeout bounds each request when the given context carries no deadline. Zero means DefaultTimeout
+	Timeout time.Duration
+
+	// RetryPolicy defines how lost datagrams are re-sent. Zero value means DefaultRetryPolicy
+	RetryPolicy RetryPolicy
+
+	// TransitionInterval is the time between the frames sent during a Transition. Zero means DefaultTransitionInterval
+	TransitionInterval time.Duration
+
+	// Observer is called after every request with its outcome, so latencies and errors can be measured.
+	// It is called from the goroutine making the request, so it should return quickly
+	Observer func(observation RequestObservation)
+}
+
+// RequestObservation represents the outcome of a request, as given to WizClientOptions.Observer
+type RequestObservation struct {
+	Address  string        // Address of the device
+	Method   string        // Method of the message sent
+	Duration time.Duration // Duration of the request, including the retransmissions
+	Err      error         // Err is not nil when the request failed
 }
 
 // Thanks to project PyWizLights for some of the reverse engineering they already did previously than me
 // Ref: https://github.com/sbidy/pywizlight
 
-// TODO
+// CreateWizClient creates a client to interact with the device listening on host:port using default options
 func CreateWizClient(host string, port int) (wizClient WizClient, err error) {
+	return CreateWizClientWithOptions(host, port, WizClientOptions{})
+}
+
+// CreateWizClientWithOptions creates a client to interact with the device listening on host:port.
+// The client must be closed with Close when it is not needed anymore
+func CreateWizClientWithOptions(host string, port int, options WizClientOptions) (wizClient WizClient, err error) {
 
 	// Resolve the address for the given backend
 	address, err := net.ResolveUDPAddr("udp", net.JoinHostPort(host, strconv.Itoa(port)))
@@ -97,114 +205,442 @@ func CreateWizClient(host string, port int) (wizClient WizClient, err error) {
 	if err != nil {
 		return wizClient, err
 	}
-	//defer deviceConn.Close()
 
-	wizClient.deviceConnection = deviceConn
+	wizClient.wizConnection = &wizConnection{
+		address:          address.String(),
+		deviceConnection: deviceConn,
+		timeout:          options.Timeout,
+		retryPolicy:      options.RetryPolicy,
+		observer:         options.Observer,
+
+		transitionInterval: options.TransitionInterval,
+		pendingRequests:    map[int]*pendingRequest{},
+		closed:             make(chan struct{}),
+	}
+
+	if wizClient.timeout <= 0 {
+		wizClient.timeout = DefaultTimeout
+	}
+
+	if wizClient.retryPolicy.Attempts <= 0 {
+		wizClient.retryPolicy = DefaultRetryPolicy
+	}
+
+	if wizClient.transitionInterval <= 0 {
+		wizClient.transitionInterval = DefaultTransitionInterval
+	}
+
+	go wizClient.readResponses(deviceConn)
+
 	return wizClient, err
 }
 
-// In general, send datagrams to the device
-func (w *WizClient) sendDatagrams(content []byte) (response []byte, err error) {
+// Address return the address of the device in host:port form
+func (w *WizClient) Address() string {
 
-	// Send datagrams to the device
-	_, err = w.deviceConnection.Write(content)
+	w.connectionMutex.RLock()
+	defer w.connectionMutex.RUnlock()
+
+	return w.address
+}
+
+// LocalIp return the local IP used to reach the device
+func (w *WizClient) LocalIp() string {
+
+	w.connectionMutex.RLock()
+	defer w.connectionMutex.RUnlock()
+
+	return w.deviceConnection.LocalAddr().(*net.UDPAddr).IP.String()
+}
+
+// redirect points the client to the device listening on host:port. Requests waiting for an answer
+// are kept, so the holders of the client keep working when DHCP gives the device another IP
+func (w *WizClient) redirect(host string, port int) (err error) {
+
+	address, err := net.ResolveUDPAddr("udp", net.JoinHostPort(host, strconv.Itoa(port)))
+	if err != nil {
+		return err
+	}
+
+	deviceConn, err := net.DialUDP("udp", nil, address)
+	if err != nil {
+		return err
+	}
+
+	w.connectionMutex.Lock()
+	select {
+	case <-w.closed:
+		w.connectionMutex.Unlock()
+		_ = deviceConn.Close()
+		return ErrClientClosed
+	default:
+	}
+
+	previousConn := w.deviceConnection
+	w.address, w.deviceConnection = address.String(), deviceConn
+	w.connectionMutex.Unlock()
+
+	go w.readResponses(deviceConn)
+
+	return previousConn.Close()
+}
+
+// Timeout return the default timeout applied to requests whose context carries no deadline
+func (w *WizClient) Timeout() time.Duration {
+	return w.timeout
+}
+
+// Close closes the connection with the device. Requests waiting for an answer fail with ErrClientClosed
+func (w *WizClient) Close() (err error) {
+	err = ErrClientClosed
+	w.closeOnce.Do(func() {
+		w.connectionMutex.Lock()
+		defer w.connectionMutex.Unlock()
+
+		close(w.closed)
+		err = w.deviceConnection.Close()
+	})
+	return err
+}
+
+// withTimeout bounds the given context with the client's default timeout when it has no deadline yet
+func (w *WizClient) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, hasDeadline := ctx.Deadline(); hasDeadline {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, w.timeout)
+}
+
+// readResponses reads the answers coming from the device through the given connection and hands each one
+// to the request waiting for it. It runs in background until the connection is closed
+func (w *WizClient) readResponses(deviceConn *net.UDPConn) {
+
+	// Prepare a buffer to receive responses
+	buffer := make([]byte, 4096)
+
+	for {
+		n, err := deviceConn.Read(buffer)
+		if err != nil {
+			select {
+			case <-w.closed:
+				return
+			default:
+			}
+
+			// The connection is gone for reasons other than closing the client
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+
+			// Errors such as ICMP 'port unreachable' are transient for a connectionless protocol
+			continue
+		}
+
+		var response wizgotypes.WizMessageResponse
+		if json.Unmarshal(buffer[:n], &response) != nil {
+			continue
+		}
+
+		w.dispatchResponse(response, append([]byte(nil), buffer[:n]...))
+	}
+}
+
+// dispatchResponse hands the answer to the request waiting for it. Answers are matched by id and method.
+// Some firmwares do not echo the id, so those answers are given to the oldest request with the same method
+func (w *WizClient) dispatchResponse(response wizgotypes.WizMessageResponse, content []byte) {
+
+	w.pendingRequestsMutex.Lock()
+	defer w.pendingRequestsMutex.Unlock()
+
+	requestId := 0
+	if request, ok := w.pendingRequests[response.Id]; ok && request.method == response.Method {
+		requestId = response.Id
+	}
+
+	// Answers carrying an unknown id are late duplicates of requests already satisfied,
+	// and giving them to another request would report its own lost datagram as answered
+	if response.Id == 0 {
+		for id, request := range w.pendingRequests {
+			if request.method == response.Method && (requestId == 0 || id < requestId) {
+				requestId = id
+			}
+		}
+	}
+
+	// Late answers to requests already satisfied are discarded
+	request, ok := w.pendingRequests[requestId]
+	if !ok {
+		return
+	}
+
+	delete(w.pendingRequests, requestId)
+	request.answer <- content
+}
+
+// exchange sends the message to the device and waits for its answer,
+// re-sending the same message following the retry policy when the answer does not arrive
+func (w *WizClient) exchange(ctx context.Context, message wizgotypes.WizMessage) (response []byte, err error) {
+
+	ctx, cancel := w.withTimeout(ctx)
+	defer cancel()
+
+	message.Id = int(w.lastMessageId.Add(1))
+
+	content, err := json.Marshal(message)
 	if err != nil {
-		err = errors.New(fmt.Sprintf(ErrorSendingDataErrorMessage, err))
 		return response, err
 	}
 
-	// Prepare a buffer to receive response
-	buffer := make([]byte, 1024)
+	// Register the request before sending anything, so the answer is never missed
+	request := &pendingRequest{method: message.Method, answer: make(chan []byte, 1)}
 
-	// Wait to receive the answer from device
-	n, addr, err := w.deviceConnection.ReadFromUDP(buffer)
+	w.pendingRequestsMutex.Lock()
+	w.pendingRequests[message.Id] = request
+	w.pendingRequestsMutex.Unlock()
+
+	defer func() {
+		w.pendingRequestsMutex.Lock()
+		delete(w.pendingRequests, message.Id)
+		w.pendingRequestsMutex.Unlock()
+	}()
+
+	attempts := w.retryPolicy.Attempts
+	if slices.Contains(WizNonIdempotentMethods, message.Method) {
+		attempts = 1
+	}
+
+	for attempt := 1; ; attempt++ {
+		response, err = w.sendDatagrams(ctx, request, content)
+
+		// Only lost datagrams are worth another attempt
+		var timeoutErr *TimeoutError
+		if err == nil || !errors.As(err, &timeoutErr) || attempt >= attempts {
+			return response, err
+		}
+
+		select {
+		case <-time.After(w.retryPolicy.backoff(attempt)):
+		case <-ctx.Done():
+			return response, err
+		}
+	}
+}
+
+// In general, send datagrams to the device and wait for the answer to the given request
+func (w *WizClient) sendDatagrams(ctx context.Context, request *pendingRequest, content []byte) (response []byte, err error) {
+
+	// Bound this attempt with its own timeout when the policy defines it
+	if w.retryPolicy.AttemptTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, w.retryPolicy.AttemptTimeout)
+		defer cancel()
+	}
+
+	w.connectionMutex.RLock()
+	deviceConn := w.deviceConnection
+	w.connectionMutex.RUnlock()
+
+	// Send datagrams to the device
+	_, err = deviceConn.Write(content)
 	if err != nil {
-		err = errors.New(fmt.Sprintf(ErrorReceivingResponseErrorMessage, err))
+		select {
+		case <-w.closed:
+			return response, ErrClientClosed
+		default:
+		}
+
+		err = wrapError(ErrorSendingDataErrorMessage, err)
 		return response, err
 	}
 
-	response = buffer[:n]
-	_ = addr
+	// Wait to receive the answer from device
+	select {
+	case response = <-request.answer:
+		return response, nil
 
-	return response, err
+	case <-w.closed:
+		return response, ErrClientClosed
+
+	case <-ctx.Done():
+
+		// The caller gave up before the device answered
+		if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return response, ctx.Err()
+		}
+
+		return response, &TimeoutError{Address: w.Address(), Method: request.method, Err: ctx.Err()}
+	}
 }
 
 // sendMessage sends a WiZ message over UDP and returns the response already parsed
-func (w *WizClient) sendMessage(message wizgotypes.WizMessage) (response wizgotypes.WizMessageResponse, err error) {
+func (w *WizClient) sendMessage(ctx context.Context, message wizgotypes.WizMessage) (response wizgotypes.WizMessageResponse, err error) {
 
-	// TODO
-	jsonMessage, err := json.Marshal(message)
+	defer w.observe(message.Method, time.Now(), &err)
+
+	w.forgetDimmingOnTurnOn(message)
+
+	responseBytes, err := w.exchange(ctx, message)
 	if err != nil {
 		return response, err
 	}
 
-	// TODO
-	responseBytes, err := w.sendDatagrams(jsonMessage)
+	err = json.Unmarshal(responseBytes, &response)
 	if err != nil {
 		return response, err
 	}
 
-	err = json.Unmarshal(responseBytes, &response)
-	return response, err
+	return response, w.deviceError(message, response.Error)
+}
+
+// forgetDimmingOnTurnOn drops the brightness pending for the next TurnOn when the light is changed in any other way.
+// Plain turn offs keep it, as the device stays off
+func (w *WizClient) forgetDimmingOnTurnOn(message wizgotypes.WizMessage) {
+	if message.Method != "setPilot" && message.Method != "setState" {
+		return
+	}
+	if state, found := message.Params["state"]; found && state == false && len(message.Params) == 1 {
+		return
+	}
+	w.dimmingOnTurnOn.Store(0)
+}
+
+// observe gives the outcome of a request to the observer, when there is one
+func (w *WizClient) observe(method string, start time.Time, err *error) {
+	if w.observer == nil {
+		return
+	}
+
+	w.observer(RequestObservation{
+		Address:  w.Address(),
+		Method:   method,
+		Duration: time.Since(start),
+		Err:      *err,
+	})
+}
+
+// deviceError return the error answered by the device, if any, as a DeviceError
+func (w *WizClient) deviceError(message wizgotypes.WizMessage, responseError wizgotypes.WizMessageError) error {
+	if responseError.Code == 0 {
+		return nil
+	}
+
+	return &DeviceError{
+		Code:    responseError.Code,
+		Message: responseError.Message,
+		Method:  message.Method,
+		Address: w.Address(),
+	}
+}
+
+// sendTypedMessage sends a WiZ message over UDP and parses the result of the response into the given value
+func (w *WizClient) sendTypedMessage(ctx context.Context, message wizgotypes.WizMessage, result interface{}) (err error) {
+
+	defer w.observe(message.Method, time.Now(), &err)
+
+	responseBytes, err := w.exchange(ctx, message)
+	if err != nil {
+		return err
+	}
+
+	envelope := struct {
+		Result json.RawMessage            `json:"result"`
+		Error  wizgotypes.WizMessageError `json:"error"`
+	}{}
+
+	err = json.Unmarshal(responseBytes, &envelope)
+	if err != nil {
+		return err
+	}
+
+	err = w.deviceError(message, envelope.Error)
+	if err != nil {
+		return err
+	}
+
+	if len(envelope.Result) == 0 {
+		return errors.New(fmt.Sprintf(ResultNotFoundErrorMessage, message.Method))
+	}
+
+	return json.Unmarshal(envelope.Result, result)
 }
 
 // GetPilot return the current status for colors, temperature, scenes, etc
 func (w *WizClient) GetPilot() (response wizgotypes.WizMessageResponse, err error) {
+	return w.GetPilotContext(context.Background())
+}
+
+// GetPilotContext is like GetPilot but honours the deadline and cancellation of the given context
+func (w *WizClient) GetPilotContext(ctx context.Context) (response wizgotypes.WizMessageResponse, err error) {
 
 	wizMessage := wizgotypes.WizMessage{
-		Id:     1,
 		Method: "getPilot",
 	}
 
-	response, err = w.sendMessage(wizMessage)
+	response, err = w.sendMessage(ctx, wizMessage)
 	return response, err
 }
 
 // GetSystemConfig return current configuration related to the system
 func (w *WizClient) GetSystemConfig() (response wizgotypes.WizMessageResponse, err error) {
+	return w.GetSystemConfigContext(context.Background())
+}
+
+// GetSystemConfigContext is like GetSystemConfig but honours the deadline and cancellation of the given context
+func (w *WizClient) GetSystemConfigContext(ctx context.Context) (response wizgotypes.WizMessageResponse, err error) {
 
 	wizMessage := wizgotypes.WizMessage{
-		Id:     1,
 		Method: "getSystemConfig",
 	}
 
-	response, err = w.sendMessage(wizMessage)
+	response, err = w.sendMessage(ctx, wizMessage)
 	return response, err
 }
 
 // GetUserConfig return current configuration related to the user
 func (w *WizClient) GetUserConfig() (response wizgotypes.WizMessageResponse, err error) {
+	return w.GetUserConfigContext(context.Background())
+}
+
+// GetUserConfigContext is like GetUserConfig but honours the deadline and cancellation of the given context
+func (w *WizClient) GetUserConfigContext(ctx context.Context) (response wizgotypes.WizMessageResponse, err error) {
 
 	wizMessage := wizgotypes.WizMessage{
-		Id:     1,
 		Method: "getUserConfig",
 	}
 
-	response, err = w.sendMessage(wizMessage)
+	response, err = w.sendMessage(ctx, wizMessage)
 	return response, err
 }
 
 // GetModelConfig return current configuration related to the device model
 func (w *WizClient) GetModelConfig() (response wizgotypes.WizMessageResponse, err error) {
+	return w.GetModelConfigContext(context.Background())
+}
+
+// GetModelConfigContext is like GetModelConfig but honours the deadline and cancellation of the given context
+func (w *WizClient) GetModelConfigContext(ctx context.Context) (response wizgotypes.WizMessageResponse, err error) {
 
 	wizMessage := wizgotypes.WizMessage{
-		Id:     1,
 		Method: "getModelConfig",
 	}
 
-	response, err = w.sendMessage(wizMessage)
+	response, err = w.sendMessage(ctx, wizMessage)
 	return response, err
 }
 
 // GetDevInfo return current configuration related to the device
 func (w *WizClient) GetDevInfo() (response wizgotypes.WizMessageResponse, err error) {
+	return w.GetDevInfoContext(context.Background())
+}
+
+// GetDevInfoContext is like GetDevInfo but honours the deadline and cancellation of the given context
+func (w *WizClient) GetDevInfoContext(ctx context.Context) (response wizgotypes.WizMessageResponse, err error) {
 
 	wizMessage := wizgotypes.WizMessage{
-		Id:     1,
 		Method: "getDevInfo",
 	}
 
-	response, err = w.sendMessage(wizMessage)
+	response, err = w.sendMessage(ctx, wizMessage)
 	return response, err
 }
 
@@ -213,9 +649,13 @@ func (w *WizClient) GetDevInfo() (response wizgotypes.WizMessageResponse, err er
 // After registering, you will receive on port 38900/udp of registered device, several messages like the following:
 // {"method":"syncPilot","env":"pro","params":{"mac":"ABCABCABC","rssi":-71,"src":"udp","state":true,"sceneId":0,"temp":6500,"dimming":62 ··· }}
 func (w *WizClient) Registration(phoneIp string, phoneMac string, register bool) (response wizgotypes.WizMessageResponse, err error) {
+	return w.RegistrationContext(context.Background(), phoneIp, phoneMac, register)
+}
+
+// RegistrationContext is like Registration but honours the deadline and cancellation of the given context
+func (w *WizClient) RegistrationContext(ctx context.Context, phoneIp string, phoneMac string, register bool) (response wizgotypes.WizMessageResponse, err error) {
 
 	wizMessage := wizgotypes.WizMessage{
-		Id:     1,
 		Method: "registration",
 		Params: wizgotypes.WizMessageParams{
 			"phoneIp":  phoneIp,
@@ -224,15 +664,19 @@ func (w *WizClient) Registration(phoneIp string, phoneMac string, register bool)
 		},
 	}
 
-	response, err = w.sendMessage(wizMessage)
+	response, err = w.sendMessage(ctx, wizMessage)
 	return response, err
 }
 
 // Pulse generate a pulse of light to locate the bulb with ease
 func (w *WizClient) Pulse() (response wizgotypes.WizMessageResponse, err error) {
+	return w.PulseContext(context.Background())
+}
+
+// PulseContext is like Pulse but honours the deadline and cancellation of the given context
+func (w *WizClient) PulseContext(ctx context.Context) (response wizgotypes.WizMessageResponse, err error) {
 
 	wizMessage := wizgotypes.WizMessage{
-		Id:     1,
 		Method: "pulse",
 		Params: wizgotypes.WizMessageParams{
 			"delta":    -100,
@@ -240,149 +684,171 @@ func (w *WizClient) Pulse() (response wizgotypes.WizMessageResponse, err error)
 		},
 	}
 
-	response, err = w.sendMessage(wizMessage)
+	response, err = w.sendMessage(ctx, wizMessage)
 	return response, err
 }
 
 // IsRgb return true when the device have RGB, cool white and warm white LEDs.
 // These type of bulbs can support all the light modes provided by WiZ
 func (w *WizClient) IsRgb() (bool, error) {
+	return w.IsRgbContext(context.Background())
+}
 
-	configResp, err := w.GetSystemConfig()
-	if err != nil {
-		return false, errors.New(fmt.Sprintf(SystemConfigNotAvailableErrorMessage, err))
-	}
-
-	if !strings.Contains(configResp.Result.ModuleName, "RGB") {
-		return false, nil
-	}
-
-	return true, nil
+// IsRgbContext is like IsRgb but honours the deadline and cancellation of the given context
+func (w *WizClient) IsRgbContext(ctx context.Context) (bool, error) {
+	return w.isClass(ctx, BulbClassRgb)
 }
 
 // IsTw return true when the device have cool white and warm white LEDs.
 // These type of devices support most static light modes + CCT control
 func (w *WizClient) IsTw() (bool, error) {
+	return w.IsTwContext(context.Background())
+}
 
-	configResp, err := w.GetSystemConfig()
-	if err != nil {
-		return false, errors.New(fmt.Sprintf(SystemConfigNotAvailableErrorMessage, err))
-	}
-
-	if !strings.Contains(configResp.Result.ModuleName, "TW") {
-		return false, nil
-	}
-
-	return true, nil
+// IsTwContext is like IsTw but honours the deadline and cancellation of the given context
+func (w *WizClient) IsTwContext(ctx context.Context) (bool, error) {
+	return w.isClass(ctx, BulbClassTw)
 }
 
 // IsDw return true when the device have only dimmable white LEDs.
 // These type of devices support only dimming and some light modes
 func (w *WizClient) IsDw() (bool, error) {
+	return w.IsDwContext(context.Background())
+}
 
-	configResp, err := w.GetSystemConfig()
-	if err != nil {
-		return false, errors.New(fmt.Sprintf(SystemConfigNotAvailableErrorMessage, err))
-	}
+// IsDwContext is like IsDw but honours the deadline and cancellation of the given context
+func (w *WizClient) IsDwContext(ctx context.Context) (bool, error) {
+	return w.isClass(ctx, BulbClassDw)
+}
 
-	if !strings.Contains(configResp.Result.ModuleName, "DW") {
-		return false, nil
+// isClass return true when the device belongs to the given class
+func (w *WizClient) isClass(ctx context.Context, class BulbClass) (bool, error) {
+
+	capabilities, err := w.CapabilitiesContext(ctx)
+	if err != nil {
+		return false, wrapError(SystemConfigNotAvailableErrorMessage, err)
 	}
 
-	return true, nil
+	return capabilities.Class == class, nil
 }
 
-// IsSceneAvailable todo
+// IsSceneAvailable return true when the scene can be set on the device, depending on its type: RGB, TW, DW
 func (w *WizClient) IsSceneAvailable(sceneId int) (available bool, err error) {
+	return w.IsSceneAvailableContext(context.Background(), sceneId)
+}
 
-	isRgbDevice, err := w.IsRgb()
-	if err != nil {
-		return false, errors.New(fmt.Sprintf(DeviceTypeNotFoundErrorMessage, err))
-	}
+// IsSceneAvailableContext is like IsSceneAvailable but honours the deadline and cancellation of the given context
+func (w *WizClient) IsSceneAvailableContext(ctx context.Context, sceneId int) (available bool, err error) {
 
-	if isRgbDevice && !slices.Contains(maps.Keys(WizScenes), sceneId) {
-		return false, nil
-	}
-
-	isTwDevice, err := w.IsTw()
+	capabilities, err := w.CapabilitiesContext(ctx)
 	if err != nil {
-		return false, errors.New(fmt.Sprintf(DeviceTypeNotFoundErrorMessage, err))
+		return false, wrapError(DeviceTypeNotFoundErrorMessage, err)
 	}
 
-	if isTwDevice && !slices.Contains(WizTwScenes, sceneId) {
-		return false, nil
-	}
+	return capabilities.SupportsScene(sceneId), nil
+}
 
-	isDwDevice, err := w.IsDw()
+// checkSceneAvailable return an error when the scene is not available on the device, or it can not be checked
+func (w *WizClient) checkSceneAvailable(ctx context.Context, sceneId int) error {
+
+	isSceneAvailable, err := w.IsSceneAvailableContext(ctx, sceneId)
 	if err != nil {
-		return false, errors.New(fmt.Sprintf(DeviceTypeNotFoundErrorMessage, err))
+		return wrapError(SceneNotAvailableErrorMessage, err)
 	}
 
-	if isDwDevice && !slices.Contains(WizDwScenes, sceneId) {
-		return false, nil
+	// The device can not play it, so it is matched by ErrNotSupported
+	if !isSceneAvailable {
+		return &wrappedError{message: fmt.Sprintf(SceneNotAvailableErrorMessage, strconv.Itoa(sceneId)), cause: ErrNotSupported}
 	}
 
-	return true, nil
+	return nil
 }
 
-// TurnOn turns on the device
+// TurnOn turns on the device. After a fade out to off, see Transition, it comes back at the brightness the fade started at
 func (w *WizClient) TurnOn() (response wizgotypes.WizMessageResponse, err error) {
+	return w.TurnOnContext(context.Background())
+}
+
+// TurnOnContext is like TurnOn but honours the deadline and cancellation of the given context
+func (w *WizClient) TurnOnContext(ctx context.Context) (response wizgotypes.WizMessageResponse, err error) {
 
 	wizMessage := wizgotypes.WizMessage{
-		Id:     1,
 		Method: "setState",
 		Params: wizgotypes.WizMessageParams{
 			"state": true,
 		},
 	}
 
-	response, err = w.sendMessage(wizMessage)
+	// The brightness is kept for the next try when the device does not get it
+	if dimming := w.dimmingOnTurnOn.Swap(0); dimming != 0 {
+		response, err = w.SetPilotContext(ctx, CreatePilotBuilder().State(true).Brightness(int(dimming)))
+		if err != nil {
+			w.dimmingOnTurnOn.CompareAndSwap(0, dimming)
+		}
+		return response, err
+	}
+
+	response, err = w.sendMessage(ctx, wizMessage)
 	return response, err
 }
 
 // TurnOff turns off the device
 func (w *WizClient) TurnOff() (response wizgotypes.WizMessageResponse, err error) {
+	return w.TurnOffContext(context.Background())
+}
+
+// TurnOffContext is like TurnOff but honours the deadline and cancellation of the given context
+func (w *WizClient) TurnOffContext(ctx context.Context) (response wizgotypes.WizMessageResponse, err error) {
 
 	wizMessage := wizgotypes.WizMessage{
-		Id:     1,
 		Method: "setState",
 		Params: wizgotypes.WizMessageParams{
 			"state": false,
 		},
 	}
 
-	response, err = w.sendMessage(wizMessage)
+	response, err = w.sendMessage(ctx, wizMessage)
 	return response, err
 }
 
 // SetBrightness change the device's brightness (10-100)
 func (w *WizClient) SetBrightness(brightness int) (response wizgotypes.WizMessageResponse, err error) {
+	return w.SetBrightnessContext(context.Background(), brightness)
+}
 
-	if brightness < 10 || brightness > 100 {
-		return response, errors.New(BrithnessRangeMessage)
+// SetBrightnessContext is like SetBrightness but honours the deadline and cancellation of the given context
+func (w *WizClient) SetBrightnessContext(ctx context.Context, brightness int) (response wizgotypes.WizMessageResponse, err error) {
+
+	err = validateBrightness(brightness)
+	if err != nil {
+		return response, err
 	}
 
 	wizMessage := wizgotypes.WizMessage{
-		Id:     1,
 		Method: "setPilot",
 		Params: wizgotypes.WizMessageParams{
 			"dimming": brightness,
 		},
 	}
 
-	response, err = w.sendMessage(wizMessage)
+	response, err = w.sendMessage(ctx, wizMessage)
 	return response, err
 }
 
 // SetRgb set the color for the device (3 x 0-255)
 func (w *WizClient) SetRgb(r, g, b int) (response wizgotypes.WizMessageResponse, err error) {
+	return w.SetRgbContext(context.Background(), r, g, b)
+}
+
+// SetRgbContext is like SetRgb but honours the deadline and cancellation of the given context
+func (w *WizClient) SetRgbContext(ctx context.Context, r, g, b int) (response wizgotypes.WizMessageResponse, err error) {
 
-	if r < 0 || r > 255 || g < 0 || g > 255 || b < 0 || b > 255 {
-		return response, errors.New(LedRangeMessage)
+	err = validateRgb(r, g, b)
+	if err != nil {
+		return response, err
 	}
 
 	wizMessage := wizgotypes.WizMessage{
-		Id:     1,
 		Method: "setPilot",
 		Params: wizgotypes.WizMessageParams{
 			"r": r,
@@ -391,122 +857,151 @@ func (w *WizClient) SetRgb(r, g, b int) (response wizgotypes.WizMessageResponse,
 		},
 	}
 
-	response, err = w.sendMessage(wizMessage)
+	response, err = w.sendMessage(ctx, wizMessage)
 	return response, err
 }
 
 // SetColdWhite set the level of light given by cold white LEDs (0-255)
 func (w *WizClient) SetColdWhite(coldWhite int) (response wizgotypes.WizMessageResponse, err error) {
+	return w.SetColdWhiteContext(context.Background(), coldWhite)
+}
+
+// SetColdWhiteContext is like SetColdWhite but honours the deadline and cancellation of the given context
+func (w *WizClient) SetColdWhiteContext(ctx context.Context, coldWhite int) (response wizgotypes.WizMessageResponse, err error) {
 
-	if coldWhite < 0 || coldWhite > 255 {
-		return response, errors.New(LedRangeMessage)
+	err = validateLed("c", coldWhite)
+	if err != nil {
+		return response, err
 	}
 
 	wizMessage := wizgotypes.WizMessage{
-		Id:     1,
 		Method: "setPilot",
 		Params: wizgotypes.WizMessageParams{
 			"c": coldWhite,
 		},
 	}
 
-	response, err = w.sendMessage(wizMessage)
+	response, err = w.sendMessage(ctx, wizMessage)
 	return response, err
 }
 
 // SetWarmWhite set the level of light given by warm white LEDs (0-255)
 func (w *WizClient) SetWarmWhite(warmWhite int) (response wizgotypes.WizMessageResponse, err error) {
+	return w.SetWarmWhiteContext(context.Background(), warmWhite)
+}
 
-	if warmWhite < 0 || warmWhite > 255 {
-		return response, errors.New(LedRangeMessage)
+// SetWarmWhiteContext is like SetWarmWhite but honours the deadline and cancellation of the given context
+func (w *WizClient) SetWarmWhiteContext(ctx context.Context, warmWhite int) (response wizgotypes.WizMessageResponse, err error) {
+
+	err = validateLed("w", warmWhite)
+	if err != nil {
+		return response, err
 	}
 
 	wizMessage := wizgotypes.WizMessage{
-		Id:     1,
 		Method: "setPilot",
 		Params: wizgotypes.WizMessageParams{
 			"w": warmWhite,
 		},
 	}
 
-	response, err = w.sendMessage(wizMessage)
+	response, err = w.sendMessage(ctx, wizMessage)
 	return response, err
 }
 
-// SetTemperature set color temperature in kelvin
+// SetTemperature set color temperature in kelvin, within the range advertised by the device
 func (w *WizClient) SetTemperature(temperature int) (response wizgotypes.WizMessageResponse, err error) {
+	return w.SetTemperatureContext(context.Background(), temperature)
+}
 
-	if temperature < 2000 || temperature > 9000 {
-		return response, errors.New(TemperatureRangeMessage)
+// SetTemperatureContext is like SetTemperature but honours the deadline and cancellation of the given context
+func (w *WizClient) SetTemperatureContext(ctx context.Context, temperature int) (response wizgotypes.WizMessageResponse, err error) {
+
+	err = w.validateDeviceTemperature(ctx, temperature)
+	if err != nil {
+		return response, err
 	}
 
 	wizMessage := wizgotypes.WizMessage{
-		Id:     1,
 		Method: "setPilot",
 		Params: wizgotypes.WizMessageParams{
 			"temp": temperature,
 		},
 	}
 
-	response, err = w.sendMessage(wizMessage)
+	response, err = w.sendMessage(ctx, wizMessage)
 	return response, err
 }
 
 // SetSpeed set changing speed between the colors in a scene
 func (w *WizClient) SetSpeed(speed int) (response wizgotypes.WizMessageResponse, err error) {
+	return w.SetSpeedContext(context.Background(), speed)
+}
+
+// SetSpeedContext is like SetSpeed but honours the deadline and cancellation of the given context
+func (w *WizClient) SetSpeedContext(ctx context.Context, speed int) (response wizgotypes.WizMessageResponse, err error) {
 
-	if speed < 10 || speed > 200 {
-		return response, errors.New(SpeedRangeMessage)
+	err = validateSpeed(speed)
+	if err != nil {
+		return response, err
 	}
 
 	wizMessage := wizgotypes.WizMessage{
-		Id:     1,
 		Method: "setPilot",
 		Params: wizgotypes.WizMessageParams{
 			"speed": speed,
 		},
 	}
 
-	response, err = w.sendMessage(wizMessage)
+	response, err = w.sendMessage(ctx, wizMessage)
 	return response, err
 }
 
-// TODO
+// SetRatio set the ratio between the up and down light on dual-head devices (1-100)
 func (w *WizClient) SetRatio(ratio int) (response wizgotypes.WizMessageResponse, err error) {
+	return w.SetRatioContext(context.Background(), ratio)
+}
+
+// SetRatioContext is like SetRatio but honours the deadline and cancellation of the given context
+func (w *WizClient) SetRatioContext(ctx context.Context, ratio int) (response wizgotypes.WizMessageResponse, err error) {
 
-	if ratio < 1 || ratio > 100 {
-		return response, errors.New(RatioRangeMessage)
+	err = validateRatio(ratio)
+	if err != nil {
+		return response, err
 	}
 
 	wizMessage := wizgotypes.WizMessage{
-		Id:     1,
 		Method: "setPilot",
 		Params: wizgotypes.WizMessageParams{
 			"ratio": ratio,
 		},
 	}
 
-	response, err = w.sendMessage(wizMessage)
+	response, err = w.sendMessage(ctx, wizMessage)
 	return response, err
 }
 
 // SetScene set a scene by its ID. The availability of IDs depend on the type of bulb: RBG, TW, DW
 func (w *WizClient) SetScene(sceneId int) (response wizgotypes.WizMessageResponse, err error) {
+	return w.SetSceneContext(context.Background(), sceneId)
+}
 
-	isSceneAvailable, err := w.IsSceneAvailable(sceneId)
-	if err != nil || !isSceneAvailable {
-		return response, errors.New(fmt.Sprintf(SceneNotAvailableErrorMessage, err))
+// SetSceneContext is like SetScene but honours the deadline and cancellation of the given context
+func (w *WizClient) SetSceneContext(ctx context.Context, sceneId int) (response wizgotypes.WizMessageResponse, err error) {
+
+	err = w.checkSceneAvailable(ctx, sceneId)
+	if err != nil {
+		return response, err
 	}
 
 	wizMessage := wizgotypes.WizMessage{
-		Id:     1,
 		Method: "setPilot",
 		Params: wizgotypes.WizMessageParams{
 			"sceneId": sceneId,
 		},
 	}
 
-	response, err = w.sendMessage(wizMessage)
+	response, err = w.sendMessage(ctx, wizMessage)
 	return response, err
 }
 
@@ -514,5 +1009,10 @@ func (w *WizClient) SetScene(sceneId int) (response wizgotypes.WizMessageRespons
 // TODO: Implementation pending as it requires deeper reverse engineering to figure out what is needed
 // There are three potential methods involved: setSchdPset, setSchd, setPilot
 func (w *WizClient) SetRhythm(rhythmId int) (response wizgotypes.WizMessageResponse, err error) {
+	return w.SetRhythmContext(context.Background(), rhythmId)
+}
+
+// SetRhythmContext is like SetRhythm but honours the deadline and cancellation of the given context
+func (w *WizClient) SetRhythmContext(ctx context.Context, rhythmId int) (response wizgotypes.WizMessageResponse, err error) {
 	return response, err
 }
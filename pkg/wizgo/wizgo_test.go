@@ -0,0 +1,162 @@
+package wizgo
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	wizgotypes "github.com/achetronic/wizgo/api/types"
+	"github.com/achetronic/wizgo/pkg/emulator"
+)
+
+// startEmulator starts an emulated device that is closed when the test ends
+func startEmulator(t *testing.T, options emulator.Options) *emulator.Emulator {
+	t.Helper()
+
+	device, err := emulator.Start(options)
+	if err != nil {
+		t.Fatalf("error starting the emulator: %s", err)
+	}
+	t.Cleanup(func() { _ = device.Close() })
+
+	return device
+}
+
+// createClient creates a client for the emulated device that is closed when the test ends
+func createClient(t *testing.T, device *emulator.Emulator, options WizClientOptions) *WizClient {
+	t.Helper()
+
+	wizClient, err := CreateWizClientWithOptions(device.Host(), device.Port(), options)
+	if err != nil {
+		t.Fatalf("error creating the client: %s", err)
+	}
+	t.Cleanup(func() { _ = wizClient.Close() })
+
+	return &wizClient
+}
+
+// receivedMessages return the messages with the given method received by the emulated device
+func receivedMessages(device *emulator.Emulator, method string) (messages []wizgotypes.WizMessage) {
+	for _, message := range device.Messages() {
+		if message.Method == method {
+			messages = append(messages, message)
+		}
+	}
+	return messages
+}
+
+// intPointer return a pointer to the given value, to fill the optional fields of a PilotState
+func intPointer(value int) *int {
+	return &value
+}
+
+// boolPointer return a pointer to the given value, to fill the optional fields of a PilotState
+func boolPointer(value bool) *bool {
+	return &value
+}
+
+// intText return the value of an optional field, or '-' when it was not reported
+func intText(value *int) string {
+	if value == nil {
+		return "-"
+	}
+	return fmt.Sprintf("%d", *value)
+}
+
+func TestConcurrentRequestsGetTheirOwnAnswers(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		lossRate float64
+		latency  time.Duration
+	}{
+		{name: "reliable network"},
+		{name: "slow device", latency: 20 * time.Millisecond},
+		{name: "lossy network", lossRate: 0.3, latency: 5 * time.Millisecond},
+	}
+
+	methods := []string{"getPilot", "getSystemConfig", "getUserConfig", "getDevInfo"}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			device := startEmulator(t, emulator.Options{LossRate: test.lossRate, Latency: test.latency})
+			wizClient := createClient(t, device, WizClientOptions{
+				Timeout:     5 * time.Second,
+				RetryPolicy: RetryPolicy{Attempts: 20, AttemptTimeout: 100 * time.Millisecond},
+			})
+
+			responses := make([]wizgotypes.WizMessageResponse, 40)
+			errs := make([]error, len(responses))
+
+			var wg sync.WaitGroup
+			for index := range responses {
+				wg.Add(1)
+				go func(index int) {
+					defer wg.Done()
+					message := wizgotypes.WizMessage{Method: methods[index%len(methods)]}
+					responses[index], errs[index] = wizClient.sendMessage(context.Background(), message)
+				}(index)
+			}
+			wg.Wait()
+
+			ids := map[int]bool{}
+			for index, response := range responses {
+				if errs[index] != nil {
+					t.Fatalf("request %d failed: %s", index, errs[index])
+				}
+				if want := methods[index%len(methods)]; response.Method != want {
+					t.Errorf("request %d for '%s' got the answer to '%s'", index, want, response.Method)
+				}
+				if ids[response.Id] {
+					t.Errorf("answer with id %d given to more than one request", response.Id)
+				}
+				ids[response.Id] = true
+			}
+		})
+	}
+}
+
+func TestDispatchResponse(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		response wizgotypes.WizMessageResponse
+		answered int // answered is the id of the request receiving the answer, zero when it is discarded
+	}{
+		{name: "id echoed", response: wizgotypes.WizMessageResponse{Id: 3, Method: "getPilot"}, answered: 3},
+		{name: "id not echoed", response: wizgotypes.WizMessageResponse{Method: "getPilot"}, answered: 2},
+		{name: "late duplicate", response: wizgotypes.WizMessageResponse{Id: 1, Method: "getPilot"}},
+		{name: "id of another method", response: wizgotypes.WizMessageResponse{Id: 4, Method: "getPilot"}},
+		{name: "nothing pending for the method", response: wizgotypes.WizMessageResponse{Method: "getDevInfo"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pendingRequests := map[int]*pendingRequest{
+				2: {method: "getPilot", answer: make(chan []byte, 1)},
+				3: {method: "getPilot", answer: make(chan []byte, 1)},
+				4: {method: "getSystemConfig", answer: make(chan []byte, 1)},
+			}
+			wizClient := &WizClient{wizConnection: &wizConnection{pendingRequests: map[int]*pendingRequest{}}}
+			for id, request := range pendingRequests {
+				wizClient.pendingRequests[id] = request
+			}
+
+			wizClient.dispatchResponse(test.response, []byte(fmt.Sprintf(`{"id":%d}`, test.response.Id)))
+
+			for id, request := range pendingRequests {
+				_, stillPending := wizClient.pendingRequests[id]
+				answered := len(request.answer) > 0
+
+				if id == test.answered && (!answered || stillPending) {
+					t.Errorf("request %d did not receive the answer", id)
+				}
+				if id != test.answered && (answered || !stillPending) {
+					t.Errorf("request %d received an answer that was not for it", id)
+				}
+			}
+		})
+	}
+}